@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+
+	"encoding/binary"
+	"hash/crc32"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+type pngChunk struct {
+	typ string
+	data []byte
+}
+
+// 拆出png檔內的chunk
+func readChunks(buf []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(buf, pngHeader) {
+		return nil, errors.New("not png")
+	}
+	buf = buf[len(pngHeader):]
+
+	list := make([]pngChunk, 0, 4)
+	for len(buf) >= 12 {
+		sz := int(binary.BigEndian.Uint32(buf[0:4]))
+		if len(buf) < 12 + sz {
+			return nil, errors.New("short chunk")
+		}
+		list = append(list, pngChunk{
+			typ: string(buf[4:8]),
+			data: buf[8:8+sz],
+		})
+		buf = buf[12+sz:]
+	}
+	return list, nil
+}
+
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(len(data)))
+	copy(hdr[4:8], typ)
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:8])
+	crc.Write(data)
+
+	var tail [4]byte
+	binary.BigEndian.PutUint32(tail[:], crc.Sum32())
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(tail[:])
+	return err
+}
+
+// 每個frame先用image/png編碼, 再把IDAT搬進fcTL/fdAT
+// 所有frame需同尺寸且同palette
+func encodeAPNG(w io.Writer, frames []*image.Paletted, delayMs int, loop int) error {
+	if len(frames) == 0 {
+		return errors.New("no frame")
+	}
+
+	enc := &png.Encoder{CompressionLevel: png.BestCompression}
+	seq := uint32(0)
+	for i, img := range frames {
+		var buf bytes.Buffer
+		err := enc.Encode(&buf, img)
+		if err != nil {
+			return err
+		}
+		chunks, err := readChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			if _, err := w.Write(pngHeader); err != nil {
+				return err
+			}
+			for _, c := range chunks {
+				if c.typ != "IHDR" {
+					continue
+				}
+				if err := writeChunk(w, c.typ, c.data); err != nil {
+					return err
+				}
+			}
+
+			actl := make([]byte, 8)
+			binary.BigEndian.PutUint32(actl[0:4], uint32(len(frames)))
+			binary.BigEndian.PutUint32(actl[4:8], uint32(loop))
+			if err := writeChunk(w, "acTL", actl); err != nil {
+				return err
+			}
+
+			for _, c := range chunks {
+				switch c.typ {
+				case "PLTE", "tRNS":
+					if err := writeChunk(w, c.typ, c.data); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		b := img.Bounds()
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:4], seq)
+		binary.BigEndian.PutUint32(fctl[4:8], uint32(b.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:12], uint32(b.Dy()))
+		// x_offset, y_offset = 0
+		num, den := apngDelay(delayMs)
+		binary.BigEndian.PutUint16(fctl[20:22], num)
+		binary.BigEndian.PutUint16(fctl[22:24], den)
+		fctl[24] = 1 // dispose_op: APNG_DISPOSE_OP_BACKGROUND
+		fctl[25] = 0 // blend_op: APNG_BLEND_OP_SOURCE
+		if err := writeChunk(w, "fcTL", fctl); err != nil {
+			return err
+		}
+		seq++
+
+		for _, c := range chunks {
+			if c.typ != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				if err := writeChunk(w, "IDAT", c.data); err != nil {
+					return err
+				}
+				continue
+			}
+			fdat := make([]byte, 4 + len(c.data))
+			binary.BigEndian.PutUint32(fdat[0:4], seq)
+			copy(fdat[4:], c.data)
+			if err := writeChunk(w, "fdAT", fdat); err != nil {
+				return err
+			}
+			seq++
+		}
+	}
+
+	return writeChunk(w, "IEND", nil)
+}
+
+// delay_num/delay_den 皆為 uint16: 超過 65535 ms 時改以 1/100 秒計, 再超過就取最大值
+func apngDelay(delayMs int) (uint16, uint16) {
+	switch {
+	case delayMs < 0:
+		return 0, 1000
+	case delayMs <= 0xffff:
+		return uint16(delayMs), 1000
+	case delayMs / 10 <= 0xffff:
+		return uint16(delayMs / 10), 100
+	}
+	return 0xffff, 100
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"image"
+)
+
+// 5x7 點陣字型, 只收錄時間戳記會用到的字元
+var glyphs = map[rune][7]uint8{
+	'0': {0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e},
+	'1': {0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'2': {0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f},
+	'3': {0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e},
+	'4': {0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02},
+	'5': {0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e},
+	'6': {0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e},
+	'7': {0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8': {0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e},
+	'9': {0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c},
+	'-': {0x00, 0x00, 0x00, 0x1f, 0x00, 0x00, 0x00},
+	':': {0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x0c, 0x00},
+	'+': {0x00, 0x04, 0x04, 0x1f, 0x04, 0x04, 0x00},
+	'.': {0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x0c},
+	'C': {0x0e, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0e},
+	'T': {0x1f, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'U': {0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e},
+	' ': {},
+}
+
+const (
+	glyphW = 5
+	glyphH = 7
+)
+
+// 畫字串到左下角, 底色fg/bg為palette index
+func drawLabel(img *image.Paletted, str string, scale int, fg uint8, bg uint8) {
+	if scale < 1 {
+		scale = 1
+	}
+	pad := 2 * scale
+	tw := (len(str) * (glyphW + 1) - 1) * scale
+	th := glyphH * scale
+	b := img.Bounds()
+	x0 := b.Min.X + pad
+	y0 := b.Max.Y - pad - th
+
+	// 底色
+	for y := y0 - pad; y < y0 + th + pad; y++ {
+		for x := x0 - pad; x < x0 + tw + pad; x++ {
+			if (image.Point{x, y}).In(b) {
+				img.SetColorIndex(x, y, bg)
+			}
+		}
+	}
+
+	for i, c := range str {
+		g, ok := glyphs[c]
+		if !ok {
+			continue
+		}
+		gx := x0 + i * (glyphW + 1) * scale
+		for row := 0; row < glyphH; row++ {
+			for col := 0; col < glyphW; col++ {
+				if g[row] & (1 << uint(glyphW - 1 - col)) == 0 {
+					continue
+				}
+				for dy := 0; dy < scale; dy++ {
+					for dx := 0; dx < scale; dx++ {
+						x := gx + col * scale + dx
+						y := y0 + row * scale + dy
+						if (image.Point{x, y}).In(b) {
+							img.SetColorIndex(x, y, fg)
+						}
+					}
+				}
+			}
+		}
+	}
+}
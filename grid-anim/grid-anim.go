@@ -0,0 +1,386 @@
+package main
+
+/*
+* 讀取轉換後的 index.json, 將各時間的格點資料依序畫成動畫 (GIF/APNG)
+* 每個frame只畫一個變數, 並在左下角加上UTC+8的時間
+* 供社群貼文/LINE訊息使用
+*/
+
+import (
+	"flag"
+	"log"
+	"time"
+	"fmt"
+	"errors"
+
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"strconv"
+	"path/filepath"
+
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+var (
+	inDir = flag.String("dir", "json/", "path of index.json & grid files")
+	varName = flag.String("var", "浪高", "variable to draw")
+	outFile = flag.String("o", "anim.gif", "output file (.gif or .png/.apng)")
+	outFmt = flag.String("fmt", "", "output format: gif, apng (default by file ext)")
+
+	delay = flag.Int("delay", 500, "frame delay in ms")
+	loop = flag.Int("loop", 0, "loop count, 0 == forever")
+	width = flag.Int("width", 0, "output width, 0 == keep ratio / grid size")
+	height = flag.Int("height", 0, "output height, 0 == keep ratio / grid size")
+	cropStr = flag.String("crop", "", "crop by lon/lat: minLon,minLat,maxLon,maxLat (119,21.5,122.5,25.5)")
+	rangeStr = flag.String("range", "", "color range: min,max (default from index.json drange)")
+
+	verbosity = flag.Int("v", 3, "verbosity for app (1 error, 2 warn, 3 info, 4 debug)")
+	logFormat = flag.String("log", "text", "log format, text or json")
+)
+
+const (
+	idxNaN = 0
+	idxBlack = 1
+	idxWhite = 2
+	idxRamp = 3 // 3 ~ 255 色階
+)
+
+type IndexFile struct {
+	TimeUTC time.Time `json:"timeUTC"`
+	Name string `json:"name"`
+
+	DataRange map[string][]lib.JsonFloat `json:"drange"`
+}
+
+type sortByTime []*IndexFile
+func (s sortByTime) Len() int      { return len(s) }
+func (s sortByTime) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sortByTime) Less(i, j int) bool { return s[i].TimeUTC.Before(s[j].TimeUTC) }
+
+func main() {
+	flag.Parse()
+	if err := lib.SetupLog(*verbosity, *logFormat); err != nil {
+		log.Fatalln(err)
+	}
+
+	list, err := readIndex(filepath.Join(*inDir, "index.json"))
+	if err != nil {
+		Vln(2, "[index]err", err)
+		return
+	}
+	Vln(3, "[index]count", len(list))
+
+	vmin, vmax, err := parseRange(*rangeStr, list, *varName)
+	if err != nil {
+		Vln(2, "[range]err", err)
+		return
+	}
+	Vln(3, "[range]", *varName, vmin, vmax)
+
+	loc := time.FixedZone("UTC+8", +8*60*60)
+	pal := makePalette()
+	frames := make([]*image.Paletted, 0, len(list))
+	// 裁切範圍及輸出尺寸以第一個grid決定, 所有frame必須同尺寸
+	var ref *lib.VectorGrid
+	var cp *cropBox
+	var w, h int
+	for _, item := range list {
+		if item.Name == "" { // 略過的時間 (-partial skip)
+			Vln(3, "[grid]skipped", item.TimeUTC)
+			continue
+		}
+		grid, err := lib.ReadGridFile(filepath.Join(*inDir, item.Name))
+		if err != nil {
+			Vln(2, "[grid]err", item.Name, err)
+			continue
+		}
+		if _, ok := grid.Data[*varName]; !ok {
+			Vln(2, "[grid]no variable", item.Name, *varName)
+			continue
+		}
+
+		if ref == nil {
+			cp, err = parseCrop(*cropStr, grid)
+			if err != nil {
+				Vln(2, "[crop]err", err)
+				return
+			}
+			w, h = outSize(*width, *height, cp.Dx(), cp.Dy())
+			ref = grid
+		} else if !sameGeometry(ref, grid) {
+			Vln(2, "[grid]different grid, skipped", item.Name, grid.Nx, grid.Ny)
+			continue
+		}
+
+		label := item.TimeUTC.In(loc).Format("2006-01-02 15:04") + " UTC+8"
+		img := renderFrame(grid, *varName, cp, w, h, vmin, vmax, pal)
+		drawLabel(img, label, w / 320 + 1, idxWhite, idxBlack)
+		frames = append(frames, img)
+		Vln(4, "[frame]", item.Name, label, w, h)
+	}
+	if len(frames) == 0 {
+		Vln(2, "[frame]nothing to draw")
+		return
+	}
+
+	format := *outFmt
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(*outFile)) {
+		case ".png", ".apng":
+			format = "apng"
+		default:
+			format = "gif"
+		}
+	}
+
+	of, err := os.OpenFile(*outFile, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		Vln(2, "[open]err", *outFile, err)
+		return
+	}
+	defer of.Close()
+
+	switch format {
+	case "apng":
+		err = encodeAPNG(of, frames, *delay, *loop)
+	case "gif":
+		err = encodeGIF(of, frames, *delay, *loop)
+	default:
+		err = errors.New("unknown format: " + format)
+	}
+	if err != nil {
+		Vln(2, "[write]err", *outFile, err)
+		return
+	}
+	Vln(3, "[write]ok", *outFile, format, len(frames))
+}
+
+func readIndex(fp string) ([]*IndexFile, error) {
+	fd, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	list := make([]*IndexFile, 0, 32)
+	err = json.NewDecoder(fd).Decode(&list)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sortByTime(list))
+	return list, nil
+}
+
+// 未指定就取所有frame的 drange 聯集
+func parseRange(str string, list []*IndexFile, key string) (float32, float32, error) {
+	if str != "" {
+		v := strings.Split(str, ",")
+		if len(v) != 2 {
+			return 0, 0, errors.New("range should be min,max")
+		}
+		vmin, err := strconv.ParseFloat(strings.TrimSpace(v[0]), 32)
+		if err != nil {
+			return 0, 0, err
+		}
+		vmax, err := strconv.ParseFloat(strings.TrimSpace(v[1]), 32)
+		if err != nil {
+			return 0, 0, err
+		}
+		return float32(vmin), float32(vmax), nil
+	}
+
+	var vmin, vmax float32
+	found := false
+	for _, item := range list {
+		minMax, ok := item.DataRange[key]
+		if !ok || len(minMax) != 2 {
+			continue
+		}
+		if !found || float32(minMax[0]) < vmin {
+			vmin = float32(minMax[0])
+		}
+		if !found || float32(minMax[1]) > vmax {
+			vmax = float32(minMax[1])
+		}
+		found = true
+	}
+	if !found {
+		return 0, 0, fmt.Errorf("no drange for %v in index.json, use -range", key)
+	}
+	return vmin, vmax, nil
+}
+
+// 格點範圍, row以南方為0, 皆包含邊界
+type cropBox struct {
+	col0, col1 int
+	row0, row1 int
+}
+
+func (cp *cropBox) Dx() int {
+	return cp.col1 - cp.col0 + 1
+}
+
+func (cp *cropBox) Dy() int {
+	return cp.row1 - cp.row0 + 1
+}
+
+func parseCrop(str string, grid *lib.VectorGrid) (*cropBox, error) {
+	cp := &cropBox{0, grid.Nx - 1, 0, grid.Ny - 1}
+	if grid.Nx < 1 || grid.Ny < 1 {
+		return nil, errors.New("empty grid")
+	}
+	if str == "" {
+		return cp, nil
+	}
+
+	v := strings.Split(str, ",")
+	if len(v) != 4 {
+		return nil, errors.New("crop should be minLon,minLat,maxLon,maxLat")
+	}
+	var bbox [4]float64
+	for i, s := range v {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 32)
+		if err != nil {
+			return nil, err
+		}
+		bbox[i] = f
+	}
+
+	dx := float64(grid.Dx())
+	dy := float64(grid.Dy())
+	if dx > 0 {
+		cp.col0 = clamp(int((bbox[0] - float64(grid.Lo1)) / dx + 0.5), 0, grid.Nx - 1)
+		cp.col1 = clamp(int((bbox[2] - float64(grid.Lo1)) / dx + 0.5), 0, grid.Nx - 1)
+	}
+	if dy > 0 {
+		cp.row0 = clamp(int((bbox[1] - float64(grid.La2)) / dy + 0.5), 0, grid.Ny - 1)
+		cp.row1 = clamp(int((bbox[3] - float64(grid.La2)) / dy + 0.5), 0, grid.Ny - 1)
+	}
+	if cp.col1 < cp.col0 || cp.row1 < cp.row0 {
+		return nil, errors.New("crop out of grid")
+	}
+	return cp, nil
+}
+
+// 範圍及格數相同, 同一個cropBox才適用
+func sameGeometry(a *lib.VectorGrid, b *lib.VectorGrid) bool {
+	return a.Nx == b.Nx && a.Ny == b.Ny && a.Lo1 == b.Lo1 && a.La1 == b.La1 && a.Lo2 == b.Lo2 && a.La2 == b.La2
+}
+
+func clamp(v int, lo int, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func outSize(w int, h int, cw int, ch int) (int, int) {
+	switch {
+	case w <= 0 && h <= 0:
+		return cw, ch
+	case h <= 0:
+		return w, (w * ch + cw / 2) / cw
+	case w <= 0:
+		return (h * cw + ch / 2) / ch, h
+	}
+	return w, h
+}
+
+// 藍 >> 青 >> 綠 >> 黃 >> 紅
+func makePalette() color.Palette {
+	stops := []color.RGBA{
+		{0, 0, 255, 255},
+		{0, 255, 255, 255},
+		{0, 255, 0, 255},
+		{255, 255, 0, 255},
+		{255, 0, 0, 255},
+	}
+
+	pal := make(color.Palette, 256)
+	pal[idxNaN] = color.RGBA{0, 0, 0, 0}
+	pal[idxBlack] = color.RGBA{0, 0, 0, 255}
+	pal[idxWhite] = color.RGBA{255, 255, 255, 255}
+
+	n := len(pal) - idxRamp
+	for i := 0; i < n; i++ {
+		t := float64(i) / float64(n - 1) * float64(len(stops) - 1)
+		s := int(t)
+		if s >= len(stops) - 1 {
+			s = len(stops) - 2
+		}
+		f := t - float64(s)
+		c0, c1 := stops[s], stops[s+1]
+		pal[idxRamp + i] = color.RGBA{
+			uint8(float64(c0.R) + (float64(c1.R) - float64(c0.R)) * f),
+			uint8(float64(c0.G) + (float64(c1.G) - float64(c0.G)) * f),
+			uint8(float64(c0.B) + (float64(c1.B) - float64(c0.B)) * f),
+			255,
+		}
+	}
+	return pal
+}
+
+// 最近鄰取樣, 圖片上方為北
+func renderFrame(grid *lib.VectorGrid, key string, cp *cropBox, w int, h int, vmin float32, vmax float32, pal color.Palette) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, w, h), pal)
+	n := len(pal) - idxRamp
+	span := vmax - vmin
+	for y := 0; y < h; y++ {
+		row := cp.row1 - y * cp.Dy() / h
+		for x := 0; x < w; x++ {
+			col := cp.col0 + x * cp.Dx() / w
+			v := grid.At(key, row, col)
+			if v.IsNaN() {
+				img.SetColorIndex(x, y, idxNaN)
+				continue
+			}
+			t := float32(0.5)
+			if span > 0 {
+				t = (float32(v) - vmin) / span
+			}
+			i := clamp(int(t * float32(n - 1) + 0.5), 0, n - 1)
+			img.SetColorIndex(x, y, uint8(idxRamp + i))
+		}
+	}
+	return img
+}
+
+func encodeGIF(w io.Writer, frames []*image.Paletted, delayMs int, loop int) error {
+	anim := &gif.GIF{
+		Image: frames,
+		Delay: make([]int, len(frames)),
+		Disposal: make([]byte, len(frames)),
+		LoopCount: loop,
+	}
+	for i := range frames {
+		anim.Delay[i] = gifDelay(delayMs)
+		anim.Disposal[i] = gif.DisposalBackground
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// 以 1/100 秒計; 瀏覽器把小於 2 (20 ms) 的當成 100 ms, 所以至少 2
+func gifDelay(delayMs int) int {
+	if d := delayMs / 10; d > 2 {
+		return d
+	}
+	return 2
+}
+
+// ==== log ====
+func Vf(level int, format string, v ...interface{}) {
+	lib.Vf(level, format, v...)
+}
+func Vln(level int, v ...interface{}) {
+	lib.Vln(level, v...)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"math"
+	"testing"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+// nx*ny 格點, vals 由南往北逐列 (row 0 為南)
+func testGrid(nx int, ny int, vals ...float64) *lib.VectorGrid {
+	g := lib.NewVectorGrid()
+	g.Nx, g.Ny = nx, ny
+	g.Lo1, g.Lo2 = 120, 120 + float32(nx - 1)
+	g.La1, g.La2 = 20 + float32(ny - 1), 20
+	arr := make([]lib.JsonFloat, len(vals))
+	for i, v := range vals {
+		arr[i] = lib.JsonFloat(v)
+	}
+	g.Data["v"] = arr
+	return g
+}
+
+func fullCrop(g *lib.VectorGrid) *cropBox {
+	return &cropBox{0, g.Nx - 1, 0, g.Ny - 1}
+}
+
+func indexes(img *image.Paletted) [][]uint8 {
+	b := img.Bounds()
+	out := make([][]uint8, b.Dy())
+	for y := range out {
+		out[y] = make([]uint8, b.Dx())
+		for x := range out[y] {
+			out[y][x] = img.ColorIndexAt(x, y)
+		}
+	}
+	return out
+}
+
+func TestPalette(t *testing.T) {
+	pal := makePalette()
+	if len(pal) != 256 {
+		t.Fatalf("%d colors", len(pal))
+	}
+	if _, _, _, a := pal[idxNaN].RGBA(); a != 0 {
+		t.Errorf("NaN color not transparent: %v", pal[idxNaN])
+	}
+	cases := []struct {
+		idx int
+		want color.RGBA
+	}{
+		{idxBlack, color.RGBA{0, 0, 0, 255}},
+		{idxWhite, color.RGBA{255, 255, 255, 255}},
+		{idxRamp, color.RGBA{0, 0, 255, 255}}, // 最小值: 藍
+		{255, color.RGBA{255, 0, 0, 255}}, // 最大值: 紅
+	}
+	for _, tc := range cases {
+		if pal[tc.idx] != tc.want {
+			t.Errorf("pal[%d] = %v, want %v", tc.idx, pal[tc.idx], tc.want)
+		}
+	}
+	// 中間為綠
+	if c := pal[idxRamp + (255 - idxRamp) / 2].(color.RGBA); c.G != 255 || c.R > 8 || c.B > 8 {
+		t.Errorf("middle color %v, want green", c)
+	}
+}
+
+// 最小值/最大值在色階兩端, 超出範圍的截到兩端, NaN 透明, 圖片上方為北
+func TestRenderFrame(t *testing.T) {
+	nan := math.NaN()
+	g := testGrid(3, 2,
+		0, 5, 10, // 南
+		-5, nan, 20) // 北
+	pal := makePalette()
+	img := renderFrame(g, "v", fullCrop(g), 3, 2, 0, 10, pal)
+
+	mid := uint8(idxRamp + (255 - idxRamp + 1) / 2)
+	want := [][]uint8{
+		{idxRamp, idxNaN, 255},
+		{idxRamp, mid, 255},
+	}
+	got := indexes(img)
+	for y := range want {
+		for x := range want[y] {
+			if got[y][x] != want[y][x] {
+				t.Errorf("(%d, %d) = %d, want %d", x, y, got[y][x], want[y][x])
+			}
+		}
+	}
+
+	// 範圍為 0 時全部畫中間色
+	img = renderFrame(g, "v", fullCrop(g), 3, 2, 5, 5, pal)
+	if i := img.ColorIndexAt(0, 1); i != mid {
+		t.Errorf("zero span: %d, want %d", i, mid)
+	}
+	// 沒有這個變數: 全部 NaN
+	img = renderFrame(g, "x", fullCrop(g), 3, 2, 0, 10, pal)
+	if i := img.ColorIndexAt(2, 1); i != idxNaN {
+		t.Errorf("unknown variable: %d", i)
+	}
+}
+
+// 放大時最近鄰取樣; 裁切後只畫範圍內的格點
+func TestRenderScaleCrop(t *testing.T) {
+	g := testGrid(2, 2,
+		0, 10,
+		10, 0)
+	pal := makePalette()
+	img := renderFrame(g, "v", fullCrop(g), 4, 4, 0, 10, pal)
+	lo, hi := uint8(idxRamp), uint8(255)
+	want := [][]uint8{
+		{hi, hi, lo, lo},
+		{hi, hi, lo, lo},
+		{lo, lo, hi, hi},
+		{lo, lo, hi, hi},
+	}
+	got := indexes(img)
+	for y := range want {
+		for x := range want[y] {
+			if got[y][x] != want[y][x] {
+				t.Fatalf("scaled %v, want %v", got, want)
+			}
+		}
+	}
+
+	cp, err := parseCrop("120.6,20.6,121,21", g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Dx() != 1 || cp.Dy() != 1 {
+		t.Fatalf("crop %+v", cp)
+	}
+	img = renderFrame(g, "v", cp, 2, 2, 0, 10, pal)
+	for _, row := range indexes(img) {
+		for _, i := range row {
+			if i != lo {
+				t.Errorf("cropped to the north-east cell (0): %v", indexes(img))
+			}
+		}
+	}
+}
+
+func TestOutSize(t *testing.T) {
+	cases := []struct {
+		w, h, cw, ch int
+		ww, wh int
+	}{
+		{0, 0, 71, 291, 71, 291},
+		{400, 0, 71, 291, 400, 1639},
+		{0, 300, 200, 100, 600, 300},
+		{100, 50, 7, 7, 100, 50},
+	}
+	for _, tc := range cases {
+		if w, h := outSize(tc.w, tc.h, tc.cw, tc.ch); w != tc.ww || h != tc.wh {
+			t.Errorf("outSize(%d, %d, %d, %d) = %d, %d; want %d, %d", tc.w, tc.h, tc.cw, tc.ch, w, h, tc.ww, tc.wh)
+		}
+	}
+}
+
+func TestGifDelay(t *testing.T) {
+	for _, tc := range [][2]int{{-10, 2}, {0, 2}, {5, 2}, {19, 2}, {20, 2}, {30, 3}, {500, 50}, {1234, 123}} {
+		if got := gifDelay(tc[0]); got != tc[1] {
+			t.Errorf("gifDelay(%d) = %d, want %d", tc[0], got, tc[1])
+		}
+	}
+}
+
+func TestEncodeGIF(t *testing.T) {
+	g := testGrid(2, 2, 0, 5, 10, math.NaN())
+	pal := makePalette()
+	frames := []*image.Paletted{
+		renderFrame(g, "v", fullCrop(g), 4, 4, 0, 10, pal),
+		renderFrame(g, "v", fullCrop(g), 4, 4, 5, 10, pal),
+	}
+	var buf bytes.Buffer
+	if err := encodeGIF(&buf, frames, 10, 3); err != nil {
+		t.Fatal(err)
+	}
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 2 || anim.LoopCount != 3 {
+		t.Fatalf("%d frames, loop %d", len(anim.Image), anim.LoopCount)
+	}
+	for i, img := range anim.Image {
+		if anim.Delay[i] != 2 || anim.Disposal[i] != gif.DisposalBackground {
+			t.Errorf("frame %d: delay %d disposal %d", i, anim.Delay[i], anim.Disposal[i])
+		}
+		want := indexes(frames[i])
+		got := indexes(img)
+		for y := range want {
+			for x := range want[y] {
+				if got[y][x] != want[y][x] {
+					t.Errorf("frame %d (%d, %d) = %d, want %d", i, x, y, got[y][x], want[y][x])
+				}
+			}
+		}
+	}
+}
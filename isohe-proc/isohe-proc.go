@@ -0,0 +1,556 @@
+package main
+
+/*
+* 交通部運輸研究所 港灣環境資訊 商港觀測資料
+* https://isohe.ihmt.gov.tw/station/OpenData/XML/GetXXstationXML.aspx
+* 取代 OAC_opendata_Console 的 isoheXMLtoJson
+* History: 波浪(HS, TP, MDIR, Tmean)及海流(Velocity, Vmdir); WindData: 風; TideData: 潮位
+* 輸出各站最新觀測(latest.json)及滾動保存的時間序列(CODE.json)
+*/
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"encoding/json"
+	"encoding/xml"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+var (
+	stationList = flag.String("stations", "KL,基隆港,127851|SA,蘇澳港,127855|HL,花蓮港,127852|TC,臺中港,127831|KH,高雄港,127853|TP,臺北港,127836|BD,布袋港,127840|AP,安平港,127846|NG,馬祖南竿,127847", "stations, code,name,data.gov.tw id|...")
+	url = flag.String("u", "https://isohe.ihmt.gov.tw/station/OpenData/XML/Get%vstationXML.aspx", "station XML url, %v: station code")
+	inFiles = flag.String("i", "", "convert local XML instead of download, code=file,code=file,...")
+	outDir = flag.String("dir", "json/", "path to save output file")
+	keepHours = flag.Int("keep", 72, "hours of time series to keep in CODE.json")
+
+	proxyAddr = flag.String("x", "", "proxy URL socks5://[user:pass@]host:port, http://... or https://... (host:port: socks5), default env HTTPS_PROXY/HTTP_PROXY")
+	connTimeout = flag.Int("timeout", 10, "connect timeout in Seconds")
+	UA = flag.String("ua", "OAC bot", "User-Agent")
+
+	verbosity = flag.Int("v", 3, "verbosity for app (1 error, 2 warn, 3 info, 4 debug)")
+	logFormat = flag.String("log", "text", "log format, text or json")
+)
+
+var loc = time.FixedZone("UTC+8", +8*60*60)
+
+func main() {
+	flag.Parse()
+	if err := lib.SetupLog(*verbosity, *logFormat); err != nil {
+		log.Fatalln(err)
+	}
+	lib.UA = *UA
+
+	stations, err := parseStations(*stationList)
+	if err != nil {
+		Vln(2, "[station]err", err)
+		return
+	}
+
+	local := make(map[string]string)
+	for _, s := range strings.Split(*inFiles, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 {
+			Vln(2, "[input]bad format, need code=file", s)
+			return
+		}
+		local[kv[0]] = kv[1]
+	}
+
+	timeout := time.Duration(*connTimeout) * time.Second
+	dialFunc := lib.NewDialFunc(*proxyAddr, timeout)
+
+	ok := 0
+	for _, st := range stations {
+		var buf []byte
+		if len(local) > 0 {
+			fp, found := local[st.Code]
+			if !found {
+				continue
+			}
+			buf, err = ioutil.ReadFile(fp)
+		} else {
+			Vln(3, "[get]", st.XmlSourceUrl)
+			buf, err = lib.GetUrl(st.XmlSourceUrl, dialFunc, timeout)
+		}
+		if err != nil {
+			Vln(2, "[get]err", st.Code, err)
+			continue
+		}
+
+		obs, err := parseXML(bytes.NewReader(buf))
+		if err != nil {
+			Vln(2, "[xml]err", st.Code, err)
+			continue
+		}
+		st.obs = obs
+		ok++
+		Vln(3, "[xml]", st.Code, st.Name, len(obs.Wave), len(obs.Current), len(obs.Wind), len(obs.Tide))
+	}
+
+	err = writeOutput(stations, *outDir, time.Duration(*keepHours) * time.Hour)
+	if err != nil {
+		Vln(2, "[json]err", err)
+		return
+	}
+	Vln(3, "[json]ok", ok, len(stations))
+}
+
+// ==== station ====
+type Station struct {
+	Name string
+	Code string
+	XmlSourceUrl string
+	OpendataLinkUrl string
+
+	obs *Observation
+}
+
+func parseStations(str string) ([]*Station, error) {
+	out := make([]*Station, 0, 9)
+	for _, s := range strings.Split(str, "|") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		p := strings.Split(s, ",")
+		if len(p) != 3 {
+			return nil, fmt.Errorf("bad station %q, need code,name,id", s)
+		}
+		out = append(out, &Station{
+			Code: p[0],
+			Name: p[1],
+			XmlSourceUrl: fmt.Sprintf(*url, p[0]),
+			OpendataLinkUrl: "https://data.gov.tw/dataset/" + p[2],
+		})
+	}
+	return out, nil
+}
+
+// ==== observation ====
+type Location struct {
+	Lat lib.JsonFloat `json:"lat"`
+	Lon lib.JsonFloat `json:"lon"`
+}
+
+// 波浪: 示性波高(m), 尖峰週期(s), 波向(度), 平均週期(s)
+type WaveObs struct {
+	Time time.Time `json:"time"`
+	HS lib.JsonFloat `json:"hs"`
+	TP lib.JsonFloat `json:"tp"`
+	Dir lib.JsonFloat `json:"dir"`
+	Tmean lib.JsonFloat `json:"tmean"`
+}
+
+// 海流/風: 速度(m/s), 方向(度)
+type VectorObs struct {
+	Time time.Time `json:"time"`
+	Speed lib.JsonFloat `json:"speed"`
+	Dir lib.JsonFloat `json:"dir"`
+}
+
+// 潮位(m)
+type TideObs struct {
+	Time time.Time `json:"time"`
+	Level lib.JsonFloat `json:"level"`
+}
+
+type Observation struct {
+	Wave []*WaveObs `json:"wave"`
+	Current []*VectorObs `json:"current"`
+	Wind []*VectorObs `json:"wind"`
+	Tide []*TideObs `json:"tide"`
+
+	// 各類觀測的位置不一定相同
+	WaveLoc *Location `json:"waveLoc,omitempty"`
+	WindLoc *Location `json:"windLoc,omitempty"`
+	TideLoc *Location `json:"tideLoc,omitempty"`
+}
+
+// 節點可能不存在, 值也可能是 -999.99
+type xmlItem struct {
+	Latitude string `xml:"Latitude"`
+	Longitude string `xml:"Longitude"`
+	DateTime string `xml:"Date_Time"`
+
+	HS string `xml:"HS"`
+	TP string `xml:"TP"`
+	MDIR string `xml:"MDIR"`
+	Tmean string `xml:"Tmean"`
+	Velocity string `xml:"Velocity"`
+	Vmdir string `xml:"Vmdir"`
+
+	WSAvg string `xml:"WS_AVG"`
+	WDAvg string `xml:"WD_AVG"`
+
+	TideValue string `xml:"TideValue"`
+}
+
+type xmlRoot struct {
+	History []xmlItem `xml:"History"`
+	WindData []xmlItem `xml:"WindData"`
+	TideData []xmlItem `xml:"TideData"`
+}
+
+func parseXML(r io.Reader) (*Observation, error) {
+	var root xmlRoot
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-8", "utf8", "":
+			return input, nil
+		}
+		return nil, fmt.Errorf("unsupported charset %v", charset)
+	}
+	err := decoder.Decode(&root)
+	if err != nil {
+		return nil, err
+	}
+
+	obs := &Observation{}
+	for _, it := range root.History {
+		t, err := parseTime(it.DateTime)
+		if err != nil {
+			Vln(4, "[xml]skip History", err)
+			continue
+		}
+		obs.WaveLoc = updateLoc(obs.WaveLoc, it)
+		w := &WaveObs{
+			Time: t,
+			HS: parseValue(it.HS),
+			TP: parseValue(it.TP),
+			Dir: parseValue(it.MDIR),
+			Tmean: parseValue(it.Tmean),
+		}
+		if !(w.HS.IsNaN() && w.TP.IsNaN() && w.Dir.IsNaN() && w.Tmean.IsNaN()) {
+			obs.Wave = append(obs.Wave, w)
+		}
+		c := &VectorObs{
+			Time: t,
+			Speed: parseValue(it.Velocity),
+			Dir: parseValue(it.Vmdir),
+		}
+		if !(c.Speed.IsNaN() && c.Dir.IsNaN()) {
+			obs.Current = append(obs.Current, c)
+		}
+	}
+	for _, it := range root.WindData {
+		t, err := parseTime(it.DateTime)
+		if err != nil {
+			Vln(4, "[xml]skip WindData", err)
+			continue
+		}
+		obs.WindLoc = updateLoc(obs.WindLoc, it)
+		obs.Wind = append(obs.Wind, &VectorObs{
+			Time: t,
+			Speed: parseValue(it.WSAvg),
+			Dir: parseValue(it.WDAvg),
+		})
+	}
+	for _, it := range root.TideData {
+		t, err := parseTime(it.DateTime)
+		if err != nil {
+			Vln(4, "[xml]skip TideData", err)
+			continue
+		}
+		obs.TideLoc = updateLoc(obs.TideLoc, it)
+		obs.Tide = append(obs.Tide, &TideObs{
+			Time: t,
+			Level: parseValue(it.TideValue),
+		})
+	}
+	obs.sort()
+	return obs, nil
+}
+
+// 空白, 無法解析, -999 以下皆視為無資料
+func parseValue(s string) lib.JsonFloat {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return lib.JsonFloat(math.NaN())
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= -999 {
+		return lib.JsonFloat(math.NaN())
+	}
+	return lib.JsonFloat(v)
+}
+
+// 沒有時區的視為 UTC+8
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	layouts := []string{
+		"2006/01/02 15:04:05",
+		"2006/1/2 15:04:05",
+		"2006/01/02 15:04",
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04",
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.In(loc), nil
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	// 上午/下午
+	if strings.Contains(s, "午") {
+		pm := strings.Contains(s, "下午")
+		s2 := strings.Replace(strings.Replace(s, "上午 ", "", 1), "下午 ", "", 1)
+		for _, layout := range []string{"2006/1/2 3:04:05", "2006/1/2 03:04:05"} {
+			if t, err := time.ParseInLocation(layout, s2, loc); err == nil {
+				if pm && t.Hour() < 12 {
+					t = t.Add(12 * time.Hour)
+				} else if !pm && t.Hour() == 12 {
+					t = t.Add(-12 * time.Hour)
+				}
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q", s)
+}
+
+// 取最後一筆有效的座標
+func updateLoc(l *Location, it xmlItem) *Location {
+	lat, lon := parseValue(it.Latitude), parseValue(it.Longitude)
+	if lat.IsNaN() || lon.IsNaN() || (lat == 0 && lon == 0) {
+		return l
+	}
+	return &Location{lat, lon}
+}
+
+func (o *Observation) sort() {
+	sort.SliceStable(o.Wave, func(i, j int) bool { return o.Wave[i].Time.Before(o.Wave[j].Time) })
+	sort.SliceStable(o.Current, func(i, j int) bool { return o.Current[i].Time.Before(o.Current[j].Time) })
+	sort.SliceStable(o.Wind, func(i, j int) bool { return o.Wind[i].Time.Before(o.Wind[j].Time) })
+	sort.SliceStable(o.Tide, func(i, j int) bool { return o.Tide[i].Time.Before(o.Tide[j].Time) })
+}
+
+// 合併舊的時間序列, 同一時間以新的為準, 只保留最新一筆往前 keep 的資料
+func (o *Observation) merge(old *Observation, keep time.Duration) {
+	if old != nil {
+		o.Wave = mergeWave(old.Wave, o.Wave)
+		o.Current = mergeVector(old.Current, o.Current)
+		o.Wind = mergeVector(old.Wind, o.Wind)
+		o.Tide = mergeTide(old.Tide, o.Tide)
+		if o.WaveLoc == nil {
+			o.WaveLoc = old.WaveLoc
+		}
+		if o.WindLoc == nil {
+			o.WindLoc = old.WindLoc
+		}
+		if o.TideLoc == nil {
+			o.TideLoc = old.TideLoc
+		}
+	}
+	o.sort()
+
+	if n := len(o.Wave); n > 0 {
+		st := sort.Search(n, func(i int) bool { return !o.Wave[i].Time.Before(o.Wave[n-1].Time.Add(-keep)) })
+		o.Wave = o.Wave[st:]
+	}
+	if n := len(o.Current); n > 0 {
+		st := sort.Search(n, func(i int) bool { return !o.Current[i].Time.Before(o.Current[n-1].Time.Add(-keep)) })
+		o.Current = o.Current[st:]
+	}
+	if n := len(o.Wind); n > 0 {
+		st := sort.Search(n, func(i int) bool { return !o.Wind[i].Time.Before(o.Wind[n-1].Time.Add(-keep)) })
+		o.Wind = o.Wind[st:]
+	}
+	if n := len(o.Tide); n > 0 {
+		st := sort.Search(n, func(i int) bool { return !o.Tide[i].Time.Before(o.Tide[n-1].Time.Add(-keep)) })
+		o.Tide = o.Tide[st:]
+	}
+}
+
+func mergeWave(old []*WaveObs, cur []*WaveObs) []*WaveObs {
+	seen := make(map[int64]bool, len(cur))
+	for _, x := range cur {
+		seen[x.Time.Unix()] = true
+	}
+	for _, x := range old {
+		if !seen[x.Time.Unix()] {
+			cur = append(cur, x)
+		}
+	}
+	return cur
+}
+
+func mergeVector(old []*VectorObs, cur []*VectorObs) []*VectorObs {
+	seen := make(map[int64]bool, len(cur))
+	for _, x := range cur {
+		seen[x.Time.Unix()] = true
+	}
+	for _, x := range old {
+		if !seen[x.Time.Unix()] {
+			cur = append(cur, x)
+		}
+	}
+	return cur
+}
+
+func mergeTide(old []*TideObs, cur []*TideObs) []*TideObs {
+	seen := make(map[int64]bool, len(cur))
+	for _, x := range cur {
+		seen[x.Time.Unix()] = true
+	}
+	for _, x := range old {
+		if !seen[x.Time.Unix()] {
+			cur = append(cur, x)
+		}
+	}
+	return cur
+}
+
+// ==== output ====
+
+// CODE.json
+type SeriesFile struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	XmlSourceUrl string `json:"xmlUrl"`
+	OpendataLinkUrl string `json:"opendataUrl"`
+	Updated time.Time `json:"updated"`
+
+	*Observation
+}
+
+// latest.json 的一筆, 各類觀測只留最後一筆
+type LatestItem struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	File string `json:"file"` // 時間序列檔名
+	Updated time.Time `json:"updated"`
+
+	Wave *WaveObs `json:"wave"`
+	Current *VectorObs `json:"current"`
+	Wind *VectorObs `json:"wind"`
+	Tide *TideObs `json:"tide"`
+
+	WaveLoc *Location `json:"waveLoc,omitempty"`
+	WindLoc *Location `json:"windLoc,omitempty"`
+	TideLoc *Location `json:"tideLoc,omitempty"`
+}
+
+func writeOutput(stations []*Station, dirOut string, keep time.Duration) error {
+	now := time.Now().In(loc).Truncate(time.Second)
+	latest := make([]*LatestItem, 0, len(stations))
+	for _, st := range stations {
+		name := st.Code + ".json"
+		fp := filepath.Join(dirOut, name)
+		old := readSeries(fp)
+
+		series := &SeriesFile{
+			Code: st.Code,
+			Name: st.Name,
+			XmlSourceUrl: st.XmlSourceUrl,
+			OpendataLinkUrl: st.OpendataLinkUrl,
+		}
+		switch {
+		case st.obs != nil:
+			// 更新時間序列
+			var oldObs *Observation
+			if old != nil {
+				oldObs = old.Observation
+			}
+			st.obs.merge(oldObs, keep)
+			series.Updated = now
+			series.Observation = st.obs
+
+			buf, err := json.Marshal(series)
+			if err != nil {
+				return err
+			}
+			err = ioutil.WriteFile(fp, buf, 0644)
+			if err != nil {
+				Vln(2, "[write]err", fp, err)
+				return err
+			}
+			Vln(4, "[write]", name)
+		case old != nil && old.Observation != nil:
+			// 這次抓取失敗, 沿用上一次的
+			Vln(3, "[station]use old data", st.Code, old.Updated)
+			series = old
+		default:
+			Vln(2, "[station]no data", st.Code)
+			continue
+		}
+
+		o := series.Observation
+		item := &LatestItem{
+			Code: st.Code,
+			Name: st.Name,
+			File: name,
+			Updated: series.Updated,
+			WaveLoc: o.WaveLoc,
+			WindLoc: o.WindLoc,
+			TideLoc: o.TideLoc,
+		}
+		if n := len(o.Wave); n > 0 {
+			item.Wave = o.Wave[n-1]
+		}
+		if n := len(o.Current); n > 0 {
+			item.Current = o.Current[n-1]
+		}
+		if n := len(o.Wind); n > 0 {
+			item.Wind = o.Wind[n-1]
+		}
+		if n := len(o.Tide); n > 0 {
+			item.Tide = o.Tide[n-1]
+		}
+		latest = append(latest, item)
+	}
+
+	buf, err := json.Marshal(latest)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(dirOut, "latest.json"), buf, 0644)
+	if err != nil {
+		Vln(2, "[write]latest.json", err)
+		return err
+	}
+	return nil
+}
+
+func readSeries(fp string) *SeriesFile {
+	buf, err := ioutil.ReadFile(fp)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Vln(2, "[read]old series", fp, err)
+		}
+		return nil
+	}
+	var out SeriesFile
+	err = json.Unmarshal(buf, &out)
+	if err != nil {
+		Vln(2, "[read]old series", fp, err)
+		return nil
+	}
+	return &out
+}
+
+// ==== log ====
+func Vf(level int, format string, v ...interface{}) {
+	lib.Vf(level, format, v...)
+}
+func Vln(level int, v ...interface{}) {
+	lib.Vln(level, v...)
+}
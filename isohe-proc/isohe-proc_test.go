@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parseSample(t *testing.T, name string) *Observation {
+	t.Helper()
+	fd, err := os.Open(filepath.Join("sample", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	obs, err := parseXML(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obs
+}
+
+// 轉換後要與 KL.fixture.json 相同: 依時間排序, -999.99/空白/非數字為 "", 全部無資料的波浪不輸出, 時間無法解析的略過
+func TestParseSample(t *testing.T) {
+	obs := parseSample(t, "KL.fixture.xml")
+	got, err := json.Marshal(obs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filepath.Join("sample", "KL.fixture.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, bytes.TrimSpace(want)) {
+		t.Errorf("differs from KL.fixture.json\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2024, 1, 1, 14, 5, 0, 0, loc)
+	for _, s := range []string{
+		"2024/01/01 14:05:00",
+		"2024/1/1 14:05:00",
+		"2024/01/01 14:05",
+		"2024-01-01 14:05:00",
+		"2024-01-01T14:05:00",
+		"2024-01-01T06:05:00Z",
+		"2024/1/1 下午 02:05:00",
+		" 2024/1/1 下午 2:05:00 ",
+	} {
+		got, err := parseTime(s)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseTime(%q) = %v, %v", s, got, err)
+		}
+	}
+	// 上午 12 點為 0 點
+	if got, err := parseTime("2024/1/1 上午 12:30:00"); err != nil || got.Hour() != 0 {
+		t.Errorf("上午 12:30: %v, %v", got, err)
+	}
+	if _, err := parseTime("2024/13/01 00:00:00"); err == nil {
+		t.Error("no error for bad month")
+	}
+}
+
+func TestParseBadXML(t *testing.T) {
+	if _, err := parseXML(strings.NewReader("<NewDataSet><History>")); err == nil {
+		t.Error("no error for truncated XML")
+	}
+	if _, err := parseXML(strings.NewReader(`<?xml version="1.0" encoding="big5"?><NewDataSet/>`)); err == nil {
+		t.Error("no error for big5")
+	}
+}
+
+func readJSON(t *testing.T, fp string, v interface{}) {
+	t.Helper()
+	buf, err := os.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 寫 CODE.json 及 latest.json; 沒有新資料的站沿用上一次, 有新資料的與舊的合併
+func TestWriteOutput(t *testing.T) {
+	dir := t.TempDir()
+	stations, err := parseStations("KL,基隆港,127851|SA,蘇澳港,127855")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stations[0].obs = parseSample(t, "KL.fixture.xml")
+	if err := writeOutput(stations, dir, 72 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	var series SeriesFile
+	readJSON(t, filepath.Join(dir, "KL.json"), &series)
+	want, _ := os.ReadFile(filepath.Join("sample", "KL.fixture.json"))
+	got, _ := json.Marshal(series.Observation)
+	if series.Code != "KL" || series.Name != "基隆港" || series.OpendataLinkUrl != "https://data.gov.tw/dataset/127851" || series.Updated.IsZero() {
+		t.Errorf("series %+v", series)
+	}
+	if !bytes.Equal(got, bytes.TrimSpace(want)) {
+		t.Errorf("KL.json differs from KL.fixture.json\ngot:  %s", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "SA.json")); !os.IsNotExist(err) {
+		t.Errorf("SA.json without data: %v", err)
+	}
+
+	var latest []*LatestItem
+	readJSON(t, filepath.Join(dir, "latest.json"), &latest)
+	if len(latest) != 1 || latest[0].Code != "KL" || latest[0].File != "KL.json" {
+		t.Fatalf("latest %+v", latest)
+	}
+	item := latest[0]
+	if item.Wave.HS != 1.25 || item.Current.Speed != 0.5 || item.Wind.Dir != 35 || item.Tide.Level != 0.42 || item.WaveLoc.Lat != 25.1553 {
+		t.Errorf("latest KL %+v", item)
+	}
+
+	// 第二次: KL 下載失敗沿用舊的; SA 有資料
+	stations[0].obs = nil
+	stations[1].obs = parseSample(t, "KL.fixture.xml")
+	if err := writeOutput(stations, dir, 72 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	latest = nil
+	readJSON(t, filepath.Join(dir, "latest.json"), &latest)
+	if len(latest) != 2 || latest[0].Code != "KL" || !latest[0].Updated.Equal(series.Updated) || latest[1].Code != "SA" {
+		t.Errorf("latest %+v", latest)
+	}
+
+	// 第三次: 新的一筆與舊的合併, 同一時間以新的為準, 只保留 -keep
+	st := time.Date(2024, 1, 1, 14, 0, 0, 0, loc)
+	stations[0].obs = &Observation{
+		Current: []*VectorObs{{Time: st, Speed: 0.7, Dir: 90}, {Time: st.Add(time.Hour), Speed: 0.8, Dir: 95}},
+	}
+	stations[1].obs = nil
+	if err := writeOutput(stations, dir, 13 * time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	series = SeriesFile{}
+	readJSON(t, filepath.Join(dir, "KL.json"), &series)
+	// 01:00 (0.31) 早於 15:00 - 13h, 14:00 的 0.5 換成 0.7
+	cur := series.Current
+	if len(cur) != 2 || cur[0].Speed != 0.7 || cur[1].Speed != 0.8 {
+		t.Errorf("current %+v", cur)
+	}
+	// 各類觀測各自以最新一筆計算保留範圍
+	if len(series.Wave) != 2 || len(series.Wind) != 2 || len(series.Tide) != 2 || series.WaveLoc == nil {
+		t.Errorf("wave %v wind %v tide %v loc %v, want kept from the old file", series.Wave, series.Wind, series.Tide, series.WaveLoc)
+	}
+}
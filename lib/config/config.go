@@ -0,0 +1,273 @@
+package config
+
+/*
+* 設定檔 (JSON) 及環境變數
+* 優先順序: 指令參數 > 環境變數 > 設定檔 (資料集區段 > 全域)
+* 授權碼、web hook 等機密不寫死在程式內, 可由環境變數或檔案讀取:
+*	OAC_TOKEN / OAC_TOKEN_FILE, OAC_HOOK / OAC_HOOK_FILE, OAC_HOOK_SECRET / OAC_HOOK_SECRET_FILE
+*	S3: OAC_S3, AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY / AWS_SECRET_ACCESS_KEY_FILE, AWS_REGION
+*	SFTP: OAC_SFTP, OAC_SFTP_KEY (私鑰檔路徑)
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// 設定檔路徑 (沒有 -config 時)
+const EnvConfig = "OAC_CONFIG"
+
+// 單一資料集的設定, 空白/nil 表示沿用上一層
+type Settings struct {
+	URL string `json:"url,omitempty"`
+	Token string `json:"token,omitempty"`
+	TokenFile string `json:"tokenFile,omitempty"` // 檔案內容為授權碼, 與 token 擇一
+
+	Proxy string `json:"proxy,omitempty"`
+	Timeout int `json:"timeout,omitempty"` // 連線, 秒
+	TLSTimeout int `json:"tlsTimeout,omitempty"` // TLS 握手, 秒
+	MaxTime int `json:"maxTime,omitempty"` // 整次下載 (含重試), 秒
+	Retry *int `json:"retry,omitempty"` // 下載中斷/5xx 重試次數
+	MaxSize *int `json:"maxSize,omitempty"` // 下載上限, MB, 0: 不限制
+	UA string `json:"ua,omitempty"`
+
+	Dir string `json:"dir,omitempty"`
+	NetCDF *bool `json:"nc,omitempty"`
+	Contour string `json:"contour,omitempty"`
+	Partial string `json:"partial,omitempty"` // skip, partial, abort
+
+	Hook string `json:"hook,omitempty"`
+	HookFile string `json:"hookFile,omitempty"` // 檔案內容為 web hook URL (含push key), 與 hook 擇一
+	HookSecret string `json:"hookSecret,omitempty"` // HMAC-SHA256 簽章用
+	HookSecretFile string `json:"hookSecretFile,omitempty"`
+	HookCA string `json:"hookCA,omitempty"` // 自訂CA (PEM)
+	HookRetry *int `json:"hookRetry,omitempty"` // 失敗重試次數
+
+	// S3 相容的物件儲存, https://endpoint/bucket/prefix/
+	S3 string `json:"s3,omitempty"`
+	S3Region string `json:"s3Region,omitempty"`
+	S3AccessKey string `json:"s3AccessKey,omitempty"`
+	S3SecretKey string `json:"s3SecretKey,omitempty"`
+	S3SecretKeyFile string `json:"s3SecretKeyFile,omitempty"`
+	S3CA string `json:"s3CA,omitempty"`
+	S3CacheControl string `json:"s3CacheControl,omitempty"` // index.json 以外的檔案
+	S3Gzip *bool `json:"s3Gzip,omitempty"` // 上傳前壓縮 json/geojson
+	S3Retry *int `json:"s3Retry,omitempty"` // 失敗重試次數
+
+	// SFTP, sftp://user@host[:port]/dir, 金鑰登入
+	SFTP string `json:"sftp,omitempty"`
+	SFTPKey string `json:"sftpKey,omitempty"` // 私鑰檔
+	SFTPKnownHosts string `json:"sftpKnownHosts,omitempty"` // 預設 ~/.ssh/known_hosts
+}
+
+type Config struct {
+	Settings
+
+	// serve
+	Listen string `json:"listen,omitempty"`
+	Interval string `json:"interval,omitempty"` // time.ParseDuration
+
+	// Prometheus textfile (*.prom)
+	Textfile string `json:"textfile,omitempty"`
+
+	// 資料集編號 >> 覆蓋全域設定
+	Datasets map[string]*Settings `json:"datasets,omitempty"`
+}
+
+func Load(fp string) (*Config, error) {
+	buf, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	err = json.Unmarshal(buf, c)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", fp, err)
+	}
+	return c, nil
+}
+
+// fp 空白時用 $OAC_CONFIG, 都沒有時為空設定
+func LoadDefault(fp string) (*Config, error) {
+	if fp == "" {
+		fp = os.Getenv(EnvConfig)
+	}
+	if fp == "" {
+		return &Config{}, nil
+	}
+	return Load(fp)
+}
+
+// 全域設定 + 資料集區段
+func (c *Config) For(id string) *Settings {
+	s := c.Settings
+	if ds, ok := c.Datasets[id]; ok {
+		s.Merge(ds)
+	}
+	return &s
+}
+
+// o 內非空白的欄位覆蓋 s; 機密與其檔案視為同一欄位
+// 設定檔/環境變數的 0 或空白表示沒有設定; 指令參數的 0 或空白由呼叫端直接寫入
+func (s *Settings) Merge(o *Settings) {
+	if o == nil {
+		return
+	}
+	if o.URL != "" {
+		s.URL = o.URL
+	}
+	if o.Token != "" || o.TokenFile != "" {
+		s.Token, s.TokenFile = o.Token, o.TokenFile
+	}
+	if o.Proxy != "" {
+		s.Proxy = o.Proxy
+	}
+	if o.Timeout != 0 {
+		s.Timeout = o.Timeout
+	}
+	if o.TLSTimeout != 0 {
+		s.TLSTimeout = o.TLSTimeout
+	}
+	if o.MaxTime != 0 {
+		s.MaxTime = o.MaxTime
+	}
+	if o.Retry != nil {
+		s.Retry = o.Retry
+	}
+	if o.MaxSize != nil {
+		s.MaxSize = o.MaxSize
+	}
+	if o.UA != "" {
+		s.UA = o.UA
+	}
+	if o.Dir != "" {
+		s.Dir = o.Dir
+	}
+	if o.NetCDF != nil {
+		s.NetCDF = o.NetCDF
+	}
+	if o.Contour != "" {
+		s.Contour = o.Contour
+	}
+	if o.Partial != "" {
+		s.Partial = o.Partial
+	}
+	if o.Hook != "" || o.HookFile != "" {
+		s.Hook, s.HookFile = o.Hook, o.HookFile
+	}
+	if o.HookSecret != "" || o.HookSecretFile != "" {
+		s.HookSecret, s.HookSecretFile = o.HookSecret, o.HookSecretFile
+	}
+	if o.HookCA != "" {
+		s.HookCA = o.HookCA
+	}
+	if o.HookRetry != nil {
+		s.HookRetry = o.HookRetry
+	}
+	if o.S3 != "" {
+		s.S3 = o.S3
+	}
+	if o.S3Region != "" {
+		s.S3Region = o.S3Region
+	}
+	if o.S3AccessKey != "" {
+		s.S3AccessKey = o.S3AccessKey
+	}
+	if o.S3SecretKey != "" || o.S3SecretKeyFile != "" {
+		s.S3SecretKey, s.S3SecretKeyFile = o.S3SecretKey, o.S3SecretKeyFile
+	}
+	if o.S3CA != "" {
+		s.S3CA = o.S3CA
+	}
+	if o.S3CacheControl != "" {
+		s.S3CacheControl = o.S3CacheControl
+	}
+	if o.S3Gzip != nil {
+		s.S3Gzip = o.S3Gzip
+	}
+	if o.S3Retry != nil {
+		s.S3Retry = o.S3Retry
+	}
+	if o.SFTP != "" {
+		s.SFTP = o.SFTP
+	}
+	if o.SFTPKey != "" {
+		s.SFTPKey = o.SFTPKey
+	}
+	if o.SFTPKnownHosts != "" {
+		s.SFTPKnownHosts = o.SFTPKnownHosts
+	}
+}
+
+// 由環境變數取得的設定
+func FromEnv() *Settings {
+	return &Settings{
+		Token: os.Getenv("OAC_TOKEN"),
+		TokenFile: os.Getenv("OAC_TOKEN_FILE"),
+		Proxy: os.Getenv("OAC_PROXY"),
+		Hook: os.Getenv("OAC_HOOK"),
+		HookFile: os.Getenv("OAC_HOOK_FILE"),
+		HookSecret: os.Getenv("OAC_HOOK_SECRET"),
+		HookSecretFile: os.Getenv("OAC_HOOK_SECRET_FILE"),
+		S3: os.Getenv("OAC_S3"),
+		S3Region: os.Getenv("AWS_REGION"),
+		S3AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		S3SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		S3SecretKeyFile: os.Getenv("AWS_SECRET_ACCESS_KEY_FILE"),
+		SFTP: os.Getenv("OAC_SFTP"),
+		SFTPKey: os.Getenv("OAC_SFTP_KEY"),
+	}
+}
+
+// 讀出 tokenFile, hookFile, hookSecretFile, s3SecretKeyFile 的內容
+func (s *Settings) ResolveSecrets() error {
+	var err error
+	if s.Token == "" && s.TokenFile != "" {
+		s.Token, err = ReadSecret(s.TokenFile)
+		if err != nil {
+			return err
+		}
+	}
+	if s.Hook == "" && s.HookFile != "" {
+		s.Hook, err = ReadSecret(s.HookFile)
+		if err != nil {
+			return err
+		}
+	}
+	if s.HookSecret == "" && s.HookSecretFile != "" {
+		s.HookSecret, err = ReadSecret(s.HookSecretFile)
+		if err != nil {
+			return err
+		}
+	}
+	if s.S3SecretKey == "" && s.S3SecretKeyFile != "" {
+		s.S3SecretKey, err = ReadSecret(s.S3SecretKeyFile)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 檔案內容去掉前後空白
+func ReadSecret(fp string) (string, error) {
+	buf, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+// 環境變數 NAME, 沒有時讀 NAME_FILE 指向的檔案
+func EnvSecret(name string) (string, error) {
+	if v := os.Getenv(name); v != "" {
+		return v, nil
+	}
+	if fp := os.Getenv(name + "_FILE"); fp != "" {
+		return ReadSecret(fp)
+	}
+	return "", nil
+}
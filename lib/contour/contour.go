@@ -0,0 +1,617 @@
+package contour
+
+/*
+* marching squares 等值線/等值帶
+* 每個格子用中心點(四角平均)切成4個三角形, 三角形內用線性內插
+* 中心點的值決定saddle(對角同側)時怎麼連, 不會有歧義
+* 任一角為NaN的格子直接跳過, 等值帶會在資料邊界處封閉
+*
+* 頂點用格點/格線編號當key, 相鄰格子算出來的點完全一致,
+* 等值帶先逐個三角形產生小多邊形, 再消去內部共用邊, 剩下的邊串成環
+*/
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+// ==== GeoJSON ====
+type FeatureCollection struct {
+	Type string `json:"type"`
+	Features []*Feature `json:"features"`
+}
+
+type Feature struct {
+	Type string `json:"type"`
+	Geometry *Geometry `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type Geometry struct {
+	Type string `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+func NewFeatureCollection() *FeatureCollection {
+	return &FeatureCollection{
+		Type: "FeatureCollection",
+		Features: make([]*Feature, 0, 16),
+	}
+}
+
+// 座標精度, 小數點後4位 (約10m)
+const coordScale = 1e4
+
+type point [2]float64
+
+// ==== vertex key ====
+const (
+	kindCorner = iota
+	kindCenter
+	kindEdgeH // (c,r) >> (c+1,r)
+	kindEdgeV // (c,r) >> (c,r+1)
+	kindDiag  // 角 >> 中心, k = 角的編號
+)
+
+type vkey struct {
+	kind uint8
+	k uint8
+	lv int32
+	c int32
+	r int32
+}
+
+type field struct {
+	grid *lib.VectorGrid
+	arr []lib.JsonFloat
+	levels []float64
+	dx float64
+	dy float64
+}
+
+func (f *field) val(c int, r int) float64 {
+	return float64(f.arr[r * f.grid.Nx + c])
+}
+
+func (f *field) center(c int, r int) float64 {
+	return (f.val(c, r) + f.val(c+1, r) + f.val(c+1, r+1) + f.val(c, r+1)) / 4
+}
+
+// 格子4角, 逆時針: 西南, 東南, 東北, 西北
+var cornerOff = [4][2]int{{0, 0}, {1, 0}, {1, 1}, {0, 1}}
+
+func (f *field) cornerPos(c int, r int) point {
+	return point{
+		float64(f.grid.Lo1) + float64(c) * f.dx,
+		float64(f.grid.La2) + float64(r) * f.dy,
+	}
+}
+
+// 三角形頂點
+type tvert struct {
+	key vkey
+	pos point
+	v float64
+}
+
+// 三角形的邊 a >> b 對應的key, 第二個回傳值表示走向與key的固定方向相反
+// 內插都用固定方向算, 讓共用邊的結果一致
+func (f *field) edgeKey(c int, r int, a int, b int) (vkey, bool) {
+	// a, b: 0~3 為角, 4 為中心; 對角線固定由角往中心
+	if a == 4 {
+		return vkey{kind: kindDiag, k: uint8(b), c: int32(c), r: int32(r)}, true
+	}
+	if b == 4 {
+		return vkey{kind: kindDiag, k: uint8(a), c: int32(c), r: int32(r)}, false
+	}
+
+	rev := false
+	if a > b {
+		a, b = b, a
+		rev = true
+	}
+	switch {
+	case a == 0 && b == 1:
+		return vkey{kind: kindEdgeH, c: int32(c), r: int32(r)}, rev
+	case a == 1 && b == 2:
+		return vkey{kind: kindEdgeV, c: int32(c + 1), r: int32(r)}, rev
+	case a == 2 && b == 3: // 固定方向為 西北 >> 東北
+		return vkey{kind: kindEdgeH, c: int32(c), r: int32(r + 1)}, !rev
+	default: // 0, 3
+		return vkey{kind: kindEdgeV, c: int32(c), r: int32(r)}, rev
+	}
+}
+
+// 邊上等值點, 依key的固定方向內插
+func crossAt(p tvert, q tvert, lv float64, reversed bool) point {
+	if reversed {
+		p, q = q, p
+	}
+	t := (lv - p.v) / (q.v - p.v)
+	return point{
+		p.pos[0] + (q.pos[0] - p.pos[0]) * t,
+		p.pos[1] + (q.pos[1] - p.pos[1]) * t,
+	}
+}
+
+type cellTri struct {
+	idx [3]int // 0~3 角, 4 中心
+	v [3]tvert
+}
+
+// 格子切成4個三角形, 皆為逆時針
+func (f *field) cellTris(c int, r int) ([4]cellTri, bool) {
+	var tris [4]cellTri
+	var vs [5]tvert
+	for i, off := range cornerOff {
+		v := f.val(c + off[0], r + off[1])
+		if math.IsNaN(v) {
+			return tris, false
+		}
+		vs[i] = tvert{
+			key: vkey{kind: kindCorner, c: int32(c + off[0]), r: int32(r + off[1])},
+			pos: f.cornerPos(c + off[0], r + off[1]),
+			v: v,
+		}
+	}
+	p0 := f.cornerPos(c, r)
+	vs[4] = tvert{
+		key: vkey{kind: kindCenter, c: int32(c), r: int32(r)},
+		pos: point{p0[0] + f.dx / 2, p0[1] + f.dy / 2},
+		v: f.center(c, r),
+	}
+	for i := 0; i < 4; i++ {
+		j := (i + 1) % 4
+		tris[i].idx = [3]int{i, j, 4}
+		tris[i].v = [3]tvert{vs[i], vs[j], vs[4]}
+	}
+	return tris, true
+}
+
+func newField(grid *lib.VectorGrid, key string, levels []float64) (*field, error) {
+	arr, ok := grid.Data[key]
+	if !ok {
+		return nil, errors.New("no variable: " + key)
+	}
+	if grid.Nx < 2 || grid.Ny < 2 || len(arr) != grid.Nx * grid.Ny {
+		return nil, errors.New("bad grid size")
+	}
+	lv := make([]float64, len(levels))
+	copy(lv, levels)
+	sort.Float64s(lv)
+	return &field{
+		grid: grid,
+		arr: arr,
+		levels: lv,
+		dx: float64(grid.Dx()),
+		dy: float64(grid.Dy()),
+	}, nil
+}
+
+// 產生等值線(LineString)跟等值帶(MultiPolygon)
+func Build(grid *lib.VectorGrid, key string, levels []float64) (*FeatureCollection, error) {
+	f, err := newField(grid, key, levels)
+	if err != nil {
+		return nil, err
+	}
+
+	bands, err := f.isobands()
+	if err != nil {
+		return nil, err
+	}
+	fc := NewFeatureCollection()
+	fc.Features = append(fc.Features, bands...)
+	fc.Features = append(fc.Features, f.isolines()...)
+	return fc, nil
+}
+
+func Isolines(grid *lib.VectorGrid, key string, levels []float64) ([]*Feature, error) {
+	f, err := newField(grid, key, levels)
+	if err != nil {
+		return nil, err
+	}
+	return f.isolines(), nil
+}
+
+func Isobands(grid *lib.VectorGrid, key string, levels []float64) ([]*Feature, error) {
+	f, err := newField(grid, key, levels)
+	if err != nil {
+		return nil, err
+	}
+	return f.isobands()
+}
+
+// ==== isoline ====
+func (f *field) isolines() []*Feature {
+	out := make([]*Feature, 0, len(f.levels))
+	for li, lv := range f.levels {
+		adj := make(map[vkey][]vkey)
+		pos := make(map[vkey]point)
+		for r := 0; r < f.grid.Ny - 1; r++ {
+			for c := 0; c < f.grid.Nx - 1; c++ {
+				tris, ok := f.cellTris(c, r)
+				if !ok {
+					continue
+				}
+				for _, tri := range tris {
+					seg := make([]vkey, 0, 2)
+					for i := 0; i < 3; i++ {
+						j := (i + 1) % 3
+						p, q := tri.v[i], tri.v[j]
+						if (p.v >= lv) == (q.v >= lv) {
+							continue
+						}
+						key, rev := f.edgeKey(c, r, tri.idx[i], tri.idx[j])
+						key.lv = int32(li)
+						if _, ok := pos[key]; !ok {
+							pos[key] = crossAt(p, q, lv, rev)
+						}
+						seg = append(seg, key)
+					}
+					if len(seg) != 2 {
+						continue
+					}
+					adj[seg[0]] = append(adj[seg[0]], seg[1])
+					adj[seg[1]] = append(adj[seg[1]], seg[0])
+				}
+			}
+		}
+
+		for _, chain := range chainLines(adj) {
+			coords := make([]point, 0, len(chain))
+			for _, key := range chain {
+				coords = appendPoint(coords, pos[key])
+			}
+			if len(coords) < 2 {
+				continue
+			}
+			out = append(out, &Feature{
+				Type: "Feature",
+				Geometry: &Geometry{Type: "LineString", Coordinates: coords},
+				Properties: map[string]interface{}{
+					"type": "isoline",
+					"level": lv,
+				},
+			})
+		}
+	}
+	return out
+}
+
+// 無向線段串成折線, 先走端點(開放線), 再走剩下的環
+func chainLines(adj map[vkey][]vkey) [][]vkey {
+	used := make(map[[2]vkey]bool)
+	segKey := func(a vkey, b vkey) [2]vkey {
+		if lessKey(b, a) {
+			a, b = b, a
+		}
+		return [2]vkey{a, b}
+	}
+
+	starts := make([]vkey, 0, len(adj))
+	for k, nb := range adj {
+		if len(nb) == 1 {
+			starts = append(starts, k)
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return lessKey(starts[i], starts[j]) })
+	rest := make([]vkey, 0, len(adj))
+	for k := range adj {
+		rest = append(rest, k)
+	}
+	sort.Slice(rest, func(i, j int) bool { return lessKey(rest[i], rest[j]) })
+	starts = append(starts, rest...)
+
+	out := make([][]vkey, 0, 16)
+	for _, st := range starts {
+		cur := st
+		chain := []vkey{cur}
+		for {
+			next, ok := vkey{}, false
+			for _, nb := range adj[cur] {
+				if !used[segKey(cur, nb)] {
+					next, ok = nb, true
+					break
+				}
+			}
+			if !ok {
+				break
+			}
+			used[segKey(cur, next)] = true
+			chain = append(chain, next)
+			cur = next
+		}
+		if len(chain) > 1 {
+			out = append(out, chain)
+		}
+	}
+	return out
+}
+
+func lessKey(a vkey, b vkey) bool {
+	if a.r != b.r {
+		return a.r < b.r
+	}
+	if a.c != b.c {
+		return a.c < b.c
+	}
+	if a.kind != b.kind {
+		return a.kind < b.kind
+	}
+	if a.k != b.k {
+		return a.k < b.k
+	}
+	return a.lv < b.lv
+}
+
+// ==== isoband ====
+// 等值帶 [levels[i], levels[i+1]), 最後一帶沒有上限
+func (f *field) isobands() ([]*Feature, error) {
+	out := make([]*Feature, 0, len(f.levels))
+	for li, lo := range f.levels {
+		hi := math.Inf(1)
+		if li + 1 < len(f.levels) {
+			hi = f.levels[li + 1]
+		}
+
+		edges := make(map[[2]vkey]bool)
+		pos := make(map[vkey]point)
+		for r := 0; r < f.grid.Ny - 1; r++ {
+			for c := 0; c < f.grid.Nx - 1; c++ {
+				tris, ok := f.cellTris(c, r)
+				if !ok {
+					continue
+				}
+				for _, tri := range tris {
+					poly := f.clipTri(c, r, &tri, li, lo, hi, pos)
+					if len(poly) < 3 {
+						continue
+					}
+					for i := range poly {
+						a, b := poly[i], poly[(i + 1) % len(poly)]
+						if a == b {
+							continue
+						}
+						// 內部共用邊方向相反, 互相抵消
+						if edges[[2]vkey{b, a}] {
+							delete(edges, [2]vkey{b, a})
+							continue
+						}
+						edges[[2]vkey{a, b}] = true
+					}
+				}
+			}
+		}
+
+		polys, err := buildPolygons(edges, pos)
+		if err != nil {
+			return nil, fmt.Errorf("isoband %v: %v", lo, err)
+		}
+		if len(polys) == 0 {
+			continue
+		}
+		props := map[string]interface{}{
+			"type": "isoband",
+			"level": lo,
+		}
+		if !math.IsInf(hi, 1) {
+			props["upper"] = hi
+		}
+		out = append(out, &Feature{
+			Type: "Feature",
+			Geometry: &Geometry{Type: "MultiPolygon", Coordinates: polys},
+			Properties: props,
+		})
+	}
+	return out, nil
+}
+
+// 三角形與 lo <= v < hi 的交集 (凸多邊形), 沿三角形邊逆時針輸出頂點
+func (f *field) clipTri(c int, r int, tri *cellTri, li int, lo float64, hi float64, pos map[vkey]point) []vkey {
+	in := func(v float64) bool {
+		return v >= lo && v < hi
+	}
+
+	out := make([]vkey, 0, 7)
+	for i := 0; i < 3; i++ {
+		j := (i + 1) % 3
+		p, q := tri.v[i], tri.v[j]
+		if in(p.v) {
+			if _, ok := pos[p.key]; !ok {
+				pos[p.key] = p.pos
+			}
+			out = append(out, p.key)
+		}
+
+		type cross struct {
+			t float64
+			key vkey
+			lv float64
+		}
+		xs := make([]cross, 0, 2)
+		for n, lv := range []float64{lo, hi} {
+			if math.IsInf(lv, 1) || (p.v >= lv) == (q.v >= lv) {
+				continue
+			}
+			key, rev := f.edgeKey(c, r, tri.idx[i], tri.idx[j])
+			key.lv = int32(li + n)
+			xs = append(xs, cross{(lv - p.v) / (q.v - p.v), key, lv})
+			if _, ok := pos[key]; !ok {
+				pos[key] = crossAt(p, q, lv, rev)
+			}
+		}
+		if len(xs) == 2 && xs[1].t < xs[0].t {
+			xs[0], xs[1] = xs[1], xs[0]
+		}
+		for _, x := range xs {
+			out = append(out, x.key)
+		}
+	}
+	return out
+}
+
+// 剩下的有向邊串成環, 逆時針為外環, 順時針為洞
+// 找不到外環的洞不能丟掉 (等值帶會變成實心), 回傳錯誤
+func buildPolygons(edges map[[2]vkey]bool, pos map[vkey]point) ([][][]point, error) {
+	next := make(map[vkey][]vkey, len(edges))
+	for e := range edges {
+		next[e[0]] = append(next[e[0]], e[1])
+	}
+	starts := make([]vkey, 0, len(next))
+	for k, list := range next {
+		starts = append(starts, k)
+		sort.Slice(list, func(i, j int) bool { return lessKey(list[i], list[j]) })
+	}
+	sort.Slice(starts, func(i, j int) bool { return lessKey(starts[i], starts[j]) })
+
+	outer := make([][]point, 0, 16)
+	holes := make([][]point, 0, 16)
+	for _, st := range starts {
+		for len(next[st]) > 0 {
+			ring := []point{}
+			cur := st
+			for {
+				list := next[cur]
+				if len(list) == 0 {
+					break
+				}
+				nxt := list[0]
+				next[cur] = list[1:]
+				ring = appendPoint(ring, pos[cur])
+				cur = nxt
+				if cur == st {
+					break
+				}
+			}
+			if len(ring) > 1 && ring[0] == ring[len(ring) - 1] {
+				ring = ring[:len(ring) - 1]
+			}
+			if len(ring) < 3 {
+				continue
+			}
+			ring = append(ring, ring[0]) // GeoJSON 頭尾相同
+			a := ringArea(ring)
+			switch {
+			case a > 0:
+				outer = append(outer, ring)
+			case a < 0:
+				holes = append(holes, ring)
+			}
+		}
+	}
+
+	polys := make([][][]point, len(outer))
+	for i, ring := range outer {
+		polys[i] = [][]point{ring}
+	}
+	for _, hole := range holes {
+		// 放進面積最小且包含此洞的外環
+		// 座標取整後探測點可能剛好落在邊上, 再用洞的頂點試一次
+		best := containingRing(outer, []point{ringProbe(hole)})
+		if best < 0 {
+			best = containingRing(outer, hole[:len(hole) - 1])
+		}
+		if best < 0 {
+			return nil, fmt.Errorf("hole at %v not inside any ring", hole[0])
+		}
+		polys[best] = append(polys[best], hole)
+	}
+	return polys, nil
+}
+
+// 包含任一點且面積最小的環, 沒有為 -1
+func containingRing(rings [][]point, pts []point) int {
+	best := -1
+	bestArea := math.Inf(1)
+	for i, ring := range rings {
+		a := ringArea(ring)
+		if a >= bestArea {
+			continue
+		}
+		for _, p := range pts {
+			if ringContains(ring, p) {
+				best = i
+				bestArea = a
+				break
+			}
+		}
+	}
+	return best
+}
+
+func appendPoint(list []point, p point) []point {
+	p[0] = math.Round(p[0] * coordScale) / coordScale
+	p[1] = math.Round(p[1] * coordScale) / coordScale
+	if len(list) > 0 && list[len(list) - 1] == p {
+		return list
+	}
+	return append(list, p)
+}
+
+// shoelace, 逆時針為正
+func ringArea(ring []point) float64 {
+	a := 0.0
+	for i := 0; i + 1 < len(ring); i++ {
+		a += ring[i][0] * ring[i+1][1] - ring[i+1][0] * ring[i][1]
+	}
+	return a / 2
+}
+
+// 取洞內側的一點: 第一條邊中點往左側(洞為順時針, 左側為洞外==外環內)偏一點點
+func ringProbe(ring []point) point {
+	p, q := ring[0], ring[1]
+	mx, my := (p[0] + q[0]) / 2, (p[1] + q[1]) / 2
+	dx, dy := q[0] - p[0], q[1] - p[1]
+	l := math.Hypot(dx, dy)
+	if l == 0 {
+		return p
+	}
+	eps := 1e-6
+	return point{mx - dy / l * eps, my + dx / l * eps}
+}
+
+func ringContains(ring []point, p point) bool {
+	in := false
+	for i, j := 0, len(ring) - 1; i < len(ring); j, i = i, i + 1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) &&
+			p[0] < (b[0] - a[0]) * (p[1] - a[1]) / (b[1] - a[1]) + a[0] {
+			in = !in
+		}
+	}
+	return in
+}
+
+// 解析 "浪高:100,200,300;週期:500,800"
+func ParseSpec(str string) (map[string][]float64, error) {
+	out := make(map[string][]float64)
+	for _, item := range strings.Split(str, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.New("contour spec should be name:level,level,...")
+		}
+		levels := make([]float64, 0, 8)
+		for _, s := range strings.Split(kv[1], ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return nil, err
+			}
+			levels = append(levels, v)
+		}
+		if len(levels) == 0 {
+			return nil, errors.New("no level for " + kv[0])
+		}
+		out[strings.TrimSpace(kv[0])] = levels
+	}
+	return out, nil
+}
@@ -0,0 +1,296 @@
+package contour
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+// nx*ny 格點, 經緯度從 0 開始間距 1, vals 由南往北逐列
+func testGrid(nx int, ny int, vals ...float64) *lib.VectorGrid {
+	g := lib.NewVectorGrid()
+	g.Nx, g.Ny = nx, ny
+	g.Lo1, g.Lo2 = 0, float32(nx - 1)
+	g.La1, g.La2 = float32(ny - 1), 0
+	arr := make([]lib.JsonFloat, len(vals))
+	for i, v := range vals {
+		arr[i] = lib.JsonFloat(v)
+	}
+	g.Data["v"] = arr
+	return g
+}
+
+func bands(t *testing.T, g *lib.VectorGrid, levels ...float64) map[float64][][][]point {
+	t.Helper()
+	fs, err := Isobands(g, "v", levels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[float64][][][]point)
+	for _, f := range fs {
+		polys := f.Geometry.Coordinates.([][][]point)
+		for _, poly := range polys {
+			checkPolygon(t, poly)
+		}
+		out[f.Properties["level"].(float64)] = polys
+	}
+	return out
+}
+
+func lines(t *testing.T, g *lib.VectorGrid, level float64) [][]point {
+	t.Helper()
+	fs, err := Isolines(g, "v", []float64{level})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([][]point, 0, len(fs))
+	for _, f := range fs {
+		out = append(out, f.Geometry.Coordinates.([]point))
+	}
+	return out
+}
+
+// 環頭尾相同, 外環逆時針, 洞順時針
+func checkPolygon(t *testing.T, poly [][]point) {
+	t.Helper()
+	for i, ring := range poly {
+		if len(ring) < 4 || ring[0] != ring[len(ring) - 1] {
+			t.Errorf("ring %d not closed: %v", i, ring)
+		}
+		a := ringArea(ring)
+		if i == 0 && a <= 0 {
+			t.Errorf("outer ring area %v, want counterclockwise", a)
+		}
+		if i > 0 && a >= 0 {
+			t.Errorf("hole area %v, want clockwise", a)
+		}
+	}
+}
+
+func near(a float64, b float64) bool {
+	return math.Abs(a - b) < 1e-4
+}
+
+func hasPoint(list []point, x float64, y float64) bool {
+	for _, p := range list {
+		if near(p[0], x) && near(p[1], y) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPeak(t *testing.T) {
+	g := testGrid(3, 3,
+		0, 0, 0,
+		0, 10, 0,
+		0, 0, 0)
+
+	ls := lines(t, g, 5)
+	if len(ls) != 1 {
+		t.Fatalf("%d isolines, want 1", len(ls))
+	}
+	l := ls[0]
+	if l[0] != l[len(l) - 1] {
+		t.Errorf("isoline around peak not closed: %v", l)
+	}
+	for _, p := range [][2]float64{{1, 0.5}, {1.5, 1}, {1, 1.5}, {0.5, 1}} {
+		if !hasPoint(l, p[0], p[1]) {
+			t.Errorf("isoline has no %v: %v", p, l)
+		}
+	}
+
+	// [0, 5): 整個範圍扣掉山頂, 山頂是洞; [5, ~): 山頂
+	bs := bands(t, g, 0, 5)
+	low, high := bs[0], bs[5]
+	if len(low) != 1 || len(low[0]) != 2 {
+		t.Fatalf("band 0: %v", low)
+	}
+	if a := ringArea(low[0][0]); !near(a, 4) {
+		t.Errorf("band 0 outer area %v, want 4", a)
+	}
+	if len(high) != 1 || len(high[0]) != 1 {
+		t.Fatalf("band 5: %v", high)
+	}
+	if a, h := ringArea(high[0][0]), -ringArea(low[0][1]); !near(a, h) {
+		t.Errorf("peak area %v != hole area %v", a, h)
+	}
+}
+
+// 對角同側的 saddle 由中心點決定連法
+func TestSaddle(t *testing.T) {
+	g := testGrid(2, 2,
+		0, 10,
+		10, 0)
+
+	// 中心 5 >= 4: 高的對角相連, 低的兩角各自一條線
+	ls := lines(t, g, 4)
+	if len(ls) != 2 {
+		t.Fatalf("%d isolines, want 2", len(ls))
+	}
+	for _, l := range ls {
+		if l[0] == l[len(l) - 1] {
+			t.Errorf("isoline should end at the edge: %v", l)
+		}
+	}
+	if !hasPoint(ls[0], 0.4, 0.4) && !hasPoint(ls[1], 0.4, 0.4) {
+		t.Errorf("no isoline through (0.4, 0.4): %v", ls)
+	}
+	bs := bands(t, g, 0, 4)
+	if len(bs[0]) != 2 || len(bs[4]) != 1 {
+		t.Errorf("level 4: %d low, %d high polygons, want 2, 1", len(bs[0]), len(bs[4]))
+	}
+
+	// 中心 5 < 6: 反過來
+	bs = bands(t, g, 0, 6)
+	if len(bs[0]) != 1 || len(bs[6]) != 2 {
+		t.Errorf("level 6: %d low, %d high polygons, want 1, 2", len(bs[0]), len(bs[6]))
+	}
+}
+
+// NaN 周圍的格子跳過, 等值帶在 NaN 處留洞, 也不產生等值線
+func TestNaNHole(t *testing.T) {
+	nan := math.NaN()
+	g := testGrid(5, 5,
+		10, 10, 10, 10, 10,
+		10, 10, 10, 10, 10,
+		10, 10, nan, 10, 10,
+		10, 10, 10, 10, 10,
+		10, 10, 10, 10, 10)
+
+	if ls := lines(t, g, 5); len(ls) != 0 {
+		t.Errorf("isolines %v, want none", ls)
+	}
+	bs := bands(t, g, 5)
+	poly := bs[5]
+	if len(poly) != 1 || len(poly[0]) != 2 {
+		t.Fatalf("band: %v", poly)
+	}
+	if a := ringArea(poly[0][0]); !near(a, 16) {
+		t.Errorf("outer area %v, want 16", a)
+	}
+	hole := poly[0][1]
+	if a := ringArea(hole); !near(a, -4) {
+		t.Errorf("hole area %v, want -4", a)
+	}
+	for _, p := range hole {
+		if p[0] < 1 || p[0] > 3 || p[1] < 1 || p[1] > 3 {
+			t.Errorf("hole point %v outside the NaN cells", p)
+		}
+	}
+}
+
+// 等值線兩端在資料邊界, 等值帶沿邊界封閉
+func TestEdge(t *testing.T) {
+	g := testGrid(3, 2,
+		0, 5, 10,
+		0, 5, 10)
+
+	ls := lines(t, g, 3)
+	if len(ls) != 1 {
+		t.Fatalf("%d isolines, want 1", len(ls))
+	}
+	l := ls[0]
+	for _, p := range l {
+		if !near(p[0], 0.6) {
+			t.Errorf("isoline point %v, want lon 0.6", p)
+		}
+	}
+	ys := []float64{l[0][1], l[len(l) - 1][1]}
+	if !(near(ys[0], 0) && near(ys[1], 1)) && !(near(ys[0], 1) && near(ys[1], 0)) {
+		t.Errorf("isoline ends %v, want on both edges", ys)
+	}
+
+	poly := bands(t, g, 3)[3]
+	if len(poly) != 1 || len(poly[0]) != 1 {
+		t.Fatalf("band: %v", poly)
+	}
+	if a := ringArea(poly[0][0]); !near(a, 1.4) {
+		t.Errorf("band area %v, want 1.4", a)
+	}
+	for _, p := range [][2]float64{{0.6, 0}, {2, 0}, {2, 1}, {0.6, 1}} {
+		if !hasPoint(poly[0][0], p[0], p[1]) {
+			t.Errorf("band has no %v: %v", p, poly[0][0])
+		}
+	}
+}
+
+// 兩個山頂: 洞各自放進所在的外環
+func TestHoleAssignment(t *testing.T) {
+	g := testGrid(7, 3,
+		0, 0, 0, 0, 0, 0, 0,
+		0, 10, 0, 0, 0, 10, 0,
+		0, 0, 0, 0, 0, 0, 0)
+
+	low := bands(t, g, 0, 5)[0]
+	if len(low) != 1 || len(low[0]) != 3 {
+		t.Fatalf("band 0: %v", low)
+	}
+
+	// 大環裡的小環: 洞放進面積最小的外環
+	g = testGrid(7, 7,
+		0, 0, 0, 0, 0, 0, 0,
+		0, 10, 10, 10, 10, 10, 0,
+		0, 10, 0, 0, 0, 10, 0,
+		0, 10, 0, 10, 0, 10, 0,
+		0, 10, 0, 0, 0, 10, 0,
+		0, 10, 10, 10, 10, 10, 0,
+		0, 0, 0, 0, 0, 0, 0)
+	bs := bands(t, g, 0, 5)
+	if len(bs[0]) != 2 || len(bs[5]) != 2 {
+		t.Fatalf("%d low, %d high polygons, want 2, 2", len(bs[0]), len(bs[5]))
+	}
+	for _, poly := range bs[5] {
+		if ringArea(poly[0]) < 4 {
+			continue
+		}
+		// 外圍的高值環, 內側一個洞
+		if len(poly) != 2 {
+			t.Errorf("ring polygon has %d holes, want 1", len(poly) - 1)
+		}
+	}
+}
+
+// 沒有外環的洞要回報, 不能丟掉
+func TestOrphanHole(t *testing.T) {
+	pos := make(map[vkey]point)
+	edges := make(map[[2]vkey]bool)
+	keys := []vkey{{c: 0}, {c: 1}, {c: 2}}
+	pos[keys[0]] = point{0, 0}
+	pos[keys[1]] = point{0, 1}
+	pos[keys[2]] = point{1, 0}
+	for i := range keys {
+		edges[[2]vkey{keys[i], keys[(i + 1) % 3]}] = true
+	}
+	if _, err := buildPolygons(edges, pos); err == nil || !strings.Contains(err.Error(), "not inside any ring") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestBadInput(t *testing.T) {
+	g := testGrid(2, 2, 0, 1, 2, 3)
+	if _, err := Build(g, "x", []float64{1}); err == nil {
+		t.Error("no error for unknown variable")
+	}
+	g.Data["v"] = g.Data["v"][:3]
+	if _, err := Build(g, "v", []float64{1}); err == nil {
+		t.Error("no error for short data")
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	spec, err := ParseSpec("浪高:300, 100,200; 週期:5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spec) != 2 || len(spec["浪高"]) != 3 || spec["週期"][0] != 5 {
+		t.Errorf("spec %v", spec)
+	}
+	for _, s := range []string{"浪高", ":1", "浪高:a"} {
+		if _, err := ParseSpec(s); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
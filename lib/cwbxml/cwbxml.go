@@ -0,0 +1,343 @@
+package cwbxml
+
+/*
+* 氣象局 open data 格點XML (cwbopendata / cwaopendata) >> VectorGrid
+* XML路徑及 elementName >> key 的對應由 Mapping 設定, 新的資料集不需要改程式
+* 路徑不含根節點, 氣象局改名為氣象署後的 cwaopendata 也能用同一份設定
+* 每個 location 一個格點 (lat, lon, 數個 weatherElement), 順序不拘
+*/
+
+import (
+	"embed"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+// 輸出的 key 及單位; Units 空白時用XML內的 measures
+type Element struct {
+	Key string `json:"key"`
+	Units string `json:"units,omitempty"`
+}
+
+// 路徑以 '/' 分隔, 從根節點的下一層開始 (例: "dataset/location")
+// 舊設定含有根節點 "cwbopendata/" 或 "cwaopendata/" 時會自動去掉
+// Lat, Lon, ElementName, Value, Measures 為相對於 Location 的路徑
+type Mapping struct {
+	Dataset string `json:"dataset"`
+
+	Description string `json:"description"`
+	Time []string `json:"time"`
+
+	// 參數名稱 >> "nx" / "ny", 用來檢查格點數
+	ParameterName string `json:"parameterName"`
+	ParameterValue string `json:"parameterValue"`
+	Parameters map[string]string `json:"parameters"`
+
+	Location string `json:"location"`
+	Lat string `json:"lat"`
+	Lon string `json:"lon"`
+	ElementName string `json:"elementName"`
+	Value string `json:"value"`
+	Measures string `json:"measures"`
+
+	// elementName >> 輸出; 沒列出的略過
+	Elements map[string]Element `json:"elements"`
+}
+
+//go:embed mapping/*.json
+var builtinFS embed.FS
+
+// 內建的對應, 依資料集編號
+func Builtin(dataset string) (*Mapping, error) {
+	buf, err := builtinFS.ReadFile("mapping/" + dataset + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("no builtin mapping for %v", dataset)
+	}
+	return decodeMapping(buf)
+}
+
+// 內建的資料集編號
+func BuiltinList() []string {
+	list, _ := builtinFS.ReadDir("mapping")
+	out := make([]string, 0, len(list))
+	for _, f := range list {
+		out = append(out, strings.TrimSuffix(f.Name(), path.Ext(f.Name())))
+	}
+	return out
+}
+
+func LoadMapping(fp string) (*Mapping, error) {
+	buf, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMapping(buf)
+}
+
+func decodeMapping(buf []byte) (*Mapping, error) {
+	m := &Mapping{}
+	err := json.Unmarshal(buf, m)
+	if err != nil {
+		return nil, err
+	}
+	m.trimRoot()
+	return m, m.check()
+}
+
+var rootNames = []string{"cwbopendata/", "cwaopendata/"}
+
+func trimRoot(p string) string {
+	for _, root := range rootNames {
+		if strings.HasPrefix(p, root) {
+			return p[len(root):]
+		}
+	}
+	return p
+}
+
+func (m *Mapping) trimRoot() {
+	m.Description = trimRoot(m.Description)
+	for i, p := range m.Time {
+		m.Time[i] = trimRoot(p)
+	}
+	m.ParameterName = trimRoot(m.ParameterName)
+	m.ParameterValue = trimRoot(m.ParameterValue)
+	m.Location = trimRoot(m.Location)
+}
+
+func (m *Mapping) check() error {
+	switch {
+	case m.Location == "":
+		return errors.New("mapping: empty location")
+	case m.Lat == "" || m.Lon == "":
+		return errors.New("mapping: empty lat/lon")
+	case m.ElementName == "" || m.Value == "":
+		return errors.New("mapping: empty elementName/value")
+	case len(m.Elements) == 0:
+		return errors.New("mapping: no elements")
+	}
+	return nil
+}
+
+type parser struct {
+	m *Mapping
+	grid *lib.VectorGrid
+
+	path []string
+	locPrefix string
+
+	paramName string
+	nx int
+	ny int
+
+	// 目前的 location
+	latStr string
+	lonStr string
+	key string // 目前 elementName 對應的 key, 空白為略過
+
+	latIdx map[string]float64
+	lonIdx map[string]float64
+	buf map[string]map[string]map[string]lib.JsonFloat // key >> lat >> lon
+}
+
+func Parse(r io.Reader, m *Mapping) (*lib.VectorGrid, error) {
+	ps := &parser{
+		m: m,
+		grid: lib.NewVectorGrid(),
+		path: make([]string, 0, 32),
+		locPrefix: m.Location + "/",
+		latIdx: make(map[string]float64),
+		lonIdx: make(map[string]float64),
+		buf: make(map[string]map[string]map[string]lib.JsonFloat),
+	}
+
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return ps.grid, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			ps.path = append(ps.path, t.Name.Local)
+		case xml.EndElement:
+			if len(ps.path) > 0 {
+				ps.path = ps.path[:len(ps.path)-1]
+			}
+		case xml.CharData:
+			ps.fill(strings.TrimSpace(string(t)))
+		}
+	}
+	return ps.finish()
+}
+
+func (ps *parser) fill(str string) {
+	if str == "" {
+		return
+	}
+	m := ps.m
+	if len(ps.path) < 2 {
+		return
+	}
+	p := strings.Join(ps.path[1:], "/") // 不管根節點名稱
+
+	if strings.HasPrefix(p, ps.locPrefix) {
+		ps.fillLocation(p[len(ps.locPrefix):], str)
+		return
+	}
+
+	switch p {
+	case m.Description:
+		lib.Vln(3, "[desc]", p, str)
+		ps.grid.Desc = str
+	case m.ParameterName:
+		lib.Vln(4, "[parmName]", p, str)
+		ps.paramName = str
+	case m.ParameterValue:
+		lib.Vln(4, "[parmVal]", p, str)
+		v, err := strconv.Atoi(str)
+		if err != nil {
+			return
+		}
+		switch m.Parameters[ps.paramName] {
+		case "nx":
+			ps.nx = v
+		case "ny":
+			ps.ny = v
+		}
+	default:
+		for _, tp := range m.Time {
+			if p == tp {
+				lib.Vln(3, "[time]", p, str)
+				ps.grid.Time = str
+				break
+			}
+		}
+	}
+}
+
+func (ps *parser) fillLocation(p string, str string) {
+	m := ps.m
+	switch p {
+	case m.Lat:
+		if v, err := strconv.ParseFloat(str, 64); err == nil {
+			ps.latStr = str
+			ps.latIdx[str] = v
+		}
+	case m.Lon:
+		if v, err := strconv.ParseFloat(str, 64); err == nil {
+			ps.lonStr = str
+			ps.lonIdx[str] = v
+		}
+	case m.ElementName:
+		ps.key = ""
+		if el, ok := m.Elements[str]; ok {
+			ps.key = el.Key
+			if el.Units != "" {
+				ps.grid.Units[el.Key] = el.Units
+			}
+		}
+	case m.Measures:
+		if ps.key == "" {
+			return
+		}
+		if _, ok := ps.grid.Units[ps.key]; !ok {
+			ps.grid.Units[ps.key] = str
+		}
+	case m.Value:
+		if ps.key == "" {
+			return
+		}
+		// 原始資料為 float32 精度
+		v, err := strconv.ParseFloat(str, 32)
+		if err != nil {
+			return
+		}
+		arr2d, ok := ps.buf[ps.key]
+		if !ok {
+			arr2d = make(map[string]map[string]lib.JsonFloat)
+			ps.buf[ps.key] = arr2d
+		}
+		row, ok := arr2d[ps.latStr]
+		if !ok {
+			row = make(map[string]lib.JsonFloat)
+			arr2d[ps.latStr] = row
+		}
+		row[ps.lonStr] = lib.JsonFloat(v)
+	}
+}
+
+// 經緯度由小到大, row 0 為最南
+func (ps *parser) finish() (*lib.VectorGrid, error) {
+	grid := ps.grid
+	if len(ps.buf) == 0 {
+		return grid, fmt.Errorf("%v: no element found", ps.m.Dataset)
+	}
+
+	latS := sortedKeys(ps.latIdx)
+	lonS := sortedKeys(ps.lonIdx)
+	ny, nx := len(latS), len(lonS)
+	if nx == 0 || ny == 0 {
+		return grid, fmt.Errorf("%v: no coordinate found (%vx%v)", ps.m.Dataset, nx, ny)
+	}
+	if (ps.nx > 0 && ps.nx != nx) || (ps.ny > 0 && ps.ny != ny) {
+		return grid, fmt.Errorf("%v: grid %vx%v not match with parameters %vx%v", ps.m.Dataset, nx, ny, ps.nx, ps.ny)
+	}
+
+	grid.Nx = nx
+	grid.Ny = ny
+	grid.Lo1 = float32(ps.lonIdx[lonS[0]])
+	grid.Lo2 = float32(ps.lonIdx[lonS[nx-1]])
+	grid.La1 = float32(ps.latIdx[latS[ny-1]])
+	grid.La2 = float32(ps.latIdx[latS[0]])
+	grid.Lons = make([]float64, nx)
+	for i, k := range lonS {
+		grid.Lons[i] = ps.lonIdx[k]
+	}
+	grid.Lats = make([]float64, ny)
+	for i, k := range latS {
+		grid.Lats[i] = ps.latIdx[k]
+	}
+
+	for key, arr2d := range ps.buf {
+		out := make([]lib.JsonFloat, 0, ny*nx)
+		for _, lat := range latS {
+			row := arr2d[lat]
+			for _, lon := range lonS {
+				v, ok := row[lon]
+				if !ok { // empty
+					v = lib.JsonFloat(math.NaN())
+				}
+				out = append(out, v)
+			}
+		}
+		grid.Data[key] = out
+		grid.CalcRange(key)
+		lib.Vln(4, "[grid]", key, len(out))
+	}
+	return grid, nil
+}
+
+func sortedKeys(idx map[string]float64) []string {
+	out := make([]string, 0, len(idx))
+	for k := range idx {
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool { return idx[out[i]] < idx[out[j]] })
+	return out
+}
@@ -0,0 +1,78 @@
+package cwbxml
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const sampleDir = "../../oceancurrent-proc/sample"
+
+func openSample(t *testing.T, name string) io.ReadCloser {
+	t.Helper()
+	fp := filepath.Join(sampleDir, name)
+	if filepath.Ext(name) != ".zip" {
+		fd, err := os.Open(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fd
+	}
+	zr, err := zip.OpenReader(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 {
+		t.Fatalf("%v: %d files in zip", name, len(zr.File))
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{rc, zr}
+}
+
+// cwbopendata (舊網域) 與 cwaopendata (新網域) 轉換後都要與 grid.json 相同
+func TestParseSamples(t *testing.T) {
+	cases := []struct {
+		dataset string
+		xml string
+		grid string
+	}{
+		{"M-B0071-000", "M-B0071-000.cwb-fixture.xml", "M-B0071-000.fixture.grid.json"},
+		{"M-B0071-000", "M-B0071-000.cwa-fixture.xml", "M-B0071-000.fixture.grid.json"},
+		{"M-B0071-000", "M-B0071-000.20200812-1530.xml.zip", "M-B0071-000.20200812-1530.grid.json"},
+	}
+	for _, c := range cases {
+		t.Run(c.xml, func(t *testing.T) {
+			m, err := Builtin(c.dataset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fd := openSample(t, c.xml)
+			defer fd.Close()
+			grid, err := Parse(fd, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(grid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(filepath.Join(sampleDir, c.grid))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, bytes.TrimSpace(want)) {
+				t.Errorf("grid differs from %v\ngot:  %.300s\nwant: %.300s", c.grid, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package dap
+
+/*
+* OPeNDAP DAP2 client
+* 只抓需要的範圍(hyperslab), 轉成 netcdf.File 後可直接用 netcdf.ToGrids
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+	"github.com/OAC-TW/oac-opendata-converters/lib/netcdf"
+)
+
+type Client struct {
+	// 下載函數, 預設直連 (lib.GetUrlFd)
+	Get func(url string) (io.ReadCloser, error)
+}
+
+// 取範圍; 索引皆包含兩端, Time[1] < 0 表示到最後一筆
+// BBox 為 minLon, minLat, maxLon, maxLat; nil 表示全部
+type Selection struct {
+	Time [2]int
+	BBox []float64
+}
+
+var (
+	latNames  = []string{"lat", "latitude", "y"}
+	lonNames  = []string{"lon", "longitude", "x"}
+	timeNames = []string{"time", "t"}
+)
+
+func (c *Client) fetch(url string) ([]byte, error) {
+	get := c.Get
+	if get == nil {
+		get = func(url string) (io.ReadCloser, error) {
+			return lib.GetUrlFd(url, nil, 10 * time.Second)
+		}
+	}
+	fd, err := get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return ioutil.ReadAll(fd)
+}
+
+// [ ] 需要escape, 不然部分伺服器會拒絕
+func escapeCE(ce string) string {
+	ce = strings.ReplaceAll(ce, "[", "%5B")
+	ce = strings.ReplaceAll(ce, "]", "%5D")
+	return ce
+}
+
+func (c *Client) DDS(base string) (*Dataset, error) {
+	buf, err := c.fetch(base + ".dds")
+	if err != nil {
+		return nil, err
+	}
+	return ParseDDS(string(buf))
+}
+
+func (c *Client) DAS(base string) (Attributes, error) {
+	buf, err := c.fetch(base + ".das")
+	if err != nil {
+		return nil, err
+	}
+	return ParseDAS(string(buf))
+}
+
+// ce: constraint expression, 例: "SST[0:1:0][0:1:0][0:1:1160][0:1:640]"
+func (c *Client) Data(base string, ce string) (*Data, error) {
+	url := base + ".dods"
+	if ce != "" {
+		url += "?" + escapeCE(ce)
+	}
+	lib.Vln(4, "[dap]get", url)
+	buf, err := c.fetch(url)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeDODS(buf)
+}
+
+// 抓 vars 在 sel 範圍內的資料; vars 必須是 Grid, 最後兩維為 lat, lon
+// 第一維為 time, 中間的維度(depth)只取第0層
+func (c *Client) Subset(base string, vars []string, sel Selection) (*netcdf.File, error) {
+	dds, err := c.DDS(base)
+	if err != nil {
+		return nil, fmt.Errorf("dds: %v", err)
+	}
+	das, err := c.DAS(base)
+	if err != nil {
+		return nil, fmt.Errorf("das: %v", err)
+	}
+
+	if len(vars) == 0 {
+		for _, v := range dds.Vars {
+			if v.Grid {
+				vars = append(vars, v.Name)
+			}
+		}
+	}
+	if len(vars) == 0 {
+		return nil, errors.New("no grid variable")
+	}
+
+	nc := netcdf.NewFile()
+	nc.Attrs = convAttrs(das["NC_GLOBAL"])
+	ranges := make(map[string][2]int)
+	for _, name := range vars {
+		v, ok := dds.Var(name)
+		if !ok {
+			return nil, fmt.Errorf("variable not found: %v", name)
+		}
+		if !v.Grid || len(v.Dims) < 3 {
+			return nil, fmt.Errorf("%v is not a (time, ..., lat, lon) grid", name)
+		}
+
+		// 先抓座標算範圍, 每個維度只算一次
+		n := len(v.Dims)
+		ce := make([]string, n)
+		for i, m := range v.Maps {
+			r, ok := ranges[m.Name]
+			if !ok {
+				r, err = c.dimRange(base, m, i, n, sel)
+				if err != nil {
+					return nil, err
+				}
+				ranges[m.Name] = r
+			}
+			ce[i] = fmt.Sprintf("[%v:1:%v]", r[0], r[1])
+		}
+		data, err := c.Data(base, name + strings.Join(ce, ""))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+
+		dv, val, ok := data.Var(name)
+		if !ok {
+			return nil, fmt.Errorf("%v: missing in response", name)
+		}
+		dims := make([]int, 0, n)
+		for i, m := range v.Maps {
+			_, mval, ok := data.Var(m.Name)
+			if !ok {
+				return nil, fmt.Errorf("%v: map %v missing in response", name, m.Name)
+			}
+			id := nc.DimID(m.Name)
+			if id < 0 {
+				id = nc.AddDim(m.Name, dv.Dims[i].Size)
+				typ, arr := convData(mval)
+				nc.AddVar(m.Name, typ, []int{id}, arr, convAttrs(das[m.Name])...)
+			} else if nc.Dims[id].Len != dv.Dims[i].Size {
+				return nil, fmt.Errorf("%v: dimension %v size %v, expect %v", name, m.Name, dv.Dims[i].Size, nc.Dims[id].Len)
+			}
+			dims = append(dims, id)
+		}
+		typ, arr := convData(val)
+		nc.AddVar(name, typ, dims, arr, convAttrs(das[name])...)
+		lib.Vln(4, "[dap]", name, dv.Dims)
+	}
+	return nc, nil
+}
+
+// 第 idx 維(共 n 維)的索引範圍
+func (c *Client) dimRange(base string, m *Variable, idx int, n int, sel Selection) ([2]int, error) {
+	size := 1
+	if len(m.Dims) > 0 {
+		size = m.Dims[0].Size
+	}
+	last := size - 1
+	switch {
+	case idx == 0 && matchName(m.Name, timeNames):
+		r := sel.Time
+		if r[1] < 0 || r[1] > last {
+			r[1] = last
+		}
+		if r[0] < 0 || r[0] > r[1] {
+			return r, fmt.Errorf("bad time range %v for size %v", sel.Time, size)
+		}
+		return r, nil
+	case idx == n-2 || idx == n-1:
+		if sel.BBox == nil {
+			return [2]int{0, last}, nil
+		}
+		if len(sel.BBox) != 4 {
+			return [2]int{}, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+		}
+		lo, hi := sel.BBox[1], sel.BBox[3]
+		if idx == n-1 {
+			lo, hi = sel.BBox[0], sel.BBox[2]
+		}
+		data, err := c.Data(base, m.Name)
+		if err != nil {
+			return [2]int{}, fmt.Errorf("%v: %v", m.Name, err)
+		}
+		_, val, ok := data.Var(m.Name)
+		if !ok {
+			return [2]int{}, fmt.Errorf("%v: missing in response", m.Name)
+		}
+		return indexRange(toFloat64s(val), lo, hi)
+	}
+	// depth 等其他維度只取第0層
+	return [2]int{0, 0}, nil
+}
+
+// 座標落在 [lo, hi] 內的最小/最大索引, 座標可遞增或遞減
+func indexRange(coord []float64, lo float64, hi float64) ([2]int, error) {
+	first, end := -1, -1
+	for i, x := range coord {
+		if x >= lo && x <= hi {
+			if first < 0 {
+				first = i
+			}
+			end = i
+		}
+	}
+	if first < 0 {
+		return [2]int{}, fmt.Errorf("no coordinate in range [%v, %v]", lo, hi)
+	}
+	return [2]int{first, end}, nil
+}
+
+func matchName(name string, list []string) bool {
+	for _, s := range list {
+		if strings.EqualFold(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func toFloat64s(val interface{}) []float64 {
+	typ, arr := convData(val)
+	a := netcdf.Attr{Type: typ, Value: arr}
+	return a.Float64s()
+}
+
+// DAP 型態 >> NetCDF-3 型態 (NetCDF-3 沒有 unsigned, 往上一級)
+func convData(val interface{}) (netcdf.Type, interface{}) {
+	switch d := val.(type) {
+	case []uint8:
+		out := make([]int16, len(d))
+		for i, x := range d {
+			out[i] = int16(x)
+		}
+		return netcdf.Short, out
+	case []int16:
+		return netcdf.Short, d
+	case []uint16:
+		out := make([]int32, len(d))
+		for i, x := range d {
+			out[i] = int32(x)
+		}
+		return netcdf.Int, out
+	case []int32:
+		return netcdf.Int, d
+	case []uint32:
+		out := make([]float64, len(d))
+		for i, x := range d {
+			out[i] = float64(x)
+		}
+		return netcdf.Double, out
+	case []float32:
+		return netcdf.Float, d
+	case []float64:
+		return netcdf.Double, d
+	case []string:
+		return netcdf.Char, []byte(strings.Join(d, ""))
+	}
+	return netcdf.Double, []float64{}
+}
+
+func convAttrs(list []Attr) []netcdf.Attr {
+	out := make([]netcdf.Attr, 0, len(list))
+	for _, a := range list {
+		switch a.Type {
+		case "String", "Url":
+			out = append(out, netcdf.StringAttr(a.Name, strings.Join(a.Values, "\n")))
+		case "Byte", "Int16":
+			arr := make([]int16, 0, len(a.Values))
+			for _, s := range a.Values {
+				x, _ := strconv.ParseInt(s, 0, 16)
+				arr = append(arr, int16(x))
+			}
+			out = append(out, netcdf.ShortAttr(a.Name, arr...))
+		case "UInt16", "Int32":
+			arr := make([]int32, 0, len(a.Values))
+			for _, s := range a.Values {
+				x, _ := strconv.ParseInt(s, 0, 32)
+				arr = append(arr, int32(x))
+			}
+			out = append(out, netcdf.IntAttr(a.Name, arr...))
+		case "Float32":
+			arr := make([]float32, 0, len(a.Values))
+			for _, s := range a.Values {
+				x, err := strconv.ParseFloat(s, 32)
+				if err != nil {
+					x = math.NaN()
+				}
+				arr = append(arr, float32(x))
+			}
+			out = append(out, netcdf.FloatAttr(a.Name, arr...))
+		case "UInt32", "Float64":
+			arr := make([]float64, 0, len(a.Values))
+			for _, s := range a.Values {
+				x, err := strconv.ParseFloat(s, 64)
+				if err != nil {
+					x = math.NaN()
+				}
+				arr = append(arr, x)
+			}
+			out = append(out, netcdf.DoubleAttr(a.Name, arr...))
+		}
+	}
+	return out
+}
@@ -0,0 +1,221 @@
+package dap
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib/netcdf"
+)
+
+// testdata/ocm.*: time(2) x depth(1) x lat(4) x lon(5), SST (Float32), WL (Int16), MASK (Byte)
+// .dods 依 constraint expression 對應到檔案, 與 Subset 送出的範圍一致
+var fixtureCE = map[string]string{
+	"lat": "ocm-lat.dods",
+	"lon": "ocm-lon.dods",
+	"SST[1:1:1][0:1:0][1:1:2][1:1:3]": "ocm-SST.dods",
+	"WL[1:1:1][0:1:0][1:1:2][1:1:3]": "ocm-WL.dods",
+	"MASK[1:1:1][0:1:0][1:1:2][1:1:3]": "ocm-MASK.dods",
+}
+
+type fixtureServer struct {
+	*httptest.Server
+	mu sync.Mutex
+	ces []string
+}
+
+func newFixtureServer(t *testing.T) *fixtureServer {
+	fs := &fixtureServer{}
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := ""
+		switch r.URL.Path {
+		case "/ocm.nc.dds":
+			name = "ocm.dds"
+		case "/ocm.nc.das":
+			name = "ocm.das"
+		case "/ocm.nc.dods":
+			if strings.ContainsAny(r.URL.RawQuery, "[]") {
+				t.Errorf("unescaped constraint: %v", r.URL.RawQuery)
+			}
+			ce, err := url.QueryUnescape(r.URL.RawQuery)
+			if err != nil {
+				t.Errorf("bad query %v: %v", r.URL.RawQuery, err)
+			}
+			fs.mu.Lock()
+			fs.ces = append(fs.ces, ce)
+			fs.mu.Unlock()
+			name = fixtureCE[ce]
+			if name == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Error {\n    code = 1005;\n    message = \"unexpected constraint " + ce + "\";\n};\n"))
+				return
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		buf, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(buf)
+	}))
+	return fs
+}
+
+func TestSubset(t *testing.T) {
+	srv := newFixtureServer(t)
+	defer srv.Close()
+
+	c := &Client{}
+	sel := Selection{Time: [2]int{1, 1}, BBox: []float64{118.5, 21.5, 121.2, 23.5}}
+	nc, err := c.Subset(srv.URL + "/ocm.nc", []string{"SST", "WL", "MASK"}, sel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 座標只抓一次
+	count := make(map[string]int)
+	for _, ce := range srv.ces {
+		count[ce]++
+	}
+	for ce := range fixtureCE {
+		if count[ce] != 1 {
+			t.Errorf("%v requested %d times", ce, count[ce])
+		}
+	}
+
+	wantDims := []netcdf.Dim{{Name: "time", Len: 1}, {Name: "depth", Len: 1}, {Name: "lat", Len: 2}, {Name: "lon", Len: 3}}
+	if len(nc.Dims) != len(wantDims) {
+		t.Fatalf("dims = %v, want %v", nc.Dims, wantDims)
+	}
+	for i, d := range wantDims {
+		if nc.Dims[i] != d {
+			t.Errorf("dim %d = %v, want %v", i, nc.Dims[i], d)
+		}
+	}
+	if a, ok := nc.Attr("title"); !ok || a.String() != "OCM test" {
+		t.Errorf("title = %v", a)
+	}
+
+	// Grid maps
+	checkFloats(t, nc, "time", []float64{3})
+	checkFloats(t, nc, "lat", []float64{22, 23})
+	checkFloats(t, nc, "lon", []float64{119, 120, 121})
+
+	sst := make([]float64, 0, 6)
+	wl := make([]int16, 0, 6)
+	mask := make([]int16, 0, 6)
+	for y := 1; y <= 2; y++ {
+		for x := 1; x <= 3; x++ {
+			sst = append(sst, 20 + 1 + float64(y) * 0.5 + float64(x) * 0.25)
+			wl = append(wl, int16(100 + y * 10 + x - 50))
+			mask = append(mask, int16(200 + y * 5 + x + 1))
+		}
+	}
+	checkFloats(t, nc, "SST", sst)
+
+	// Int16 以4 bytes傳送, 轉成 short; DAS 的 scale_factor 保留
+	v, ok := nc.Var("WL")
+	if !ok {
+		t.Fatal("WL missing")
+	}
+	if v.Type != netcdf.Short || !equalInt16(v.Data.([]int16), wl) {
+		t.Errorf("WL = %v %v, want short %v", v.Type, v.Data, wl)
+	}
+	if a, ok := v.Attr("scale_factor"); !ok || math.Abs(a.Float64s()[0] - 0.01) > 1e-6 {
+		t.Errorf("WL scale_factor = %v", a)
+	}
+
+	// Byte 6個, 補2 bytes後才是 maps; 沒有 unsigned 所以轉成 short
+	v, ok = nc.Var("MASK")
+	if !ok {
+		t.Fatal("MASK missing")
+	}
+	if v.Type != netcdf.Short || !equalInt16(v.Data.([]int16), mask) {
+		t.Errorf("MASK = %v %v, want short %v", v.Type, v.Data, mask)
+	}
+
+	// 可直接轉成 grid
+	grids, times, err := netcdf.ToGrids(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grids) != 1 || times[0].Format("2006-01-02 15") != "2024-01-02 03" {
+		t.Fatalf("grids %d, times %v", len(grids), times)
+	}
+	g := grids[0]
+	if g.Nx != 3 || g.Ny != 2 || g.Lo1 != 119 || g.Lo2 != 121 || g.La1 != 23 || g.La2 != 22 {
+		t.Errorf("grid %v,%v %v-%v %v-%v", g.Nx, g.Ny, g.Lo1, g.Lo2, g.La2, g.La1)
+	}
+	if len(g.Data["海表溫度"]) != 6 || float64(g.Data["海表溫度"][0]) != sst[0] {
+		t.Errorf("海表溫度 = %v", g.Data["海表溫度"])
+	}
+	if len(g.Data["海高"]) != 6 || math.Abs(float64(g.Data["海高"][5]) - float64(wl[5]) * 0.01) > 1e-6 {
+		t.Errorf("海高 = %v", g.Data["海高"])
+	}
+}
+
+func TestSubsetErrors(t *testing.T) {
+	srv := newFixtureServer(t)
+	defer srv.Close()
+
+	c := &Client{}
+	base := srv.URL + "/ocm.nc"
+	cases := []struct {
+		name string
+		vars []string
+		sel Selection
+	}{
+		{"unknown variable", []string{"NOPE"}, Selection{Time: [2]int{0, -1}}},
+		{"not a grid", []string{"lat"}, Selection{Time: [2]int{0, -1}}},
+		{"bbox outside", []string{"SST"}, Selection{Time: [2]int{0, -1}, BBox: []float64{0, 0, 1, 1}}},
+		{"bad time", []string{"SST"}, Selection{Time: [2]int{5, 1}}},
+	}
+	for _, tc := range cases {
+		if _, err := c.Subset(base, tc.vars, tc.sel); err == nil {
+			t.Errorf("%v: no error", tc.name)
+		}
+	}
+}
+
+func checkFloats(t *testing.T, nc *netcdf.File, name string, want []float64) {
+	t.Helper()
+	v, ok := nc.Var(name)
+	if !ok {
+		t.Errorf("%v missing", name)
+		return
+	}
+	a := netcdf.Attr{Type: v.Type, Value: v.Data}
+	got := a.Float64s()
+	if len(got) != len(want) {
+		t.Errorf("%v = %v, want %v", name, got, want)
+		return
+	}
+	for i := range want {
+		if math.Abs(got[i] - want[i]) > 1e-6 {
+			t.Errorf("%v = %v, want %v", name, got, want)
+			return
+		}
+	}
+}
+
+func equalInt16(a []int16, b []int16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+package dap
+
+/*
+* .dods 回應: 受限後的 DDS 文字 + "Data:\n" + XDR 二進位資料
+* 陣列前面有兩次長度; Byte 陣列緊密排列後補齊4 bytes; Int16/UInt16 以4 bytes傳送
+* Grid 依序為陣列本身以及各個 MAPS
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrNoData = errors.New("no Data section in DODS response")
+	ErrShort  = errors.New("unexpected end of DODS data")
+)
+
+var dataMark = []byte("\nData:\n")
+
+// 變數名 >> 值
+// Byte: []uint8, Int16: []int16, UInt16: []uint16, Int32: []int32, UInt32: []uint32,
+// Float32: []float32, Float64: []float64, String/Url: []string
+type Data struct {
+	DDS    *Dataset
+	Values map[string]interface{}
+}
+
+func (d *Data) Var(name string) (*Variable, interface{}, bool) {
+	val, ok := d.Values[name]
+	if !ok {
+		return nil, nil, false
+	}
+	if v, ok := d.DDS.Var(name); ok {
+		return v, val, true
+	}
+	for _, v := range d.DDS.Vars {
+		for _, m := range v.Maps {
+			if m.Name == name {
+				return m, val, true
+			}
+		}
+	}
+	return nil, val, true
+}
+
+func DecodeDODS(body []byte) (*Data, error) {
+	idx := bytes.Index(body, dataMark)
+	if idx < 0 {
+		// 伺服器錯誤會回傳 Error { ... }
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("Error")) {
+			return nil, fmt.Errorf("server error: %s", bytes.TrimSpace(body))
+		}
+		return nil, ErrNoData
+	}
+	dds, err := ParseDDS(string(body[:idx]))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &xdrReader{buf: body[idx+len(dataMark):]}
+	out := &Data{
+		DDS:    dds,
+		Values: make(map[string]interface{}, len(dds.Vars)),
+	}
+	for _, v := range dds.Vars {
+		val, err := r.variable(v)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", v.Name, err)
+		}
+		out.Values[v.Name] = val
+		for _, m := range v.Maps {
+			val, err := r.variable(m)
+			if err != nil {
+				return nil, fmt.Errorf("%v.%v: %v", v.Name, m.Name, err)
+			}
+			out.Values[m.Name] = val
+		}
+	}
+	return out, nil
+}
+
+type xdrReader struct {
+	buf []byte
+	off int
+}
+
+func (r *xdrReader) take(n int) ([]byte, error) {
+	if r.off+n > len(r.buf) {
+		return nil, ErrShort
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b, nil
+}
+
+func (r *xdrReader) uint32() (uint32, error) {
+	b, err := r.take(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (r *xdrReader) variable(v *Variable) (interface{}, error) {
+	n := 1
+	for _, d := range v.Dims {
+		n *= d.Size
+	}
+
+	isArray := len(v.Dims) > 0
+	if isArray {
+		cnt, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		if int(cnt) != n {
+			return nil, fmt.Errorf("array length %v, expect %v", cnt, n)
+		}
+		// String 陣列只有一次長度
+		if v.Type != "String" && v.Type != "Url" {
+			if _, err := r.uint32(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch v.Type {
+	case "Byte":
+		if !isArray {
+			// 純量 Byte 也佔4 bytes
+			x, err := r.uint32()
+			return []uint8{uint8(x)}, err
+		}
+		b, err := r.take(n)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.take(int((4 - n%4) % 4)); err != nil {
+			return nil, err
+		}
+		out := make([]uint8, n)
+		copy(out, b)
+		return out, nil
+	case "Int16":
+		out := make([]int16, n)
+		for i := range out {
+			x, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			out[i] = int16(int32(x))
+		}
+		return out, nil
+	case "UInt16":
+		out := make([]uint16, n)
+		for i := range out {
+			x, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			out[i] = uint16(x)
+		}
+		return out, nil
+	case "Int32":
+		out := make([]int32, n)
+		for i := range out {
+			x, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			out[i] = int32(x)
+		}
+		return out, nil
+	case "UInt32":
+		out := make([]uint32, n)
+		for i := range out {
+			x, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			out[i] = x
+		}
+		return out, nil
+	case "Float32":
+		out := make([]float32, n)
+		for i := range out {
+			x, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			out[i] = math.Float32frombits(x)
+		}
+		return out, nil
+	case "Float64":
+		out := make([]float64, n)
+		for i := range out {
+			b, err := r.take(8)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+		return out, nil
+	case "String", "Url":
+		out := make([]string, n)
+		for i := range out {
+			l, err := r.uint32()
+			if err != nil {
+				return nil, err
+			}
+			b, err := r.take(int(l))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := r.take(int((4 - l%4) % 4)); err != nil {
+				return nil, err
+			}
+			out[i] = string(b)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported type %v", v.Type)
+}
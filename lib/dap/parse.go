@@ -0,0 +1,313 @@
+package dap
+
+/*
+* DAP2 (OPeNDAP 2.0) 文字格式解析: .dds, .das
+* https://www.opendap.org/pdf/ESE-RFC-004v1.2.pdf
+*/
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type Dim struct {
+	Name string
+	Size int
+}
+
+// Array/Grid/純量; Grid 的 Maps 為各維度的座標
+type Variable struct {
+	Name string
+	Type string // Byte, Int16, UInt16, Int32, UInt32, Float32, Float64, String, Url
+	Dims []Dim
+	Grid bool
+	Maps []*Variable
+}
+
+type Dataset struct {
+	Name string
+	Vars []*Variable
+}
+
+func (ds *Dataset) Var(name string) (*Variable, bool) {
+	for _, v := range ds.Vars {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// ==== tokenizer ====
+type tokenizer struct {
+	src []rune
+	pos int
+}
+
+const punct = "{}[];:=,"
+
+func (t *tokenizer) next() (string, bool) {
+	for t.pos < len(t.src) && unicode.IsSpace(t.src[t.pos]) {
+		t.pos++
+	}
+	if t.pos >= len(t.src) {
+		return "", false
+	}
+	c := t.src[t.pos]
+	if strings.ContainsRune(punct, c) {
+		t.pos++
+		return string(c), true
+	}
+	if c == '"' {
+		var sb strings.Builder
+		sb.WriteRune(c)
+		t.pos++
+		for t.pos < len(t.src) {
+			c = t.src[t.pos]
+			t.pos++
+			if c == '\\' && t.pos < len(t.src) {
+				sb.WriteRune(c)
+				sb.WriteRune(t.src[t.pos])
+				t.pos++
+				continue
+			}
+			sb.WriteRune(c)
+			if c == '"' {
+				break
+			}
+		}
+		return sb.String(), true
+	}
+	st := t.pos
+	for t.pos < len(t.src) {
+		c = t.src[t.pos]
+		if unicode.IsSpace(c) || strings.ContainsRune(punct, c) || c == '"' {
+			break
+		}
+		t.pos++
+	}
+	return string(t.src[st:t.pos]), true
+}
+
+func (t *tokenizer) peek() string {
+	pos := t.pos
+	tok, _ := t.next()
+	t.pos = pos
+	return tok
+}
+
+func (t *tokenizer) expect(want string) error {
+	tok, ok := t.next()
+	if !ok || !strings.EqualFold(tok, want) {
+		return fmt.Errorf("expect %q, got %q", want, tok)
+	}
+	return nil
+}
+
+// ==== DDS ====
+func ParseDDS(text string) (*Dataset, error) {
+	t := &tokenizer{src: []rune(text)}
+	if err := t.expect("Dataset"); err != nil {
+		return nil, err
+	}
+	if err := t.expect("{"); err != nil {
+		return nil, err
+	}
+	ds := &Dataset{}
+	vars, err := parseDecls(t)
+	if err != nil {
+		return nil, err
+	}
+	ds.Vars = vars
+	name, _ := t.next()
+	ds.Name = name
+	return ds, nil
+}
+
+// 讀到 '}' 為止
+func parseDecls(t *tokenizer) ([]*Variable, error) {
+	out := make([]*Variable, 0, 8)
+	for {
+		tok, ok := t.next()
+		if !ok {
+			return nil, errors.New("unexpected end of DDS")
+		}
+		switch strings.ToLower(tok) {
+		case "}":
+			return out, nil
+		case "grid":
+			v, err := parseGrid(t)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		case "structure", "sequence":
+			// 不支援巢狀結構, 只跳過
+			if err := t.expect("{"); err != nil {
+				return nil, err
+			}
+			if _, err := parseDecls(t); err != nil {
+				return nil, err
+			}
+			t.next() // name
+			if err := t.expect(";"); err != nil {
+				return nil, err
+			}
+		default:
+			v, err := parseArray(t, tok)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+	}
+}
+
+// Float32 SST[time = 120][lat = 1161];
+func parseArray(t *tokenizer, typ string) (*Variable, error) {
+	name, ok := t.next()
+	if !ok {
+		return nil, errors.New("unexpected end of DDS")
+	}
+	v := &Variable{Name: name, Type: typ}
+	for t.peek() == "[" {
+		t.next()
+		a, _ := t.next()
+		d := Dim{}
+		if t.peek() == "=" {
+			t.next()
+			d.Name = a
+			a, _ = t.next()
+		}
+		n, err := strconv.Atoi(a)
+		if err != nil {
+			return nil, fmt.Errorf("bad dimension size %q of %v", a, name)
+		}
+		d.Size = n
+		if err := t.expect("]"); err != nil {
+			return nil, err
+		}
+		v.Dims = append(v.Dims, d)
+	}
+	if err := t.expect(";"); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func parseGrid(t *tokenizer) (*Variable, error) {
+	if err := t.expect("{"); err != nil {
+		return nil, err
+	}
+	if err := t.expect("ARRAY"); err != nil {
+		return nil, err
+	}
+	if err := t.expect(":"); err != nil {
+		return nil, err
+	}
+	typ, _ := t.next()
+	arr, err := parseArray(t, typ)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.expect("MAPS"); err != nil {
+		return nil, err
+	}
+	if err := t.expect(":"); err != nil {
+		return nil, err
+	}
+	maps, err := parseDecls(t)
+	if err != nil {
+		return nil, err
+	}
+	name, _ := t.next()
+	if err := t.expect(";"); err != nil {
+		return nil, err
+	}
+	arr.Name = name
+	arr.Grid = true
+	arr.Maps = maps
+	return arr, nil
+}
+
+// ==== DAS ====
+type Attr struct {
+	Name string
+	Type string
+	Values []string // 字串已去掉引號
+}
+
+// 變數名 >> 屬性; 全域屬性通常在 NC_GLOBAL
+type Attributes map[string][]Attr
+
+func ParseDAS(text string) (Attributes, error) {
+	t := &tokenizer{src: []rune(text)}
+	if err := t.expect("Attributes"); err != nil {
+		return nil, err
+	}
+	if err := t.expect("{"); err != nil {
+		return nil, err
+	}
+	out := make(Attributes)
+	err := parseAttrTable(t, "", out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func parseAttrTable(t *tokenizer, prefix string, out Attributes) error {
+	for {
+		tok, ok := t.next()
+		if !ok {
+			return errors.New("unexpected end of DAS")
+		}
+		if tok == "}" {
+			return nil
+		}
+
+		// 容器: name { ... }
+		if t.peek() == "{" {
+			t.next()
+			name := tok
+			if prefix != "" {
+				name = prefix + "." + tok
+			}
+			if _, ok := out[name]; !ok {
+				out[name] = []Attr{}
+			}
+			if err := parseAttrTable(t, name, out); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// 屬性: Type name value[, value...];
+		a := Attr{Type: tok}
+		a.Name, _ = t.next()
+		for {
+			val, ok := t.next()
+			if !ok {
+				return errors.New("unexpected end of DAS")
+			}
+			if val == ";" {
+				break
+			}
+			if val == "," {
+				continue
+			}
+			if strings.HasPrefix(val, "\"") {
+				if s, err := strconv.Unquote(val); err == nil {
+					val = s
+				} else {
+					val = strings.Trim(val, "\"")
+				}
+			}
+			a.Values = append(a.Values, val)
+		}
+		out[prefix] = append(out[prefix], a)
+	}
+}
@@ -0,0 +1,249 @@
+package dataset
+
+/*
+* 資料集抽象: 下載 >> 解碼成一或多個 VectorGrid (附有效時間) >> 輸出
+* 新的資料集只要實作 Dataset 並 Register, 不需要再複製一份 main()
+*/
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+// 一個時間一個grid
+type Frame struct {
+	Time time.Time // 有效時間
+	Lead int // 距模式起始時間的小時數
+	Grid *lib.VectorGrid
+
+	// 部分檔案缺少/解析失敗時缺少的變數 (Grid 可能為 nil), 見 Partial
+	Missing []string
+	Policy Partial // 套用的處理方式, 記在 index.json
+}
+
+type Result struct {
+	RunTime time.Time // 模式起始時間, 沒有時為 zero
+	Frames []*Frame // 依時間排序
+	Warnings []string // 解碼時略過的資料等, 記在 manifest
+	Skipped []*Frame // 依 Partial 略過的 frame, 記在 index.json
+}
+
+type FetchOptions struct {
+	URL string // 空白時用 SourceURL()
+	Token string
+	Dial lib.DialFunc // 含連線逾時
+	TLSTimeout time.Duration
+	Total time.Duration // 整次下載 (含重試)
+	Retries int
+	MaxSize int64 // bytes, 0: 不限制
+}
+
+type Output struct {
+	Dir string
+	NetCDF bool // 另外輸出 .nc
+	Contours map[string][]float64 // 變數 >> 等值線分級
+	Partial Partial // 不完整的 frame, 空白為 skip
+
+	Log *slog.Logger // 帶有 dataset, run_id 欄位, 由 Run/Convert 設定
+	Removed []string // Publish 列出的舊檔 (通知接收端刪除), 上傳成功後由 RemoveStale 刪除本地檔案
+	Manifest *Manifest // 由 WithRun 建立, 執行結束時寫到 Dir
+}
+
+func (out *Output) logger() *slog.Logger {
+	if out.Log == nil {
+		return lib.Logger
+	}
+	return out.Log
+}
+
+// log 並記在 manifest
+func (out *Output) warn(msg string, args ...any) {
+	out.logger().Warn(msg, args...)
+	if out.Manifest != nil {
+		for i := 0; i+1 < len(args); i += 2 {
+			msg += fmt.Sprintf(" %v=%v", args[i], args[i+1])
+		}
+		out.Manifest.Warnings = append(out.Manifest.Warnings, lib.Redact(msg))
+	}
+}
+
+type Dataset interface {
+	ID() string
+	Description() string
+
+	// 下載網址樣板, %v 為授權碼 (見 lib.AuthRequest)
+	SourceURL() string
+
+	// 原始檔 (XML, ZIP...)
+	Fetch(opt *FetchOptions) ([]byte, error)
+	Decode(raw []byte) (*Result, error)
+
+	// 寫到 out.Dir, 回傳寫出的檔名
+	Publish(res *Result, out *Output) ([]string, error)
+}
+
+var registry = make(map[string]Dataset)
+
+func Register(ds Dataset) {
+	if _, ok := registry[ds.ID()]; ok {
+		panic("dataset: Register called twice for " + ds.ID())
+	}
+	registry[ds.ID()] = ds
+}
+
+func Get(id string) (Dataset, error) {
+	ds, ok := registry[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown dataset %q", id)
+	}
+	return ds, nil
+}
+
+// 已註冊的資料集編號, 依字母排序
+func List() []string {
+	out := make([]string, 0, len(registry))
+	for id := range registry {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// 每次執行一個ID, 20201019T093000-1a2b3c4d (UTC)
+func NewRunID() string {
+	var b [4]byte
+	rand.Read(b[:])
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b[:])
+}
+
+// 下載 >> 解碼 >> 輸出
+func Run(ds Dataset, opt *FetchOptions, out *Output) ([]string, error) {
+	o := WithRun(ds, out)
+	raw, err := Fetch(ds, opt, o)
+	if err != nil {
+		return nil, err
+	}
+	return convert(ds, raw, o)
+}
+
+// 下載並記錄時間/大小/HTTP狀態
+func Fetch(ds Dataset, opt *FetchOptions, out *Output) ([]byte, error) {
+	if out.Manifest != nil {
+		tmpl := opt.URL
+		if tmpl == "" {
+			tmpl = ds.SourceURL()
+		}
+		aurl, _ := lib.AuthRequest(tmpl, opt.Token)
+		out.Manifest.Source.URL = lib.RedactURL(aurl)
+	}
+	start := time.Now()
+	raw, err := ds.Fetch(opt)
+	recordFetch(ds.ID(), len(raw), time.Since(start), err)
+	if err != nil {
+		recordFailure(ds.ID())
+		out.finish(nil, err)
+		return nil, err
+	}
+	out.logger().Info("fetched", "bytes", len(raw), "duration", time.Since(start))
+	return raw, nil
+}
+
+// 由已有的原始檔輸出
+func Convert(ds Dataset, raw []byte, out *Output) ([]string, error) {
+	return convert(ds, raw, WithRun(ds, out))
+}
+
+// 複製一份帶有新 run_id 的 Output; 已經有 Log 時沿用 (同一次執行)
+func WithRun(ds Dataset, out *Output) *Output {
+	if out.Log != nil {
+		return out
+	}
+	o := *out
+	runID := NewRunID()
+	o.Log = lib.Logger.With("dataset", ds.ID(), "run_id", runID)
+	o.Manifest = newManifest(ds, runID)
+	return &o
+}
+
+// 寫 manifest.json, 失敗只記log
+func (out *Output) finish(files []string, err error) {
+	if out.Manifest == nil {
+		return
+	}
+	if e := out.Manifest.finish(out.Dir, files, err); e != nil {
+		out.logger().Error("write manifest failed", "err", e)
+	}
+}
+
+func convert(ds Dataset, raw []byte, out *Output) ([]string, error) {
+	if out.Manifest != nil {
+		out.Manifest.setSource(raw)
+	}
+	start := time.Now()
+	res, err := ds.Decode(raw)
+	if err != nil {
+		recordFailure(ds.ID())
+		out.finish(nil, err)
+		return nil, err
+	}
+	if out.Manifest != nil {
+		out.Manifest.Warnings = append(out.Manifest.Warnings, res.Warnings...)
+		if !res.RunTime.IsZero() {
+			t := res.RunTime.UTC()
+			out.Manifest.RunTime = &t
+		}
+	}
+	if err := applyPartial(res, out); err != nil {
+		out.Log.Error("incomplete data, not published", "err", err)
+		recordFailure(ds.ID())
+		out.finish(nil, err)
+		return nil, err
+	}
+	recordDecode(ds.ID(), res, time.Since(start))
+	out.Log.Info("decoded", "frames", len(res.Frames), "skipped", len(res.Skipped), "duration", time.Since(start))
+
+	if err := Validate(ds, res); err != nil {
+		out.Log.Error("validation failed, not published", "err", err)
+		recordFailure(ds.ID())
+		out.finish(nil, err)
+		return nil, err
+	}
+
+	start = time.Now()
+	out.Removed = nil
+	files, err := ds.Publish(res, out)
+	out.finish(files, err)
+	if err != nil {
+		recordFailure(ds.ID())
+		return files, err
+	}
+	recordPublish(ds.ID(), len(res.Frames), len(out.Removed))
+	out.Log.Info("published", "frames", len(res.Frames), "files", len(files), "removed", len(out.Removed), "dir", out.Dir, "duration", time.Since(start))
+	return files, nil
+}
+
+// 共用的下載: 授權碼放在網址或 Authorization header
+// 中斷時重試/續傳, verify 非 nil 時下載後檢查內容 (失敗整檔重下)
+func fetchURL(ds Dataset, opt *FetchOptions, verify func([]byte) error) ([]byte, error) {
+	tmpl := opt.URL
+	if tmpl == "" {
+		tmpl = ds.SourceURL()
+	}
+	aurl, header := lib.AuthRequest(tmpl, opt.Token)
+	lib.Logger.Debug("download start", "dataset", ds.ID())
+	return lib.Download(aurl, &lib.DownloadOptions{
+		Header: header,
+		Dial: opt.Dial,
+		TLSTimeout: opt.TLSTimeout,
+		Total: opt.Total,
+		Retries: opt.Retries,
+		MaxSize: opt.MaxSize,
+		Verify: verify,
+	})
+}
@@ -0,0 +1,56 @@
+package dataset
+
+import (
+	"strings"
+	"testing"
+)
+
+// 換掉 ID 的 M-B0071-000
+type renamed struct {
+	*MB0071
+	id string
+}
+
+func (ds *renamed) ID() string {
+	return ds.id
+}
+
+func TestRegistry(t *testing.T) {
+	// init() 註冊的資料集
+	if got := strings.Join(List(), ","); got != "F-A0020-001,M-B0071-000" {
+		t.Errorf("List() = %v", got)
+	}
+	for _, id := range List() {
+		ds, err := Get(id)
+		if err != nil || ds.ID() != id {
+			t.Errorf("Get(%q) = %v, %v", id, ds, err)
+		}
+	}
+	if ds, err := Get("NWW3"); err == nil || ds != nil || !strings.Contains(err.Error(), `unknown dataset "NWW3"`) {
+		t.Errorf("Get(NWW3) = %v, %v; NWW3 is not registered", ds, err)
+	}
+
+	ds := &renamed{MB0071: &MB0071{}, id: "A-TEST-001"}
+	Register(ds)
+	t.Cleanup(func() { delete(registry, ds.id) })
+	if got, err := Get("A-TEST-001"); err != nil || got != Dataset(ds) {
+		t.Errorf("Get after Register = %v, %v", got, err)
+	}
+	if got := List(); len(got) != 3 || got[0] != "A-TEST-001" {
+		t.Errorf("List() = %v, want sorted", got)
+	}
+
+	// 同名重複註冊 panic, 原本的不被換掉
+	func() {
+		defer func() {
+			r := recover()
+			if s, _ := r.(string); !strings.Contains(s, "Register called twice for A-TEST-001") {
+				t.Errorf("recover() = %v", r)
+			}
+		}()
+		Register(&renamed{MB0071: &MB0071{}, id: "A-TEST-001"})
+	}()
+	if got, _ := Get("A-TEST-001"); got != Dataset(ds) {
+		t.Errorf("replaced by the duplicate: %v", got)
+	}
+}
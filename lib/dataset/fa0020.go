@@ -0,0 +1,192 @@
+package dataset
+
+/*
+* 波浪預報模式資料-臺灣海域預報資料
+* https://opendata.cwa.gov.tw/dataset/climate/F-A0020-001
+* ZIP 內每個時間有 dir, hs, t 三個XML, 合併為一個grid
+*/
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+	"github.com/OAC-TW/oac-opendata-converters/lib/cwbxml"
+	"github.com/OAC-TW/oac-opendata-converters/lib/validate"
+)
+
+// Mapping 為 nil 時用內建的 F-A0020-001 (oceanwave-proc -mapping 可另外指定)
+type FA0020 struct {
+	Mapping *cwbxml.Mapping
+}
+
+func init() {
+	Register(&FA0020{})
+}
+
+// 20072318-dir.000.xml
+var fa0020Rx = regexp.MustCompile(`^([0-9]{8,8})-([dhirst]{1,3})\.([0-9]{3,3})\.xml$`)
+
+var fa0020Parts = []string{"dir", "hs", "t"}
+
+// 與 fa0020Parts 對應
+var fa0020Vars = []string{"浪向", "浪高", "週期"}
+
+func (ds *FA0020) ID() string {
+	return "F-A0020-001"
+}
+
+func (ds *FA0020) Description() string {
+	return "波浪預報模式資料-臺灣海域預報資料 (浪高、週期、浪向)"
+}
+
+func (ds *FA0020) SourceURL() string {
+	return "https://opendata.cwa.gov.tw/fileapi/v1/opendataapi/F-A0020-001?Authorization=%v&downloadType=WEB&format=ZIP"
+}
+
+func (ds *FA0020) Fetch(opt *FetchOptions) ([]byte, error) {
+	return fetchURL(ds, opt, lib.CheckZip)
+}
+
+func (ds *FA0020) Decode(raw []byte) (*Result, error) {
+	m := ds.Mapping
+	if m == nil {
+		var err error
+		m, err = cwbxml.Builtin(ds.ID())
+		if err != nil {
+			return nil, err
+		}
+	}
+	zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return nil, err
+	}
+
+	type group struct {
+		run time.Time
+		lead int
+		files map[string]*zip.File
+	}
+	groups := make(map[string]*group)
+	for _, f := range zr.File {
+		reOut := fa0020Rx.FindStringSubmatch(filepath.Base(f.Name))
+		if len(reOut) != 4 {
+			continue
+		}
+		run, err := time.Parse("06010215", reOut[1])
+		if err != nil {
+			continue
+		}
+		lead, _ := strconv.Atoi(reOut[3])
+		key := reOut[1] + "." + reOut[3]
+		g, ok := groups[key]
+		if !ok {
+			g = &group{run: run, lead: lead, files: make(map[string]*zip.File, 3)}
+			groups[key] = g
+		}
+		g.files[reOut[2]] = f
+	}
+
+	res := &Result{}
+	for key, g := range groups {
+		grid, missing := ds.merge(g.files, m, func(part string, err error) {
+			lib.Logger.Warn("incomplete frame", "dataset", ds.ID(), "frame", key, "part", part, "err", err)
+			res.Warnings = append(res.Warnings, fmt.Sprintf("frame %v: %v: %v", key, part, err))
+		})
+		if res.RunTime.IsZero() || g.run.Before(res.RunTime) {
+			res.RunTime = g.run
+		}
+		res.Frames = append(res.Frames, &Frame{
+			Time: g.run.Add(time.Duration(g.lead) * time.Hour),
+			Lead: g.lead,
+			Grid: grid,
+			Missing: missing,
+		})
+	}
+	if len(res.Frames) == 0 {
+		return nil, fmt.Errorf("%v: no frame in zip", ds.ID())
+	}
+	sort.Slice(res.Frames, func(i, j int) bool { return res.Frames[i].Time.Before(res.Frames[j].Time) })
+	return res, nil
+}
+
+// dir + hs + t >> 一個grid; 缺少、失敗或沒有該變數的部分回傳其變數, 全部失敗時 grid 為 nil
+// 三個檔案同時解析
+func (ds *FA0020) merge(files map[string]*zip.File, m *cwbxml.Mapping, fail func(part string, err error)) (*lib.VectorGrid, []string) {
+	grids := make([]*lib.VectorGrid, len(fa0020Parts))
+	errs := make([]error, len(fa0020Parts))
+	var wg sync.WaitGroup
+	for i, part := range fa0020Parts {
+		wg.Add(1)
+		go func(i int, f *zip.File) {
+			defer wg.Done()
+			grids[i], errs[i] = ds.parsePart(f, m)
+		}(i, files[part])
+	}
+	wg.Wait()
+
+	var out *lib.VectorGrid
+	var missing []string
+	for i, part := range fa0020Parts {
+		key := fa0020Vars[i]
+		grid, err := grids[i], errs[i]
+		if err == nil && grid.Data[key] == nil {
+			err = fmt.Errorf("no %v in %v", key, files[part].Name)
+		}
+		if err == nil && out != nil && (grid.Nx != out.Nx || grid.Ny != out.Ny) {
+			err = fmt.Errorf("grid size %vx%v, expect %vx%v", grid.Nx, grid.Ny, out.Nx, out.Ny)
+		}
+		if err != nil {
+			fail(part, err)
+			missing = append(missing, key)
+			continue
+		}
+		if out == nil {
+			out = grid
+			continue
+		}
+		out.Desc = out.Desc + ";" + grid.Desc
+		out.Data[key] = grid.Data[key]
+		out.DataRange[key] = grid.DataRange[key]
+		out.Units[key] = grid.Units[key]
+	}
+	return out, missing
+}
+
+func (ds *FA0020) parsePart(f *zip.File, m *cwbxml.Mapping) (*lib.VectorGrid, error) {
+	if f == nil {
+		return nil, fmt.Errorf("missing in zip")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	grid, err := cwbxml.Parse(bytes.NewReader(buf), m)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", f.Name, err)
+	}
+	return grid, nil
+}
+
+func (ds *FA0020) Rules() *validate.Rules {
+	return &validate.Rules{Required: fa0020Vars}
+}
+
+// 與 oceanwave-proc 相同: 只留目前時間前一筆之後的資料, 加上 index.json 並清除舊檔
+func (ds *FA0020) Publish(res *Result, out *Output) ([]string, error) {
+	TrimPast(res, time.Now().UTC())
+	return WriteFrames(res, out)
+}
@@ -0,0 +1,181 @@
+package dataset
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 2x2 格點, 一個變數
+func waveXML(elem string, value float64) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<cwaopendata xmlns="urn:cwa:gov:tw:cwacommon:0.1">
+	<dataset>
+		<datasetInfo>
+			<datasetDescription>波浪預報模式資料(測試用)</datasetDescription>
+		</datasetInfo>
+		<time>
+			<dataTime>2024-01-01T00:00:00</dataTime>
+		</time>
+`)
+	for _, lat := range []string{"22.0", "22.1"} {
+		for _, lon := range []string{"120.0", "120.1"} {
+			fmt.Fprintf(&sb, `		<location>
+			<lat>%v</lat>
+			<lon>%v</lon>
+			<weatherElement>
+				<elementName>%v</elementName>
+				<elementValue>
+					<value>%v</value>
+				</elementValue>
+			</weatherElement>
+		</location>
+`, lat, lon, elem, value)
+		}
+	}
+	sb.WriteString("\t</dataset>\n</cwaopendata>\n")
+	return sb.String()
+}
+
+func makeZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// +000 完整; +003 的 t 檔沒有週期 (只有浪高), 算缺少
+func fa0020Zip(t *testing.T) []byte {
+	return makeZip(t, map[string]string{
+		"24010100-dir.000.xml": waveXML("浪向", 270),
+		"24010100-hs.000.xml": waveXML("浪高", 150),
+		"24010100-t.000.xml": waveXML("週期", 800),
+		"24010100-dir.003.xml": waveXML("浪向", 180),
+		"24010100-hs.003.xml": waveXML("浪高", 120),
+		"24010100-t.003.xml": waveXML("浪高", 999),
+		"readme.txt": "not a frame",
+	})
+}
+
+func TestFA0020Decode(t *testing.T) {
+	ds := &FA0020{}
+	res, err := ds.Decode(fa0020Zip(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Frames) != 2 || res.RunTime.Format("2006010215") != "2024010100" {
+		t.Fatalf("frames %d run %v", len(res.Frames), res.RunTime)
+	}
+
+	f := res.Frames[0]
+	if f.Lead != 0 || len(f.Missing) != 0 {
+		t.Errorf("frame 0: lead %v missing %v", f.Lead, f.Missing)
+	}
+	for key, want := range map[string]float64{"浪向": 270, "浪高": 150, "週期": 800} {
+		arr := f.Grid.Data[key]
+		if len(arr) != 4 || float64(arr[0]) != want {
+			t.Errorf("frame 0 %v = %v, want %v", key, arr, want)
+		}
+	}
+	if f.Grid.Nx != 2 || f.Grid.Ny != 2 || strings.Count(f.Grid.Desc, ";") != 2 {
+		t.Errorf("frame 0: %vx%v desc %q", f.Grid.Nx, f.Grid.Ny, f.Grid.Desc)
+	}
+
+	f = res.Frames[1]
+	if f.Lead != 3 || len(f.Missing) != 1 || f.Missing[0] != "週期" {
+		t.Errorf("frame 1: lead %v missing %v", f.Lead, f.Missing)
+	}
+	if _, ok := f.Grid.Data["週期"]; ok {
+		t.Errorf("frame 1 has 週期")
+	}
+	if arr := f.Grid.Data["浪高"]; len(arr) != 4 || arr[0] != 120 {
+		t.Errorf("frame 1 浪高 = %v, overwritten by t file", arr)
+	}
+	if len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "no 週期") {
+		t.Errorf("warnings %v", res.Warnings)
+	}
+}
+
+func readIndex(t *testing.T, dir string) []*IndexFile {
+	t.Helper()
+	buf, err := os.ReadFile(filepath.Join(dir, IndexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []*IndexFile
+	if err := json.Unmarshal(buf, &list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestFA0020Partial(t *testing.T) {
+	ds := &FA0020{}
+	raw := fa0020Zip(t)
+
+	// skip: +003 沒有檔案, 記在 index.json
+	dir := t.TempDir()
+	files, err := Convert(ds, raw, &Output{Dir: dir, Partial: PartialSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(files, ",") != "24010100.000.grid.json,index.json" {
+		t.Errorf("skip: files %v", files)
+	}
+	list := readIndex(t, dir)
+	if len(list) != 2 || list[1].Name != "" || list[1].Policy != PartialSkip || list[1].Missing[0] != "週期" {
+		t.Errorf("skip: index %+v", list[1])
+	}
+
+	// partial: +003 以浪向、浪高輸出
+	dir = t.TempDir()
+	files, err = Convert(ds, raw, &Output{Dir: dir, Partial: PartialPublish})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("partial: files %v", files)
+	}
+	list = readIndex(t, dir)
+	if len(list) != 2 || list[1].Name != "24010100.003.grid.json" || list[1].Policy != PartialPublish {
+		t.Errorf("partial: index %+v", list[1])
+	}
+
+	// abort: 寫任何檔案前就失敗, 舊檔不會被覆寫或刪除
+	dir = t.TempDir()
+	old := map[string]string{
+		"24010100.000.grid.json": "old frame",
+		"23123118.003.grid.json": "stale frame",
+		IndexName: "[]",
+	}
+	for fn, content := range old {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Convert(ds, raw, &Output{Dir: dir, Partial: PartialAbort}); err == nil {
+		t.Fatal("abort: no error")
+	}
+	for fn, content := range old {
+		buf, err := os.ReadFile(filepath.Join(dir, fn))
+		if err != nil || string(buf) != content {
+			t.Errorf("abort: %v = %q, %v; want %q", fn, buf, err, content)
+		}
+	}
+}
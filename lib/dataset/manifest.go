@@ -0,0 +1,178 @@
+package dataset
+
+/*
+* 每次執行寫一份 manifest.json 到輸出資料夾, 方便除錯/稽核
+* 來源: 網址(已遮蔽授權碼)、大小、SHA-256、ZIP內的檔名及CRC32
+* 輸出: 每個檔案的大小、SHA-256; frame 的檔案另有有效時間, grid.json 另有格點數、範圍、drange
+*/
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+const ManifestName = "manifest.json"
+
+type ZipMember struct {
+	Name string `json:"name"`
+	Size uint64 `json:"size"`
+	CRC32 string `json:"crc32"`
+}
+
+type SourceInfo struct {
+	URL string `json:"url,omitempty"` // 已遮蔽
+	File string `json:"file,omitempty"` // convert 的本地檔
+	Size int `json:"size"`
+	SHA256 string `json:"sha256"`
+	Members []ZipMember `json:"members,omitempty"`
+}
+
+type OutputFile struct {
+	Name string `json:"name"`
+	Size int64 `json:"size"`
+	SHA256 string `json:"sha256"`
+
+	// frame 的檔案
+	ValidTime *time.Time `json:"validTime,omitempty"`
+	Lead *int `json:"lead,omitempty"`
+
+	// grid.json
+	Nx int `json:"nx,omitempty"`
+	Ny int `json:"ny,omitempty"`
+	BBox []float32 `json:"bbox,omitempty"` // minLon, minLat, maxLon, maxLat
+	DataRange map[string][]lib.JsonFloat `json:"drange,omitempty"`
+}
+
+type Manifest struct {
+	RunID string `json:"runId"`
+	Dataset string `json:"dataset"`
+	Started time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	RunTime *time.Time `json:"runTime,omitempty"` // 模式起始時間
+
+	Source SourceInfo `json:"source"`
+	Outputs []*OutputFile `json:"outputs"`
+	Warnings []string `json:"warnings,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	frames map[string]*OutputFile // 檔名 >> WriteFrame 記下的 frame 資訊
+}
+
+func newManifest(ds Dataset, runID string) *Manifest {
+	return &Manifest{
+		RunID: runID,
+		Dataset: ds.ID(),
+		Started: time.Now().UTC(),
+		Outputs: []*OutputFile{},
+		frames: make(map[string]*OutputFile),
+	}
+}
+
+// 原始檔的大小, hash, ZIP內容
+func (m *Manifest) setSource(raw []byte) {
+	sum := sha256.Sum256(raw)
+	m.Source.Size = len(raw)
+	m.Source.SHA256 = hex.EncodeToString(sum[:])
+	if !bytes.HasPrefix(raw, []byte("PK\x03\x04")) {
+		return
+	}
+	zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		m.Warnings = append(m.Warnings, "source: "+err.Error())
+		return
+	}
+	for _, f := range zr.File {
+		m.Source.Members = append(m.Source.Members, ZipMember{
+			Name: f.Name,
+			Size: f.UncompressedSize64,
+			CRC32: fmt.Sprintf("%08x", f.CRC32),
+		})
+	}
+}
+
+// WriteFrame 寫出的檔案
+func (m *Manifest) recordFrame(name string, f *Frame, grid bool) {
+	t := f.Time.UTC()
+	lead := f.Lead
+	of := &OutputFile{Name: name, ValidTime: &t, Lead: &lead}
+	if grid {
+		g := f.Grid
+		of.Nx = g.Nx
+		of.Ny = g.Ny
+		of.BBox = []float32{g.Lo1, g.La2, g.Lo2, g.La1}
+		of.DataRange = g.DataRange
+	}
+	m.frames[name] = of
+}
+
+// 計算輸出檔的大小/hash, 寫到 dir/manifest.json
+func (m *Manifest) finish(dir string, files []string, err error) error {
+	m.Finished = time.Now().UTC()
+	if err != nil {
+		m.Error = lib.Redact(err.Error())
+	}
+	for _, fn := range files {
+		of, ok := m.frames[fn]
+		if !ok {
+			of = &OutputFile{Name: fn}
+		}
+		size, sum, err := hashFile(filepath.Join(dir, fn))
+		if err != nil {
+			m.Warnings = append(m.Warnings, "manifest: "+err.Error())
+		}
+		of.Size = size
+		of.SHA256 = sum
+		m.Outputs = append(m.Outputs, of)
+	}
+	buf, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, ManifestName), buf)
+}
+
+// 先寫暫存檔再 rename, 讀取端 (或中斷的執行) 不會看到寫一半的 manifest.json
+func writeFileAtomic(fp string, buf []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fp), "."+filepath.Base(fp)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf)
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fp)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func hashFile(fp string) (int64, string, error) {
+	fd, err := os.Open(fp)
+	if err != nil {
+		return 0, "", err
+	}
+	defer fd.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, fd)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
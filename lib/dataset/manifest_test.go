@@ -0,0 +1,148 @@
+package dataset
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sha(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// 轉換一次後讀回 manifest.json: 來源、ZIP內容、每個輸出檔的大小/hash 與實際檔案相同
+func TestManifest(t *testing.T) {
+	ds := &FA0020{}
+	raw := fa0020Zip(t)
+	dir := t.TempDir()
+	out := WithRun(ds, &Output{Dir: dir, Partial: PartialPublish})
+	out.Manifest.Source.File = "F-A0020-001.zip"
+	files, err := Convert(ds, raw, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := readManifest(t, dir)
+	if m.RunID == "" || m.RunID != out.Manifest.RunID || m.Dataset != "F-A0020-001" || m.Error != "" {
+		t.Errorf("run %q dataset %q error %q", m.RunID, m.Dataset, m.Error)
+	}
+	if m.Finished.Before(m.Started) || m.RunTime == nil || m.RunTime.Format("2006010215") != "2024010100" {
+		t.Errorf("started %v finished %v run time %v", m.Started, m.Finished, m.RunTime)
+	}
+	if len(m.Warnings) != 2 || !strings.Contains(m.Warnings[0], "no 週期") || !strings.Contains(m.Warnings[1], "publish incomplete frame") {
+		t.Errorf("warnings %v", m.Warnings)
+	}
+
+	src := m.Source
+	if src.File != "F-A0020-001.zip" || src.Size != len(raw) || src.SHA256 != sha(raw) {
+		t.Errorf("source %+v", src)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crc := make(map[string]string)
+	for _, f := range zr.File {
+		crc[f.Name] = fmt.Sprintf("%08x", f.CRC32)
+	}
+	if len(src.Members) != len(crc) {
+		t.Errorf("%d members, want %d", len(src.Members), len(crc))
+	}
+	for _, z := range src.Members {
+		if z.CRC32 != crc[z.Name] || z.Size == 0 {
+			t.Errorf("member %+v, want crc32 %v", z, crc[z.Name])
+		}
+	}
+
+	if len(m.Outputs) != len(files) {
+		t.Fatalf("%d outputs, want %d (%v)", len(m.Outputs), len(files), files)
+	}
+	for i, of := range m.Outputs {
+		buf, err := os.ReadFile(filepath.Join(dir, files[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if of.Name != files[i] || of.Size != int64(len(buf)) || of.SHA256 != sha(buf) {
+			t.Errorf("output %v: %v %v %v", files[i], of.Name, of.Size, of.SHA256)
+		}
+		if !strings.HasSuffix(of.Name, ".grid.json") {
+			if of.ValidTime != nil || of.Nx != 0 {
+				t.Errorf("%v has frame info", of.Name)
+			}
+			continue
+		}
+		if of.ValidTime == nil || of.Lead == nil || of.Nx != 2 || of.Ny != 2 || len(of.BBox) != 4 || len(of.DataRange) == 0 {
+			t.Errorf("%v: frame info %+v", of.Name, of)
+		}
+	}
+	if lead := *m.Outputs[1].Lead; m.Outputs[1].Name != "24010100.003.grid.json" || lead != 3 {
+		t.Errorf("output 1 %v lead %v", m.Outputs[1].Name, lead)
+	}
+	if bb := m.Outputs[0].BBox; bb[0] != 120 || bb[1] != 22 || bb[2] > 120.11 || bb[3] > 22.11 {
+		t.Errorf("bbox %v", bb)
+	}
+
+	// 失敗時也寫, 記下原因, 沒有輸出; 上一個 manifest.json 整個換掉
+	if _, err := Convert(ds, raw, &Output{Dir: dir, Partial: PartialAbort}); err == nil {
+		t.Fatal("abort: no error")
+	}
+	m = readManifest(t, dir)
+	if m.Error == "" || len(m.Outputs) != 0 || m.RunID == out.Manifest.RunID {
+		t.Errorf("abort: run %q error %q outputs %v", m.RunID, m.Error, m.Outputs)
+	}
+	assertNoTemp(t, dir)
+}
+
+func assertNoTemp(t *testing.T, dir string) {
+	t.Helper()
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ents {
+		if strings.HasPrefix(e.Name(), ".") {
+			t.Errorf("temp file left: %v", e.Name())
+		}
+	}
+}
+
+// 先寫暫存檔再 rename: 覆寫舊檔, 失敗時不留下暫存檔
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, ManifestName)
+	if err := writeFileAtomic(fp, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(fp, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(fp)
+	if err != nil || string(buf) != "new" {
+		t.Errorf("content %q, err %v", buf, err)
+	}
+	if st, err := os.Stat(fp); err != nil || st.Mode().Perm() != 0644 {
+		t.Errorf("mode %v, err %v", st.Mode(), err)
+	}
+	assertNoTemp(t, dir)
+
+	// rename 失敗 (目標是資料夾)
+	sub := filepath.Join(dir, "sub")
+	if err := os.MkdirAll(filepath.Join(sub, ManifestName, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(filepath.Join(sub, ManifestName), []byte("new")); err == nil {
+		t.Error("no error when target is a directory")
+	}
+	assertNoTemp(t, sub)
+
+	if err := writeFileAtomic(filepath.Join(dir, "no", ManifestName), []byte("new")); err == nil {
+		t.Error("no error for missing directory")
+	}
+}
@@ -0,0 +1,78 @@
+package dataset
+
+/*
+* 海流模式-海流數值模式預報資料-第000小時
+* https://opendata.cwa.gov.tw/dataset/climate/M-B0071-000
+* 單一XML, 單一時間
+*/
+
+import (
+	"bytes"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib/cwbxml"
+	"github.com/OAC-TW/oac-opendata-converters/lib/validate"
+)
+
+type MB0071 struct{}
+
+func init() {
+	Register(&MB0071{})
+}
+
+func (ds *MB0071) ID() string {
+	return "M-B0071-000"
+}
+
+func (ds *MB0071) Description() string {
+	return "海流模式-海流數值模式預報資料-第000小時 (流速、海表溫度、海高、海表鹽度)"
+}
+
+func (ds *MB0071) SourceURL() string {
+	return "https://opendata.cwa.gov.tw/fileapi/v1/opendataapi/M-B0071-000?Authorization=%v&downloadType=WEB&format=XML"
+}
+
+func (ds *MB0071) Fetch(opt *FetchOptions) ([]byte, error) {
+	return fetchURL(ds, opt, nil)
+}
+
+func (ds *MB0071) Decode(raw []byte) (*Result, error) {
+	m, err := cwbxml.Builtin(ds.ID())
+	if err != nil {
+		return nil, err
+	}
+	grid, err := cwbxml.Parse(bytes.NewReader(raw), m)
+	if err != nil {
+		return nil, err
+	}
+	t, err := grid.ParseTime()
+	if err != nil {
+		return nil, err
+	}
+	return &Result{
+		RunTime: t,
+		Frames: []*Frame{{Time: t, Grid: grid}},
+	}, nil
+}
+
+// 格點數由 XML 的參數檢查 (cwbxml), 這裡只要求變數齊全
+func (ds *MB0071) Rules() *validate.Rules {
+	return &validate.Rules{Required: []string{"X", "Y", "海表溫度", "海高", "海表鹽度"}}
+}
+
+// 與 oceancurrent-proc 相同: M-B0071-000.grid.json
+func (ds *MB0071) Publish(res *Result, out *Output) ([]string, error) {
+	files := make([]string, 0, 4)
+	for _, f := range res.Frames {
+		name := ds.ID() + ".grid.json"
+		if f.Lead != 0 {
+			name = FrameName(res, f)
+		}
+		_, fl, err := WriteFrame(f, name, out)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, fl...)
+	}
+	return files, nil
+}
+
@@ -0,0 +1,106 @@
+package dataset
+
+/*
+* 每個資料集的執行狀況 (Prometheus), 由 oacconv 以 /metrics 或 textfile 輸出
+* 自行下載/解碼的 oceancurrent-proc, oceanwave-proc 用 RecordFetch/RecordGrid/RecordRun, 以 -textfile 輸出
+*/
+
+import (
+	"errors"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+	"github.com/OAC-TW/oac-opendata-converters/lib/metrics"
+)
+
+func init() {
+	metrics.Describe("oac_fetch_duration_seconds", metrics.Gauge, "Duration of the last download.")
+	metrics.Describe("oac_fetch_bytes", metrics.Gauge, "Size of the last downloaded raw file.")
+	metrics.Describe("oac_fetch_http_status", metrics.Gauge, "HTTP status of the last download, 0 on network error.")
+	metrics.Describe("oac_parse_duration_seconds", metrics.Gauge, "Duration of the last decode.")
+	metrics.Describe("oac_grid_nx", metrics.Gauge, "Longitude grid count of the last decoded grid.")
+	metrics.Describe("oac_grid_ny", metrics.Gauge, "Latitude grid count of the last decoded grid.")
+	metrics.Describe("oac_grid_nan_ratio", metrics.Gauge, "Ratio of NaN values per variable over all frames of the last run.")
+	metrics.Describe("oac_frames_written", metrics.Gauge, "Frames written by the last run.")
+	metrics.Describe("oac_frames_removed", metrics.Gauge, "Stale files listed for removal by the last run.")
+	metrics.Describe("oac_runs_total", metrics.Counter, "Runs by result (ok, error), counted after upload.")
+	metrics.Describe("oac_last_success_timestamp_seconds", metrics.Gauge, "Unix time of the last successful run, including upload.")
+}
+
+func recordFetch(id string, n int, d time.Duration, err error) {
+	status := 200
+	if err != nil {
+		status = 0
+		var se *lib.StatusError
+		if errors.As(err, &se) {
+			status = se.Code
+		}
+	}
+	metrics.Set("oac_fetch_http_status", float64(status), "dataset", id)
+	if err != nil {
+		return
+	}
+	metrics.Set("oac_fetch_duration_seconds", d.Seconds(), "dataset", id)
+	metrics.Set("oac_fetch_bytes", float64(n), "dataset", id)
+}
+
+// 不經 Fetch 自行下載時記錄, 失敗時也記一次失敗的執行
+func RecordFetch(ds Dataset, n int, d time.Duration, err error) {
+	recordFetch(ds.ID(), n, d, err)
+	if err != nil {
+		recordFailure(ds.ID())
+	}
+}
+
+// 不經 Convert 自行解碼的單一格點
+func RecordGrid(ds Dataset, g *lib.VectorGrid, d time.Duration) {
+	recordDecode(ds.ID(), &Result{Frames: []*Frame{{Grid: g}}}, d)
+}
+
+func recordDecode(id string, res *Result, d time.Duration) {
+	metrics.Set("oac_parse_duration_seconds", d.Seconds(), "dataset", id)
+	if len(res.Frames) == 0 {
+		return
+	}
+	g := res.Frames[0].Grid
+	metrics.Set("oac_grid_nx", float64(g.Nx), "dataset", id)
+	metrics.Set("oac_grid_ny", float64(g.Ny), "dataset", id)
+
+	nan := make(map[string]int)
+	total := make(map[string]int)
+	for _, f := range res.Frames {
+		for k, arr := range f.Grid.Data {
+			for _, v := range arr {
+				if v.IsNaN() {
+					nan[k]++
+				}
+			}
+			total[k] += len(arr)
+		}
+	}
+	for k, n := range total {
+		if n > 0 {
+			metrics.Set("oac_grid_nan_ratio", float64(nan[k]) / float64(n), "dataset", id, "var", k)
+		}
+	}
+}
+
+func recordPublish(id string, written int, removed int) {
+	metrics.Set("oac_frames_written", float64(written), "dataset", id)
+	metrics.Set("oac_frames_removed", float64(removed), "dataset", id)
+}
+
+// 上傳 (hook / S3 / SFTP) 結束後由呼叫端記錄整次執行的結果, 沒有上傳目標時 err 為 nil
+// 下載/解碼/輸出的失敗已在 Fetch/Convert/RecordFetch 內記錄, 不要再呼叫
+func RecordRun(ds Dataset, err error) {
+	if err != nil {
+		recordFailure(ds.ID())
+		return
+	}
+	metrics.Add("oac_runs_total", 1, "dataset", ds.ID(), "result", "ok")
+	metrics.Set("oac_last_success_timestamp_seconds", float64(time.Now().Unix()), "dataset", ds.ID())
+}
+
+func recordFailure(id string) {
+	metrics.Add("oac_runs_total", 1, "dataset", id, "result", "error")
+}
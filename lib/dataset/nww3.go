@@ -0,0 +1,92 @@
+package dataset
+
+/*
+* NWW3 (WAVEWATCH III) 波浪模式 GRIB2, oceanwave-proc -grib
+* 多個 GRIB2 檔直接接在一起當成一個原始檔 (grib2.Decode 逐一找 message)
+* 輸出與 F-A0020-001 相同 (浪高、週期、浪向), 缺少參數的時間依 Output.Partial 處理
+* 沒有固定的下載網址 (每次的模式時間不同), 不註冊; Fetch 需要 FetchOptions.URL
+*/
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib/grib2"
+	"github.com/OAC-TW/oac-opendata-converters/lib/validate"
+)
+
+type NWW3 struct {
+	BBox grib2.BBox // 裁切範圍, nil: 全部
+}
+
+func (ds *NWW3) ID() string {
+	return "NWW3"
+}
+
+func (ds *NWW3) Description() string {
+	return "NWW3 (WAVEWATCH III) 波浪模式 GRIB2 (浪高、週期、浪向)"
+}
+
+func (ds *NWW3) SourceURL() string {
+	return ""
+}
+
+func (ds *NWW3) Fetch(opt *FetchOptions) ([]byte, error) {
+	if opt.URL == "" {
+		return nil, errors.New("NWW3: no source URL")
+	}
+	return fetchURL(ds, opt, nil)
+}
+
+func (ds *NWW3) Decode(raw []byte) (*Result, error) {
+	msgs, err := grib2.Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, errors.New("no GRIB2 message")
+	}
+	grids, times, err := grib2.WaveGrids(msgs, ds.BBox)
+	if err != nil {
+		return nil, err
+	}
+
+	// 模式起始時間取最早的
+	ref := msgs[0].RefTime
+	for _, m := range msgs {
+		if m.RefTime.Before(ref) {
+			ref = m.RefTime
+		}
+	}
+
+	res := &Result{RunTime: ref}
+	for i, grid := range grids {
+		t := times[i].UTC()
+		f := &Frame{
+			Time: t,
+			Lead: int(t.Sub(ref) / time.Hour),
+			Grid: grid,
+		}
+		for _, k := range fa0020Vars {
+			if _, ok := grid.Data[k]; !ok {
+				f.Missing = append(f.Missing, k)
+			}
+		}
+		if len(f.Missing) > 0 {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("%v: no %v", t.Format("2006-01-02T15:04Z"), f.Missing))
+		}
+		res.Frames = append(res.Frames, f)
+	}
+	return res, nil
+}
+
+func (ds *NWW3) Rules() *validate.Rules {
+	return &validate.Rules{Required: fa0020Vars}
+}
+
+// 同 F-A0020-001
+func (ds *NWW3) Publish(res *Result, out *Output) ([]string, error) {
+	TrimPast(res, time.Now().UTC())
+	return WriteFrames(res, out)
+}
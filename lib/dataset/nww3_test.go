@@ -0,0 +1,153 @@
+package dataset
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ==== 最小的 GRIB2 message: 2x2 regular lat/lon, simple packing, 單位 1e-2 ====
+
+func gribSection(num int, body []byte) []byte {
+	b := make([]byte, 5, 5 + len(body))
+	binary.BigEndian.PutUint32(b, uint32(5 + len(body)))
+	b[4] = byte(num)
+	return append(b, body...)
+}
+
+// number: 10.0.x 參數, values 為 GRIB2 的原始單位 (m, s, degree)
+func gribMessage(ref time.Time, hours int, number int, values []float64) []byte {
+	s1 := make([]byte, 16)
+	binary.BigEndian.PutUint16(s1[7:], uint16(ref.Year()))
+	s1[9], s1[10], s1[11] = byte(ref.Month()), byte(ref.Day()), byte(ref.Hour())
+
+	// 北緯 22~23, 東經 120~121, 由南往北掃描
+	s3 := make([]byte, 67)
+	binary.BigEndian.PutUint32(s3[1:], 4)
+	binary.BigEndian.PutUint32(s3[25:], 2)
+	binary.BigEndian.PutUint32(s3[29:], 2)
+	binary.BigEndian.PutUint32(s3[41:], 22e6)
+	binary.BigEndian.PutUint32(s3[45:], 120e6)
+	binary.BigEndian.PutUint32(s3[50:], 23e6)
+	binary.BigEndian.PutUint32(s3[54:], 121e6)
+	binary.BigEndian.PutUint32(s3[58:], 1e6)
+	binary.BigEndian.PutUint32(s3[62:], 1e6)
+	s3[66] = 0x40
+
+	s4 := make([]byte, 29)
+	s4[5] = byte(number)
+	s4[12] = 1 // 小時
+	binary.BigEndian.PutUint32(s4[13:], uint32(hours))
+	s4[17] = 1 // 海面
+
+	// Y = X / 10^2, 每個值 24 bits
+	s5 := make([]byte, 16)
+	binary.BigEndian.PutUint32(s5, uint32(len(values)))
+	s5[13] = 2
+	s5[14] = 24
+	var s7 []byte
+	for _, v := range values {
+		x := uint32(math.Round(v * 100))
+		s7 = append(s7, byte(x >> 16), byte(x >> 8), byte(x))
+	}
+
+	var body []byte
+	for _, s := range [][]byte{gribSection(1, s1), gribSection(3, s3), gribSection(4, s4), gribSection(5, s5), gribSection(6, []byte{255}), gribSection(7, s7)} {
+		body = append(body, s...)
+	}
+	b := make([]byte, 16)
+	copy(b, "GRIB")
+	b[6] = 10
+	b[7] = 2
+	binary.BigEndian.PutUint64(b[8:], uint64(16 + len(body) + 4))
+	b = append(b, body...)
+	return append(b, "7777"...)
+}
+
+// +000 三個參數都有; +003 沒有浪向
+func nww3Raw(hs float64) []byte {
+	ref := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var raw []byte
+	for _, m := range [][]byte{
+		gribMessage(ref, 0, 3, []float64{hs, 1.5, 1.2, 1}),
+		gribMessage(ref, 0, 34, []float64{8, 8.5, 9, 9.5}),
+		gribMessage(ref, 0, 10, []float64{270, 260, 250, 240}),
+		gribMessage(ref, 3, 3, []float64{1, 1.1, 1.2, 1.3}),
+		gribMessage(ref, 3, 34, []float64{7, 7.5, 8, 8.5}),
+	} {
+		raw = append(raw, m...)
+	}
+	return raw
+}
+
+func readManifest(t *testing.T, dir string) *Manifest {
+	t.Helper()
+	buf, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(buf, m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestNWW3Convert(t *testing.T) {
+	ds := &NWW3{}
+
+	// skip: +003 缺少浪向, 記在 index.json; 與 CWA 相同寫 manifest.json
+	dir := t.TempDir()
+	files, err := Convert(ds, nww3Raw(2), &Output{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(files, ",") != "24010100.000.grid.json,index.json" {
+		t.Errorf("files %v", files)
+	}
+	list := readIndex(t, dir)
+	if len(list) != 2 || list[1].Name != "" || list[1].Policy != PartialSkip || strings.Join(list[1].Missing, ",") != "浪向" {
+		t.Errorf("index %+v", list[1])
+	}
+	if dr := list[0].DataRange["浪高"]; len(dr) != 2 || dr[1] != 200 {
+		t.Errorf("浪高 drange %v, want cm", dr)
+	}
+	m := readManifest(t, dir)
+	if m.Dataset != "NWW3" || m.Error != "" || len(m.Outputs) != 2 || m.RunTime == nil {
+		t.Errorf("manifest %+v", m)
+	}
+
+	// partial: +003 以浪高、週期輸出
+	dir = t.TempDir()
+	files, err = Convert(ds, nww3Raw(2), &Output{Dir: dir, Partial: PartialPublish})
+	if err != nil || len(files) != 3 {
+		t.Errorf("partial: files %v, err %v", files, err)
+	}
+
+	// abort: 不輸出
+	dir = t.TempDir()
+	if _, err := Convert(ds, nww3Raw(2), &Output{Dir: dir, Partial: PartialAbort}); err == nil {
+		t.Error("abort: no error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, IndexName)); err == nil {
+		t.Error("abort: index.json written")
+	}
+
+	// 浪高 50m 超出物理範圍: 檢查失敗, 不輸出, 原因記在 manifest
+	dir = t.TempDir()
+	_, err = Convert(ds, nww3Raw(50), &Output{Dir: dir})
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatalf("err = %v, want ValidationError", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "24010100.000.grid.json")); err == nil {
+		t.Error("invalid grid written")
+	}
+	if m := readManifest(t, dir); !strings.Contains(m.Error, "浪高") {
+		t.Errorf("manifest error %q", m.Error)
+	}
+}
@@ -0,0 +1,76 @@
+package dataset
+
+/*
+* 一個時間由多個檔案組成 (F-A0020-001: dir + hs + t), 部分檔案缺少或解析失敗時的處理:
+*	skip: 略過該時間 (預設)
+*	partial: 以有的變數輸出
+*	abort: 整次執行失敗, 不輸出 (保留舊檔)
+* 套用的結果記在 index.json (policy, missing) 及 log/manifest
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Partial string
+
+const (
+	PartialSkip Partial = "skip"
+	PartialPublish Partial = "partial"
+	PartialAbort Partial = "abort"
+)
+
+// 空白為 skip
+func ParsePartial(s string) (Partial, error) {
+	switch p := Partial(s); p {
+	case "":
+		return PartialSkip, nil
+	case PartialSkip, PartialPublish, PartialAbort:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown partial policy %q (skip, partial, abort)", s)
+}
+
+// 依 out.Partial 處理不完整的 frame; skip 的移到 res.Skipped
+func applyPartial(res *Result, out *Output) error {
+	policy, err := ParsePartial(string(out.Partial))
+	if err != nil {
+		return err
+	}
+	frames := make([]*Frame, 0, len(res.Frames))
+	for _, f := range res.Frames {
+		if len(f.Missing) == 0 {
+			frames = append(frames, f)
+			continue
+		}
+		missing := strings.Join(f.Missing, ",")
+		if policy == PartialAbort {
+			return fmt.Errorf("incomplete frame %v+%03d: missing %v (partial=abort)", f.Time.UTC().Format("2006-01-02T15:04Z"), f.Lead, missing)
+		}
+		if policy == PartialPublish && f.Grid != nil {
+			f.Policy = PartialPublish
+			out.warn("publish incomplete frame", "lead", f.Lead, "missing", missing)
+			frames = append(frames, f)
+			continue
+		}
+		f.Policy = PartialSkip
+		out.warn("skip incomplete frame", "lead", f.Lead, "missing", missing)
+		res.Skipped = append(res.Skipped, f)
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("no complete frame (%v skipped)", len(res.Skipped))
+	}
+	res.Frames = frames
+	return nil
+}
+
+// index.json 內略過的時間: 沒有檔名
+func skippedItem(f *Frame) *IndexFile {
+	return &IndexFile{
+		TimeUTC: f.Time.UTC(),
+		Time08: f.Time.In(loc),
+		Policy: f.Policy,
+		Missing: f.Missing,
+	}
+}
@@ -0,0 +1,251 @@
+package dataset
+
+/*
+* 共用的輸出流程, 與 oceanwave-proc 相同:
+* 每個時間一個 grid.json (+ 等值線 GeoJSON, NetCDF), index.json, 列出資料夾內過時的檔案
+* 過時的檔案等全部上傳成功後才由 RemoveStale 刪除; 上傳失敗時下次執行仍列得到, 遠端的刪除不會漏掉
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+	"github.com/OAC-TW/oac-opendata-converters/lib/contour"
+	"github.com/OAC-TW/oac-opendata-converters/lib/netcdf"
+)
+
+var loc = time.FixedZone("UTC+8", +8*60*60)
+
+const IndexName = "index.json"
+
+// 等值線輸出檔名用
+var varTag = map[string]string{
+	"X": "u",
+	"Y": "v",
+	"海表溫度": "sst",
+	"海高": "ssh",
+	"海表鹽度": "sss",
+	"浪向": "dir",
+	"浪高": "hs",
+	"週期": "t",
+}
+
+type IndexFile struct {
+	TimeUTC time.Time `json:"timeUTC"`
+	Time08  time.Time `json:"time08"`
+	Name string `json:"name"`
+
+	DataRange map[string][]lib.JsonFloat `json:"drange"`
+	Contour map[string]string `json:"contour,omitempty"` // 變數 >> 等值線GeoJSON檔名
+	NetCDF string `json:"nc,omitempty"`
+
+	// 不完整的時間: policy 為 partial (缺少 missing 的變數) 或 skip (沒有檔案, name 為空白)
+	Policy Partial `json:"policy,omitempty"`
+	Missing []string `json:"missing,omitempty"`
+}
+
+// 20072318.000.grid.json 及附屬檔
+var frameRx = regexp.MustCompile(`^([0-9]{8,8})\.([0-9]{3,3})\.(grid\.json|[a-z]+\.geojson|nc)$`)
+
+// 輸出資料夾內會被當成舊 frame 清除的檔名 (遠端同步用)
+func IsFrameFile(name string) bool {
+	return frameRx.MatchString(name)
+}
+
+// yyMMddHH.LLL.grid.json, yyMMddHH 為模式起始時間(UTC)
+func FrameName(res *Result, f *Frame) string {
+	base := res.RunTime
+	if base.IsZero() {
+		base = f.Time.Add(-time.Duration(f.Lead) * time.Hour)
+	}
+	return fmt.Sprintf("%v.%03d.grid.json", base.UTC().Format("06010215"), f.Lead)
+}
+
+// 寫出一個 frame, 回傳 index 項目及所有寫出的檔名
+func WriteFrame(f *Frame, name string, out *Output) (*IndexFile, []string, error) {
+	buf, err := json.Marshal(f.Grid)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(out.Dir, name), buf, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	lg := out.logger().With("file", name, "lead", f.Lead)
+	if out.Manifest != nil {
+		out.Manifest.recordFrame(name, f, true)
+	}
+	lg.Debug("write grid", "nx", f.Grid.Nx, "ny", f.Grid.Ny)
+
+	files := []string{name}
+	item := &IndexFile{
+		TimeUTC: f.Time.UTC(),
+		Time08: f.Time.In(loc),
+		Name: name,
+		DataRange: f.Grid.DataRange,
+		Policy: f.Policy,
+		Missing: f.Missing,
+	}
+
+	base := strings.TrimSuffix(name, ".grid.json")
+	for k, levels := range out.Contours {
+		if _, ok := f.Grid.Data[k]; !ok && len(f.Missing) > 0 {
+			continue // partial
+		}
+		tag, ok := varTag[k]
+		if !ok {
+			tag = "var"
+		}
+		fc, err := contour.Build(f.Grid, k, levels)
+		if err != nil {
+			out.warn("contour failed", "file", name, "var", k, "err", err)
+			continue
+		}
+		buf, err := json.Marshal(fc)
+		if err != nil {
+			return nil, nil, err
+		}
+		fn := base + "." + tag + ".geojson"
+		err = ioutil.WriteFile(filepath.Join(out.Dir, fn), buf, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		if item.Contour == nil {
+			item.Contour = make(map[string]string)
+		}
+		item.Contour[k] = fn
+		files = append(files, fn)
+		if out.Manifest != nil {
+			out.Manifest.recordFrame(fn, f, false)
+		}
+	}
+
+	if out.NetCDF {
+		nc, err := netcdf.FromGrids([]*lib.VectorGrid{f.Grid}, []time.Time{f.Time})
+		if err != nil {
+			return nil, nil, err
+		}
+		fn := base + ".nc"
+		fd, err := os.OpenFile(filepath.Join(out.Dir, fn), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = nc.Write(fd)
+		fd.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		item.NetCDF = fn
+		files = append(files, fn)
+		if out.Manifest != nil {
+			out.Manifest.recordFrame(fn, f, false)
+		}
+	}
+	return item, files, nil
+}
+
+// 所有 frame + index.json, 資料夾內不在這次輸出的舊 frame 記在 out.Removed (還不刪除)
+func WriteFrames(res *Result, out *Output) ([]string, error) {
+	oldFiles, err := listFrames(out.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*IndexFile, 0, len(res.Frames))
+	files := make([]string, 0, len(res.Frames)+1)
+	for _, f := range res.Frames {
+		item, fl, err := WriteFrame(f, FrameName(res, f), out)
+		if err != nil {
+			return files, err
+		}
+		list = append(list, item)
+		files = append(files, fl...)
+	}
+	if len(res.Skipped) > 0 {
+		for _, f := range res.Skipped {
+			list = append(list, skippedItem(f))
+		}
+		sort.SliceStable(list, func(i, j int) bool { return list[i].TimeUTC.Before(list[j].TimeUTC) })
+	}
+
+	buf, err := json.Marshal(list)
+	if err != nil {
+		return files, err
+	}
+	err = ioutil.WriteFile(filepath.Join(out.Dir, IndexName), buf, 0644)
+	if err != nil {
+		return files, err
+	}
+	files = append(files, IndexName)
+
+	for _, fn := range files {
+		delete(oldFiles, fn)
+	}
+	for fn := range oldFiles {
+		out.Removed = append(out.Removed, fn)
+	}
+	sort.Strings(out.Removed)
+	return files, nil
+}
+
+// 全部上傳成功 (或沒有上傳目標) 後刪除本地的舊 frame
+func RemoveStale(out *Output) {
+	for _, fn := range out.Removed {
+		err := os.Remove(filepath.Join(out.Dir, fn))
+		if err != nil && !os.IsNotExist(err) {
+			out.warn("remove stale file failed", "file", fn, "err", err)
+			continue
+		}
+		out.logger().Debug("removed stale file", "file", fn)
+	}
+}
+
+func listFrames(dir string) (map[string]bool, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	list, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool, len(list))
+	for _, name := range list {
+		if frameRx.MatchString(name) {
+			out[name] = true
+		}
+	}
+	return out, nil
+}
+
+// 只保留目前時間前一筆之後的 frame (同 oceanwave-proc), 略過的 frame 也一樣
+func TrimPast(res *Result, now time.Time) {
+	for i, f := range res.Frames {
+		if f.Time.After(now) {
+			if i > 0 {
+				res.Frames = res.Frames[i-1:]
+			}
+			break
+		}
+	}
+	if len(res.Frames) == 0 || len(res.Skipped) == 0 {
+		return
+	}
+	first := res.Frames[0].Time
+	skipped := res.Skipped[:0]
+	for _, f := range res.Skipped {
+		if !f.Time.Before(first) {
+			skipped = append(skipped, f)
+		}
+	}
+	res.Skipped = skipped
+}
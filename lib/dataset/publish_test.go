@@ -0,0 +1,54 @@
+package dataset
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 舊 frame 先只列在 Removed, 上傳失敗時下次執行仍列得到; RemoveStale 之後才刪除
+func TestWriteFramesStale(t *testing.T) {
+	dir := t.TempDir()
+	for _, fn := range []string{"23123118.003.grid.json", "23123118.003.hs.geojson", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := &Result{RunTime: t0, Frames: []*Frame{{Time: t0, Grid: waveGrid(t0, fa0020Vars...)}}}
+	want := "23123118.003.grid.json,23123118.003.hs.geojson"
+
+	for run := 0; run < 2; run++ {
+		out := &Output{Dir: dir}
+		files, err := WriteFrames(res, out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(files, ",") != "24010100.000.grid.json,index.json" {
+			t.Errorf("run %d: files %v", run, files)
+		}
+		if got := strings.Join(out.Removed, ","); got != want {
+			t.Errorf("run %d: removed %v, want %v", run, got, want)
+		}
+		for _, fn := range out.Removed {
+			if _, err := os.Stat(filepath.Join(dir, fn)); err != nil {
+				t.Errorf("run %d: %v removed before publish: %v", run, fn, err)
+			}
+		}
+		if run == 1 {
+			RemoveStale(out)
+		}
+	}
+
+	names, _ := listFrames(dir)
+	if len(names) != 1 || !names["24010100.000.grid.json"] {
+		t.Errorf("frames after RemoveStale: %v", names)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("non-frame file removed: %v", err)
+	}
+	// 已經不在的檔案不算錯誤
+	RemoveStale(&Output{Dir: dir, Removed: []string{"23123118.003.grid.json"}})
+}
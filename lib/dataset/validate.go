@@ -0,0 +1,88 @@
+package dataset
+
+/*
+* 輸出前檢查每個 frame (lib/validate), 有問題就不輸出, 原因寫到 log 及 manifest.json
+* frame 的有效時間必須遞增 (同一時間重複時檔名會相同)
+*/
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib/validate"
+)
+
+// 有實作時用資料集自己的規則 (必要變數、格點數...)
+type Validator interface {
+	Rules() *validate.Rules
+}
+
+type ValidationError struct {
+	Problems []string // frame: 問題
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("validation failed (%v problems): %v", len(e.Problems), strings.Join(e.Problems, "; "))
+}
+
+// 資料集的規則, 沒有時只做結構及物理範圍檢查
+func RulesFor(ds Dataset) *validate.Rules {
+	if v, ok := ds.(Validator); ok {
+		return v.Rules()
+	}
+	return nil
+}
+
+func Validate(ds Dataset, res *Result) error {
+	rules := RulesFor(ds)
+	var problems []string
+	for i, f := range res.Frames {
+		prefix := fmt.Sprintf("%v+%03d: ", f.Time.UTC().Format("2006-01-02T15:04Z"), f.Lead)
+		if i > 0 && !f.Time.After(res.Frames[i-1].Time) {
+			problems = append(problems, prefix+"time not after previous frame "+res.Frames[i-1].Time.UTC().Format("2006-01-02T15:04Z"))
+		}
+		if f.Grid == nil {
+			problems = append(problems, prefix+"no grid")
+			continue
+		}
+		err := validate.Check(f.Grid, withoutMissing(rules, f.Missing))
+		if err == nil {
+			continue
+		}
+		if ve, ok := err.(*validate.Error); ok {
+			for _, p := range ve.Problems {
+				problems = append(problems, prefix+p)
+			}
+			continue
+		}
+		problems = append(problems, prefix+err.Error())
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{problems}
+}
+
+// partial 輸出的 frame 不要求缺少的變數
+func withoutMissing(rules *validate.Rules, missing []string) *validate.Rules {
+	if rules == nil || len(missing) == 0 {
+		return rules
+	}
+	r := *rules
+	r.Required = nil
+	for _, k := range rules.Required {
+		if !contains(missing, k) {
+			r.Required = append(r.Required, k)
+		}
+	}
+	return &r
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package dataset
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+func waveGrid(t time.Time, keys ...string) *lib.VectorGrid {
+	g := lib.NewVectorGrid()
+	g.Lo1, g.Lo2 = 120, 121
+	g.La1, g.La2 = 23, 22
+	g.Nx, g.Ny = 2, 2
+	g.Time = t.Format(time.RFC3339)
+	for _, k := range keys {
+		g.Data[k] = []lib.JsonFloat{100, 120, 140, 160}
+	}
+	return g
+}
+
+func TestValidate(t *testing.T) {
+	ds := &FA0020{}
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	frame := func(lead int, missing []string, keys ...string) *Frame {
+		tm := t0.Add(time.Duration(lead) * time.Hour)
+		return &Frame{Time: tm, Lead: lead, Grid: waveGrid(tm, keys...), Missing: missing}
+	}
+	all := fa0020Vars
+	cases := []struct {
+		name string
+		frames []*Frame
+		want string // 空白: 通過
+	}{
+		{"ok", []*Frame{frame(0, nil, all...), frame(3, nil, all...)}, ""},
+		{"partial frame without missing variable", []*Frame{frame(0, nil, all...), frame(3, []string{"浪向"}, "浪高", "週期")}, ""},
+		{"required", []*Frame{frame(0, nil, "浪高", "週期")}, "2024-01-01T00:00Z+000: missing variable 浪向"},
+		{"extra variable range", []*Frame{frame(0, nil, "浪向", "浪高", "週期", "X")}, "X: 4 values out of range [-10, 10]"},
+		{"same time", []*Frame{frame(0, nil, all...), frame(0, nil, all...)}, "time not after previous frame"},
+		{"time order", []*Frame{frame(3, nil, all...), frame(0, nil, all...)}, "2024-01-01T00:00Z+000: time not after previous frame 2024-01-01T03:00Z"},
+		{"no grid", []*Frame{{Time: t0}}, "no grid"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(ds, &Result{Frames: tc.frames})
+			if tc.want == "" {
+				if err != nil {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+			if _, ok := err.(*ValidationError); !ok || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want %q", err, tc.want)
+			}
+		})
+	}
+
+	// 超出物理範圍 (浪高 0~3000 cm)
+	f := frame(0, nil, all...)
+	f.Grid.Data["浪高"][2] = 5000
+	err := Validate(ds, &Result{Frames: []*Frame{f}})
+	if err == nil || !strings.Contains(err.Error(), "浪高: 1 values out of range") {
+		t.Errorf("err = %v", err)
+	}
+}
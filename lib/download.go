@@ -0,0 +1,337 @@
+package lib
+
+/*
+* 大檔下載 (F-A0020-001 ZIP, GRIB2): 重試, 續傳, 檢查完整性
+*	網路錯誤, 5xx, 408, 429, 長度不符, Verify 失敗都會重試, 等待時間每次加倍
+*	伺服器支援 Range (Accept-Ranges: bytes 或回過 206) 時由已收到的位置續傳,
+*	並以 If-Range (ETag/Last-Modified) 確認檔案沒有更新, 有更新時伺服器回 200 整檔重下
+*	Content-Length / Content-Range 的總長度必須與收到的相同
+*	超過 MaxSize 直接失敗, 不重試
+* 逾時分開: 連線 (DialFunc), TLS 握手, 沒有資料 (Idle), 整次下載含重試 (Total)
+*/
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DownloadOptions struct {
+	Header http.Header
+	Dial DialFunc // 含連線逾時, nil: 直連 10 秒
+
+	TLSTimeout time.Duration // TLS 握手, 預設 10 秒
+	Idle time.Duration // 多久沒收到資料就中斷這次嘗試, 預設 60 秒
+	Total time.Duration // 整次下載 (含重試), 預設 10 分鐘
+
+	Retries int // 失敗後重試次數
+	Backoff time.Duration // 第一次重試前等待, 預設 2 秒, 之後每次加倍
+	MaxSize int64 // bytes, 0: 不限制
+
+	// 下載完成後檢查內容 (例: CheckZip), 失敗時整檔重下
+	Verify func(data []byte) error
+}
+
+const maxDownloadBackoff = time.Minute
+
+var ErrTooLarge = errors.New("download: exceeds max size")
+
+// 長度與 Content-Length/Content-Range 不符
+type ShortError struct {
+	Got int64
+	Want int64
+}
+
+func (e *ShortError) Error() string {
+	return fmt.Sprintf("download: got %v bytes, want %v", e.Got, e.Want)
+}
+
+// 下載中的狀態, 跨重試保留
+type download struct {
+	url string
+	opt DownloadOptions
+	client *http.Client
+
+	buf bytes.Buffer
+	total int64 // -1: 不知道
+	resumable bool
+	validator string // If-Range
+}
+
+func Download(url string, opt *DownloadOptions) ([]byte, error) {
+	o := *opt
+	if o.Dial == nil {
+		o.Dial = NewDialFunc("", 10 * time.Second)
+	}
+	if o.TLSTimeout <= 0 {
+		o.TLSTimeout = 10 * time.Second
+	}
+	if o.Idle <= 0 {
+		o.Idle = 60 * time.Second
+	}
+	if o.Total <= 0 {
+		o.Total = 10 * time.Minute
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 2 * time.Second
+	}
+	if o.Retries < 0 {
+		o.Retries = 0
+	}
+
+	d := &download{
+		url: url,
+		opt: o,
+		total: -1,
+		client: &http.Client{Transport: &http.Transport{
+			Dial: o.Dial.ForURL(url),
+			TLSHandshakeTimeout: o.TLSTimeout,
+			DisableCompression: true, // 長度及 Range 以原始 bytes 計算
+		}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), o.Total)
+	defer cancel()
+
+	wait := o.Backoff
+	for attempt := 0; ; attempt++ {
+		err := d.attempt(ctx)
+		if err == nil && o.Verify != nil {
+			err = o.Verify(d.buf.Bytes())
+			if err != nil {
+				err = fmt.Errorf("download: verify: %v", err)
+				d.reset()
+			}
+		}
+		if err == nil {
+			return d.buf.Bytes(), nil
+		}
+		err = RedactError(err)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("download: total timeout %v: %w", o.Total, err)
+		}
+		if attempt >= o.Retries || !retryableDownload(err) {
+			return nil, err
+		}
+		Logger.Warn("download retry", "attempt", attempt+1, "wait", wait, "have", d.buf.Len(), "total", d.total, "resume", d.resumable, "err", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("download: total timeout %v: %w", o.Total, err)
+		}
+		wait *= 2
+		if wait > maxDownloadBackoff {
+			wait = maxDownloadBackoff
+		}
+	}
+}
+
+func retryableDownload(err error) bool {
+	if errors.Is(err, ErrTooLarge) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code >= 500 || se.Code == http.StatusRequestTimeout || se.Code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// 重新下載整個檔案
+func (d *download) reset() {
+	d.buf.Reset()
+	d.total = -1
+	d.validator = ""
+}
+
+func (d *download) attempt(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", d.url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range d.opt.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", UA)
+	req.Header.Set("Accept-Encoding", "identity")
+	offset := int64(d.buf.Len())
+	if offset > 0 && d.resumable {
+		req.Header.Set("Range", "bytes=" + strconv.FormatInt(offset, 10) + "-")
+		if d.validator != "" {
+			req.Header.Set("If-Range", d.validator)
+		}
+	} else if offset > 0 {
+		d.reset()
+		offset = 0
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		if offset > 0 {
+			Logger.Debug("download restart, range ignored or file changed", "have", offset)
+			d.reset()
+		}
+		d.total = res.ContentLength
+		d.resumable = res.Header.Get("Accept-Ranges") == "bytes"
+		d.validator = rangeValidator(res.Header)
+	case http.StatusPartialContent:
+		start, total, ok := parseContentRange(res.Header.Get("Content-Range"))
+		if !ok || start != offset || (d.total >= 0 && total >= 0 && total != d.total) {
+			d.reset()
+			d.resumable = false
+			return fmt.Errorf("download: unexpected Content-Range %q at %v", res.Header.Get("Content-Range"), offset)
+		}
+		if total >= 0 {
+			d.total = total
+		}
+		d.resumable = true
+		Logger.Info("download resume", "from", offset, "total", d.total)
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 已經收完 (上次只差檢查), 否則整檔重下
+		if d.total >= 0 && offset == d.total {
+			return nil
+		}
+		d.reset()
+		d.resumable = false
+		return &StatusError{res.StatusCode, res.Status}
+	default:
+		return &StatusError{res.StatusCode, res.Status}
+	}
+
+	if d.opt.MaxSize > 0 && d.total > d.opt.MaxSize {
+		return fmt.Errorf("%w: %v > %v bytes", ErrTooLarge, d.total, d.opt.MaxSize)
+	}
+
+	// 太久沒有資料時取消這次嘗試, 已收到的保留給下次續傳
+	body := &idleReader{r: res.Body, timer: time.AfterFunc(d.opt.Idle, cancel), idle: d.opt.Idle}
+	defer body.stop()
+	var r io.Reader = body
+	if d.opt.MaxSize > 0 {
+		r = io.LimitReader(body, d.opt.MaxSize - int64(d.buf.Len()) + 1)
+	}
+	_, err = io.Copy(&d.buf, r)
+	if d.opt.MaxSize > 0 && int64(d.buf.Len()) > d.opt.MaxSize {
+		return fmt.Errorf("%w: > %v bytes", ErrTooLarge, d.opt.MaxSize)
+	}
+	if err != nil {
+		if body.idled() {
+			return fmt.Errorf("download: no data for %v: %v", d.opt.Idle, err)
+		}
+		return err
+	}
+	if d.total >= 0 && int64(d.buf.Len()) != d.total {
+		return &ShortError{int64(d.buf.Len()), d.total}
+	}
+	return nil
+}
+
+// ETag (strong), 沒有時用 Last-Modified
+func rangeValidator(h http.Header) string {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+// bytes 100-199/1000 >> 100, 1000; total 為 * 時 -1
+func parseContentRange(s string) (int64, int64, bool) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, false
+	}
+	s = strings.TrimPrefix(s, "bytes ")
+	i := strings.Index(s, "-")
+	j := strings.Index(s, "/")
+	if i < 0 || j < i {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if s[j+1:] == "*" {
+		return start, -1, true
+	}
+	total, err := strconv.ParseInt(s[j+1:], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, total, true
+}
+
+type idleReader struct {
+	r io.Reader
+	timer *time.Timer
+	idle time.Duration
+
+	mu sync.Mutex
+	fired bool
+}
+
+func (ir *idleReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if n > 0 {
+		ir.mu.Lock()
+		if !ir.fired && !ir.timer.Stop() {
+			ir.fired = true
+		}
+		if !ir.fired {
+			ir.timer.Reset(ir.idle)
+		}
+		ir.mu.Unlock()
+	}
+	return n, err
+}
+
+func (ir *idleReader) stop() {
+	ir.timer.Stop()
+}
+
+// timer 已觸發 (Stop 回傳 false 且沒有其他原因)
+func (ir *idleReader) idled() bool {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	if ir.fired {
+		return true
+	}
+	if !ir.timer.Stop() {
+		ir.fired = true
+	}
+	return ir.fired
+}
+
+// 讀出 ZIP 內每個檔案, archive/zip 會檢查 CRC-32 及長度
+func CheckZip(data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%v: %v", f.Name, err)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%v: %v", f.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 記錄每次請求的 Range/If-Range 及時間
+type dlServer struct {
+	mu sync.Mutex
+	n int
+	ranges []string
+	ifRanges []string
+	times []time.Time
+}
+
+func (s *dlServer) record(r *http.Request) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.ifRanges = append(s.ifRanges, r.Header.Get("If-Range"))
+	s.times = append(s.times, time.Now())
+	return s.n
+}
+
+func testData(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+// 宣告完整長度但只送出前 n bytes, 連線中斷
+func writeCut(w http.ResponseWriter, data []byte, n int) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data[:n])
+}
+
+func fastOptions(retries int) *DownloadOptions {
+	return &DownloadOptions{Retries: retries, Backoff: 20 * time.Millisecond, Total: 10 * time.Second}
+}
+
+func TestDownloadRetry(t *testing.T) {
+	data := testData(1000)
+	s := &dlServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.record(r) <= 2 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	got, err := Download(srv.URL, fastOptions(2))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("len %v, err %v", len(got), err)
+	}
+	if s.n != 3 {
+		t.Fatalf("%d requests, want 3", s.n)
+	}
+	// 等待時間每次加倍
+	if d := s.times[1].Sub(s.times[0]); d < 20 * time.Millisecond {
+		t.Errorf("first backoff %v", d)
+	}
+	if d := s.times[2].Sub(s.times[1]); d < 40 * time.Millisecond {
+		t.Errorf("second backoff %v", d)
+	}
+
+	// 重試次數用完
+	s = &dlServer{}
+	_, err = Download(srv.URL, fastOptions(1))
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusServiceUnavailable || s.n != 2 {
+		t.Errorf("err = %v after %d requests", err, s.n)
+	}
+}
+
+func TestDownloadNoRetry(t *testing.T) {
+	s := &dlServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.record(r)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	_, err := Download(srv.URL, fastOptions(3))
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusNotFound || s.n != 1 {
+		t.Errorf("err = %v after %d requests, want 404 once", err, s.n)
+	}
+}
+
+// 中斷後以 Range + If-Range 續傳
+func TestDownloadResume(t *testing.T) {
+	data := testData(1000)
+	s := &dlServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := s.record(r)
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", `"v1"`)
+		if n == 1 {
+			writeCut(w, data, 400)
+			return
+		}
+		if r.Header.Get("Range") != "bytes=400-" || r.Header.Get("If-Range") != `"v1"` {
+			w.Write(data)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 400-%d/%d", len(data) - 1, len(data)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data) - 400))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[400:])
+	}))
+	defer srv.Close()
+
+	got, err := Download(srv.URL, fastOptions(1))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("len %v, err %v", len(got), err)
+	}
+	if s.n != 2 || s.ranges[1] != "bytes=400-" || s.ifRanges[1] != `"v1"` {
+		t.Errorf("requests %d, range %q, if-range %q", s.n, s.ranges, s.ifRanges)
+	}
+}
+
+// 檔案已更新 (If-Range 不符) 或伺服器不理 Range: 回 200, 整檔重下而不是接在後面
+func TestDownloadRestart(t *testing.T) {
+	old, data := testData(1000), testData(800)
+	data[0] = 0xff
+	s := &dlServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		if s.record(r) == 1 {
+			writeCut(w, old, 400)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	got, err := Download(srv.URL, fastOptions(1))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("len %v, err %v", len(got), err)
+	}
+	if s.ranges[1] != "bytes=400-" || s.ifRanges[1] != "Mon, 01 Jan 2024 00:00:00 GMT" {
+		t.Errorf("range %q, if-range %q", s.ranges, s.ifRanges)
+	}
+}
+
+// 不支援 Range 時不送 Range, 從頭下載
+func TestDownloadNotResumable(t *testing.T) {
+	data := testData(1000)
+	s := &dlServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.record(r) == 1 {
+			writeCut(w, data, 400)
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	got, err := Download(srv.URL, fastOptions(1))
+	if err != nil || !bytes.Equal(got, data) || s.ranges[1] != "" {
+		t.Errorf("len %v, err %v, range %q", len(got), err, s.ranges)
+	}
+}
+
+// 收到的比 Content-Range 的總長度少
+func TestDownloadShort(t *testing.T) {
+	data := testData(100)
+	s := &dlServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := s.record(r)
+		start := 0
+		if n > 1 {
+			start = 60
+		}
+		end := start + 60
+		if end > len(data) {
+			end = len(data)
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end - 1, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[start:end])
+	}))
+	defer srv.Close()
+
+	_, err := Download(srv.URL, fastOptions(0))
+	var short *ShortError
+	if !errors.As(err, &short) || short.Got != 60 || short.Want != 100 {
+		t.Fatalf("err = %v, want ShortError 60/100", err)
+	}
+
+	// 206 表示支援 Range, 重試時續傳剩下的
+	s = &dlServer{}
+	got, err := Download(srv.URL, fastOptions(1))
+	if err != nil || !bytes.Equal(got, data) || s.ranges[1] != "bytes=60-" {
+		t.Errorf("len %v, err %v, range %q", len(got), err, s.ranges)
+	}
+}
+
+func TestDownloadMaxSize(t *testing.T) {
+	data := testData(1000)
+	s := &dlServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.record(r)
+		if r.URL.Path == "/chunked" {
+			// 沒有 Content-Length, 收到超過才知道
+			w.Write(data[:500])
+			w.(http.Flusher).Flush()
+			w.Write(data[500:])
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	for _, path := range []string{"/", "/chunked"} {
+		s.n = 0
+		opt := fastOptions(3)
+		opt.MaxSize = 800
+		_, err := Download(srv.URL + path, opt)
+		if !errors.Is(err, ErrTooLarge) || s.n != 1 {
+			t.Errorf("%v: err = %v after %d requests, want ErrTooLarge once", path, err, s.n)
+		}
+		opt.MaxSize = 1000
+		if got, err := Download(srv.URL + path, opt); err != nil || len(got) != 1000 {
+			t.Errorf("%v: exactly max size: len %v, err %v", path, len(got), err)
+		}
+	}
+}
+
+func testZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("a.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(bytes.Repeat([]byte("<a>1</a>"), 100))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCheckZip(t *testing.T) {
+	good := testZip(t)
+	if err := CheckZip(good); err != nil {
+		t.Errorf("good zip: %v", err)
+	}
+	if err := CheckZip(good[:len(good) / 2]); err == nil {
+		t.Error("no error for truncated zip")
+	}
+	// 壓縮資料損壞: CRC-32 或 deflate 錯誤
+	bad := append([]byte(nil), good...)
+	bad[40] ^= 0xff
+	if err := CheckZip(bad); err == nil {
+		t.Error("no error for corrupted zip")
+	}
+
+	// Verify 失敗時整檔重下
+	s := &dlServer{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if s.record(r) == 1 {
+			w.Write(bad)
+			return
+		}
+		w.Write(good)
+	}))
+	defer srv.Close()
+
+	opt := fastOptions(1)
+	opt.Verify = CheckZip
+	got, err := Download(srv.URL, opt)
+	if err != nil || !bytes.Equal(got, good) || s.n != 2 || s.ranges[1] != "" {
+		t.Errorf("len %v, err %v, %d requests, range %q", len(got), err, s.n, s.ranges)
+	}
+}
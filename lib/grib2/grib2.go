@@ -0,0 +1,640 @@
+package grib2
+
+/*
+* GRIB2 (WMO FM 92) 解碼, 不需要 eccodes / wgrib2
+* 只支援:
+*   Grid Definition Template 3.0 (regular lat/lon)
+*   Product Definition Template 4.0 ~ 4.15 的共同欄位 (參數, 預報時間)
+*   Data Representation Template 5.0 (simple packing), 5.2 (complex packing), 5.3 (complex packing + spatial differencing)
+*   Bit-map (section 6)
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+var (
+	ErrMagic = errors.New("not a GRIB2 message")
+	ErrShort = errors.New("unexpected end of GRIB2 message")
+)
+
+// 壞掉的 header 不能讓解碼配置過多記憶體
+const (
+	maxPoints = 1 << 26 // 全球 0.05 度約 2600 萬點
+	maxBits = 32 // 每個值/群組欄位的 bits
+)
+
+type ErrTemplate struct {
+	Section int
+	Template int
+}
+
+func (e *ErrTemplate) Error() string {
+	return fmt.Sprintf("unsupported template %v.%v", e.Section, e.Template)
+}
+
+// Template 3.0, 經緯度單位為度
+type Grid struct {
+	Ni, Nj int // 經向點數, 緯向點數
+	La1, Lo1 float64 // 第一個點
+	La2, Lo2 float64 // 最後一個點
+	Di, Dj float64
+	ScanMode int // Flag table 3.4
+}
+
+// 一個 message 一個欄位
+type Message struct {
+	Discipline int
+	RefTime time.Time
+	Category int
+	Number int
+	Forecast time.Duration // 距 RefTime
+
+	// 第一層固定面 (Code table 4.5), 例如 1: ground or water surface
+	Surface int
+
+	Grid Grid
+
+	// 依檔案內的掃描順序, 長度 Ni*Nj, 無資料為 NaN
+	Values []float64
+}
+
+func (m *Message) ValidTime() time.Time {
+	return m.RefTime.Add(m.Forecast)
+}
+
+// discipline.category.number, 例: 10.0.3
+func (m *Message) Param() string {
+	return fmt.Sprintf("%d.%d.%d", m.Discipline, m.Category, m.Number)
+}
+
+func ReadFile(fp string) ([]*Message, error) {
+	buf, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(buf)
+}
+
+// 依序解碼所有 message, message 之間的垃圾資料會跳過
+// 同一個 message 內有多個欄位 (section 2~7 重複) 時各自輸出
+func Decode(buf []byte) ([]*Message, error) {
+	out := make([]*Message, 0, 16)
+	for {
+		idx := bytes.Index(buf, []byte("GRIB"))
+		if idx < 0 {
+			break
+		}
+		buf = buf[idx:]
+		if len(buf) < 16 {
+			return out, ErrShort
+		}
+		if buf[7] != 2 {
+			// 不是 edition 2, 跳過 (GRIB1 長度為3 bytes)
+			buf = buf[4:]
+			continue
+		}
+		size := binary.BigEndian.Uint64(buf[8:16])
+		if size < 16 || size > uint64(len(buf)) {
+			return out, ErrShort
+		}
+		msgs, err := decodeMessage(buf[:size])
+		if err != nil {
+			return out, err
+		}
+		out = append(out, msgs...)
+		buf = buf[size:]
+	}
+	if len(out) == 0 {
+		return nil, ErrMagic
+	}
+	return out, nil
+}
+
+type drs struct {
+	template int
+	count int // 有值的點數
+
+	ref float32
+	bscale int
+	dscale int
+	nbits int
+
+	// complex packing
+	missingMgmt int
+	missing1, missing2 float64
+	ngroups int
+	widthRef int
+	widthBits int
+	lenRef int
+	lenInc int
+	lenLast int
+	lenBits int
+
+	// spatial differencing
+	order int
+	extraBytes int
+}
+
+func decodeMessage(buf []byte) ([]*Message, error) {
+	if !bytes.Equal(buf[:4], []byte("GRIB")) {
+		return nil, ErrMagic
+	}
+	discipline := int(buf[6])
+
+	var (
+		refTime time.Time
+		grid *Grid
+		npoints int
+		cur *Message
+		rep *drs
+		bitmap []byte
+		out []*Message
+	)
+
+	off := 16
+	for off < len(buf) {
+		if off+4 <= len(buf) && bytes.Equal(buf[off:off+4], []byte("7777")) {
+			break
+		}
+		if off+5 > len(buf) {
+			return nil, ErrShort
+		}
+		slen := int(binary.BigEndian.Uint32(buf[off:]))
+		if slen < 5 || off+slen > len(buf) {
+			return nil, ErrShort
+		}
+		sec := buf[off : off+slen]
+		num := int(sec[4])
+		off += slen
+
+		switch num {
+		case 1:
+			if len(sec) < 19 {
+				return nil, ErrShort
+			}
+			refTime = time.Date(int(binary.BigEndian.Uint16(sec[12:])), time.Month(sec[14]), int(sec[15]),
+				int(sec[16]), int(sec[17]), int(sec[18]), 0, time.UTC)
+		case 2: // local use, 略過
+		case 3:
+			g, n, err := parseGrid(sec)
+			if err != nil {
+				return nil, err
+			}
+			grid, npoints = g, n
+		case 4:
+			m, err := parseProduct(sec)
+			if err != nil {
+				return nil, err
+			}
+			m.Discipline = discipline
+			m.RefTime = refTime
+			cur = m
+		case 5:
+			r, err := parseDRS(sec)
+			if err != nil {
+				return nil, err
+			}
+			rep = r
+		case 6:
+			if len(sec) < 6 {
+				return nil, ErrShort
+			}
+			switch sec[5] {
+			case 0:
+				bitmap = sec[6:]
+			case 254: // 沿用前一個
+			case 255:
+				bitmap = nil
+			default:
+				return nil, &ErrTemplate{6, int(sec[5])}
+			}
+		case 7:
+			if grid == nil || cur == nil || rep == nil {
+				return nil, errors.New("data section before grid/product/representation")
+			}
+			vals, err := unpack(rep, sec[5:], npoints)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", cur.Param(), err)
+			}
+			cur.Grid = *grid
+			cur.Values, err = applyBitmap(vals, bitmap, npoints)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", cur.Param(), err)
+			}
+			out = append(out, cur)
+			cur = nil
+		default:
+			return nil, fmt.Errorf("unknown section %v", num)
+		}
+	}
+	return out, nil
+}
+
+// GRIB2 的有號整數為 sign-magnitude, 最高位元為正負號
+func sint(b []byte) int {
+	var v uint64
+	for _, x := range b {
+		v = v<<8 | uint64(x)
+	}
+	sign := uint64(1) << (uint(len(b))*8 - 1)
+	if v&sign != 0 {
+		return -int(v &^ sign)
+	}
+	return int(v)
+}
+
+func uintN(b []byte) int {
+	v := 0
+	for _, x := range b {
+		v = v<<8 | int(x)
+	}
+	return v
+}
+
+func parseGrid(sec []byte) (*Grid, int, error) {
+	if len(sec) < 14 {
+		return nil, 0, ErrShort
+	}
+	if sec[5] != 0 {
+		return nil, 0, errors.New("grid definition not from template")
+	}
+	npoints := uintN(sec[6:10])
+	tmpl := uintN(sec[12:14])
+	if tmpl != 0 {
+		return nil, 0, &ErrTemplate{3, tmpl}
+	}
+	if len(sec) < 72 {
+		return nil, 0, ErrShort
+	}
+
+	// 角度單位: basic angle / subdivisions, 0 或 missing 時為 1e-6 度
+	unit := 1e-6
+	basic, subdiv := uintN(sec[38:42]), uintN(sec[42:46])
+	if basic != 0 && basic != 0xFFFFFFFF && subdiv != 0 && subdiv != 0xFFFFFFFF {
+		unit = float64(basic) / float64(subdiv)
+	}
+
+	g := &Grid{
+		Ni: uintN(sec[30:34]),
+		Nj: uintN(sec[34:38]),
+		La1: float64(sint(sec[46:50])) * unit,
+		Lo1: float64(sint(sec[50:54])) * unit,
+		La2: float64(sint(sec[55:59])) * unit,
+		Lo2: float64(sint(sec[59:63])) * unit,
+		Di: float64(uintN(sec[63:67])) * unit,
+		Dj: float64(uintN(sec[67:71])) * unit,
+		ScanMode: int(sec[71]),
+	}
+	if npoints > maxPoints {
+		return nil, 0, fmt.Errorf("grid %v points exceeds %v", npoints, maxPoints)
+	}
+	if g.Ni*g.Nj != npoints {
+		return nil, 0, fmt.Errorf("grid %vx%v != %v points", g.Ni, g.Nj, npoints)
+	}
+	if g.ScanMode&0x10 != 0 {
+		return nil, 0, errors.New("boustrophedonic scanning not supported")
+	}
+	return g, npoints, nil
+}
+
+// Code table 4.4
+var timeUnits = map[int]time.Duration{
+	0: time.Minute,
+	1: time.Hour,
+	2: 24 * time.Hour,
+	10: 3 * time.Hour,
+	11: 6 * time.Hour,
+	12: 12 * time.Hour,
+	13: time.Second,
+}
+
+func parseProduct(sec []byte) (*Message, error) {
+	if len(sec) < 9 {
+		return nil, ErrShort
+	}
+	tmpl := uintN(sec[7:9])
+	// 4.0 ~ 4.15 前面的欄位相同
+	if tmpl > 15 {
+		return nil, &ErrTemplate{4, tmpl}
+	}
+	if len(sec) < 34 {
+		return nil, ErrShort
+	}
+	unit, ok := timeUnits[int(sec[17])]
+	if !ok {
+		return nil, fmt.Errorf("unsupported time unit %v", sec[17])
+	}
+	return &Message{
+		Category: int(sec[9]),
+		Number: int(sec[10]),
+		Forecast: time.Duration(uintN(sec[18:22])) * unit,
+		Surface: int(sec[22]),
+	}, nil
+}
+
+func parseDRS(sec []byte) (*drs, error) {
+	if len(sec) < 21 {
+		return nil, ErrShort
+	}
+	r := &drs{
+		count: uintN(sec[5:9]),
+		template: uintN(sec[9:11]),
+		ref: math.Float32frombits(binary.BigEndian.Uint32(sec[11:15])),
+		bscale: sint(sec[15:17]),
+		dscale: sint(sec[17:19]),
+		nbits: int(sec[19]),
+	}
+	switch r.template {
+	case 0:
+		return r, nil
+	case 2, 3:
+	default:
+		return nil, &ErrTemplate{5, r.template}
+	}
+
+	if len(sec) < 47 {
+		return nil, ErrShort
+	}
+	r.missingMgmt = int(sec[22])
+	r.missing1 = float64(math.Float32frombits(binary.BigEndian.Uint32(sec[23:27])))
+	r.missing2 = float64(math.Float32frombits(binary.BigEndian.Uint32(sec[27:31])))
+	r.ngroups = uintN(sec[31:35])
+	r.widthRef = int(sec[35])
+	r.widthBits = int(sec[36])
+	r.lenRef = uintN(sec[37:41])
+	r.lenInc = int(sec[41])
+	r.lenLast = uintN(sec[42:46])
+	r.lenBits = int(sec[46])
+	if r.template == 3 {
+		if len(sec) < 49 {
+			return nil, ErrShort
+		}
+		r.order = int(sec[47])
+		r.extraBytes = int(sec[48])
+		if r.order != 1 && r.order != 2 {
+			return nil, fmt.Errorf("unsupported spatial differencing order %v", r.order)
+		}
+	}
+	return r, nil
+}
+
+// Y = (R + X * 2^E) / 10^D
+func (r *drs) scale(x float64) float64 {
+	return (float64(r.ref) + x*math.Pow(2, float64(r.bscale))) / math.Pow(10, float64(r.dscale))
+}
+
+// header 內的點數/群組數在配置前先與格點數及資料段長度比對, 壞掉的 message 不會用光記憶體
+func unpack(r *drs, data []byte, npoints int) ([]float64, error) {
+	if r.count > npoints {
+		return nil, fmt.Errorf("%v values for %v points", r.count, npoints)
+	}
+	if r.nbits > maxBits {
+		return nil, fmt.Errorf("%v bits per value", r.nbits)
+	}
+	bits := len(data) * 8
+	if r.template == 0 {
+		if r.nbits > 0 && r.count > bits/r.nbits {
+			return nil, ErrShort
+		}
+		return unpackSimple(r, data)
+	}
+
+	// 每個群組至少一點, ref/width/length 各佔 nbits/widthBits/lenBits
+	if r.ngroups > r.count {
+		return nil, fmt.Errorf("%v groups for %v values", r.ngroups, r.count)
+	}
+	if r.widthBits > maxBits || r.lenBits > maxBits {
+		return nil, fmt.Errorf("group width/length %v/%v bits", r.widthBits, r.lenBits)
+	}
+	if r.ngroups*(r.nbits+r.widthBits+r.lenBits) > bits {
+		return nil, ErrShort
+	}
+	return unpackComplex(r, data)
+}
+
+func unpackSimple(r *drs, data []byte) ([]float64, error) {
+	out := make([]float64, r.count)
+	if r.nbits == 0 {
+		v := r.scale(0)
+		for i := range out {
+			out[i] = v
+		}
+		return out, nil
+	}
+	br := &bitReader{buf: data}
+	bs := math.Pow(2, float64(r.bscale))
+	ds := math.Pow(10, float64(r.dscale))
+	for i := range out {
+		x, err := br.read(r.nbits)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = (float64(r.ref) + float64(x)*bs) / ds
+	}
+	return out, nil
+}
+
+func unpackComplex(r *drs, data []byte) ([]float64, error) {
+	br := &bitReader{buf: data}
+
+	// spatial differencing 的初始值與最小值
+	var ival [2]int
+	var minsd int
+	if r.template == 3 {
+		nb := r.extraBytes
+		for i := 0; i < r.order; i++ {
+			b, err := br.bytes(nb)
+			if err != nil {
+				return nil, err
+			}
+			ival[i] = sint(b)
+		}
+		b, err := br.bytes(nb)
+		if err != nil {
+			return nil, err
+		}
+		minsd = sint(b)
+	}
+
+	ng := r.ngroups
+	refs := make([]int, ng)
+	for i := range refs {
+		x, err := br.read(r.nbits)
+		if err != nil {
+			return nil, err
+		}
+		refs[i] = x
+	}
+	br.align()
+
+	widths := make([]int, ng)
+	for i := range widths {
+		x, err := br.read(r.widthBits)
+		if err != nil {
+			return nil, err
+		}
+		widths[i] = r.widthRef + x
+	}
+	br.align()
+
+	lens := make([]int, ng)
+	total := 0
+	for i := range lens {
+		x, err := br.read(r.lenBits)
+		if err != nil {
+			return nil, err
+		}
+		lens[i] = r.lenRef + x*r.lenInc
+		if i == ng-1 {
+			lens[i] = r.lenLast
+		}
+		total += lens[i]
+		if total > r.count {
+			return nil, fmt.Errorf("group lengths exceed %v points", r.count)
+		}
+	}
+	br.align()
+	if total != r.count {
+		return nil, fmt.Errorf("group lengths %v != %v points", total, r.count)
+	}
+
+	// 群組內的值, 無資料記在 miss
+	vals := make([]int, r.count)
+	miss := make([]bool, r.count)
+	refMiss1 := 1<<r.nbits - 1
+	refMiss2 := 1<<r.nbits - 2
+	k := 0
+	for g := 0; g < ng; g++ {
+		w := widths[g]
+		if w > maxBits {
+			return nil, fmt.Errorf("group width %v bits", w)
+		}
+		for j := 0; j < lens[g]; j++ {
+			if w == 0 {
+				vals[k] = refs[g]
+				if r.missingMgmt >= 1 && refs[g] == refMiss1 {
+					miss[k] = true
+				} else if r.missingMgmt == 2 && refs[g] == refMiss2 {
+					miss[k] = true
+				}
+				k++
+				continue
+			}
+			x, err := br.read(w)
+			if err != nil {
+				return nil, err
+			}
+			if r.missingMgmt >= 1 && x == 1<<w-1 {
+				miss[k] = true
+			} else if r.missingMgmt == 2 && x == 1<<w-2 {
+				miss[k] = true
+			} else {
+				vals[k] = refs[g] + x
+			}
+			k++
+		}
+	}
+
+	// 還原 spatial differencing, 只對有值的點
+	if r.template == 3 {
+		n := 0
+		var prev1, prev2 int
+		for i := range vals {
+			if miss[i] {
+				continue
+			}
+			switch {
+			case n < r.order:
+				vals[i] = ival[n]
+			case r.order == 1:
+				vals[i] = vals[i] + minsd + prev1
+			default:
+				vals[i] = vals[i] + minsd + 2*prev1 - prev2
+			}
+			prev2 = prev1
+			prev1 = vals[i]
+			n++
+		}
+	}
+
+	out := make([]float64, r.count)
+	bs := math.Pow(2, float64(r.bscale))
+	ds := math.Pow(10, float64(r.dscale))
+	for i, x := range vals {
+		if miss[i] {
+			out[i] = math.NaN()
+			continue
+		}
+		out[i] = (float64(r.ref) + float64(x)*bs) / ds
+	}
+	return out, nil
+}
+
+// bitmap 為 1 的點依序填入值, 其餘為 NaN
+func applyBitmap(vals []float64, bitmap []byte, npoints int) ([]float64, error) {
+	if bitmap == nil {
+		if len(vals) != npoints {
+			return nil, fmt.Errorf("%v values for %v points", len(vals), npoints)
+		}
+		return vals, nil
+	}
+	if len(bitmap)*8 < npoints {
+		return nil, errors.New("bitmap too short")
+	}
+	out := make([]float64, npoints)
+	k := 0
+	for i := range out {
+		if bitmap[i/8]&(0x80>>(i%8)) == 0 {
+			out[i] = math.NaN()
+			continue
+		}
+		if k >= len(vals) {
+			return nil, errors.New("bitmap count exceeds values")
+		}
+		out[i] = vals[k]
+		k++
+	}
+	return out, nil
+}
+
+type bitReader struct {
+	buf []byte
+	pos int // bit offset
+}
+
+func (br *bitReader) read(n int) (int, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if br.pos+n > len(br.buf)*8 {
+		return 0, ErrShort
+	}
+	v := 0
+	for i := 0; i < n; i++ {
+		p := br.pos + i
+		v = v<<1 | int(br.buf[p/8]>>(7-p%8)&1)
+	}
+	br.pos += n
+	return v, nil
+}
+
+func (br *bitReader) align() {
+	br.pos = (br.pos + 7) &^ 7
+}
+
+func (br *bitReader) bytes(n int) ([]byte, error) {
+	br.align()
+	st := br.pos / 8
+	if st+n > len(br.buf) {
+		return nil, ErrShort
+	}
+	br.pos += n * 8
+	return br.buf[st : st+n], nil
+}
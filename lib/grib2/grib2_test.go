@@ -0,0 +1,412 @@
+package grib2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ==== 組 GRIB2 message (只填解碼用到的欄位) ====
+
+func section(num int, body []byte) []byte {
+	b := make([]byte, 5, 5 + len(body))
+	binary.BigEndian.PutUint32(b, uint32(5 + len(body)))
+	b[4] = byte(num)
+	return append(b, body...)
+}
+
+func message(discipline int, secs ...[]byte) []byte {
+	var body []byte
+	for _, s := range secs {
+		body = append(body, s...)
+	}
+	b := make([]byte, 16)
+	copy(b, "GRIB")
+	b[6] = byte(discipline)
+	b[7] = 2
+	binary.BigEndian.PutUint64(b[8:], uint64(16 + len(body) + 4))
+	b = append(b, body...)
+	return append(b, "7777"...)
+}
+
+func sec1(t time.Time) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint16(b[7:], uint16(t.Year()))
+	b[9] = byte(t.Month())
+	b[10] = byte(t.Day())
+	b[11] = byte(t.Hour())
+	b[12] = byte(t.Minute())
+	b[13] = byte(t.Second())
+	return section(1, b)
+}
+
+// sign-magnitude
+func putSint(b []byte, v int) {
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	if neg {
+		b[0] |= 0x80
+	}
+}
+
+// Template 3.0, 角度單位 1e-6 度
+func sec3(ni int, nj int, la1 float64, lo1 float64, la2 float64, lo2 float64, di float64, dj float64, scan int) []byte {
+	b := make([]byte, 67)
+	binary.BigEndian.PutUint32(b[1:], uint32(ni * nj))
+	binary.BigEndian.PutUint32(b[25:], uint32(ni))
+	binary.BigEndian.PutUint32(b[29:], uint32(nj))
+	putSint(b[41:45], int(math.Round(la1 * 1e6)))
+	putSint(b[45:49], int(math.Round(lo1 * 1e6)))
+	putSint(b[50:54], int(math.Round(la2 * 1e6)))
+	putSint(b[54:58], int(math.Round(lo2 * 1e6)))
+	binary.BigEndian.PutUint32(b[58:], uint32(math.Round(di * 1e6)))
+	binary.BigEndian.PutUint32(b[62:], uint32(math.Round(dj * 1e6)))
+	b[66] = byte(scan)
+	return section(3, b)
+}
+
+// Template 4.0, 預報時間單位為小時
+func sec4(category int, number int, hours int, surface int) []byte {
+	b := make([]byte, 29)
+	b[4] = byte(category)
+	b[5] = byte(number)
+	b[12] = 1
+	binary.BigEndian.PutUint32(b[13:], uint32(hours))
+	b[17] = byte(surface)
+	return section(4, b)
+}
+
+func drsHead(count int, template int, ref float32, bscale int, dscale int, nbits int) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint32(b, uint32(count))
+	binary.BigEndian.PutUint16(b[4:], uint16(template))
+	binary.BigEndian.PutUint32(b[6:], math.Float32bits(ref))
+	putSint(b[10:12], bscale)
+	putSint(b[12:14], dscale)
+	b[14] = byte(nbits)
+	return b
+}
+
+type complexSpec struct {
+	missingMgmt int
+	ngroups int
+	widthRef, widthBits int
+	lenRef, lenInc, lenLast, lenBits int
+	order, extraBytes int // template 5.3
+}
+
+func drsComplex(count int, template int, ref float32, bscale int, dscale int, nbits int, c complexSpec) []byte {
+	b := drsHead(count, template, ref, bscale, dscale, nbits)
+	ext := make([]byte, 26)
+	ext[1] = byte(c.missingMgmt)
+	binary.BigEndian.PutUint32(ext[10:], uint32(c.ngroups))
+	ext[14] = byte(c.widthRef)
+	ext[15] = byte(c.widthBits)
+	binary.BigEndian.PutUint32(ext[16:], uint32(c.lenRef))
+	ext[20] = byte(c.lenInc)
+	binary.BigEndian.PutUint32(ext[21:], uint32(c.lenLast))
+	ext[25] = byte(c.lenBits)
+	b = append(b, ext...)
+	if template == 3 {
+		b = append(b, byte(c.order), byte(c.extraBytes))
+	}
+	return section(5, b)
+}
+
+func sec6(bits []bool) []byte {
+	if bits == nil {
+		return section(6, []byte{255})
+	}
+	b := make([]byte, 1 + (len(bits) + 7) / 8)
+	for i, on := range bits {
+		if on {
+			b[1 + i / 8] |= 0x80 >> (i % 8)
+		}
+	}
+	return section(6, b)
+}
+
+type bitWriter struct {
+	buf []byte
+	n int
+}
+
+func (w *bitWriter) write(v int, nbits int) {
+	for i := nbits - 1; i >= 0; i-- {
+		if w.n % 8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if v >> uint(i) & 1 != 0 {
+			w.buf[len(w.buf) - 1] |= 0x80 >> (w.n % 8)
+		}
+		w.n++
+	}
+}
+
+func (w *bitWriter) align() {
+	w.n = (w.n + 7) &^ 7
+}
+
+func (w *bitWriter) bytes(b []byte) {
+	w.align()
+	w.buf = append(w.buf, b...)
+	w.n += len(b) * 8
+}
+
+func sameValues(t *testing.T, name string, got []float64, want []float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%v: %d values %v, want %d %v", name, len(got), got, len(want), want)
+	}
+	for i := range want {
+		if math.IsNaN(want[i]) != math.IsNaN(got[i]) || (!math.IsNaN(want[i]) && math.Abs(got[i] - want[i]) > 1e-9) {
+			t.Errorf("%v[%d] = %v, want %v (all %v)", name, i, got[i], want[i], got)
+		}
+	}
+}
+
+var testRef = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// 5.0 simple packing + bitmap, 北 >> 南掃描
+func TestDecodeSimple(t *testing.T) {
+	// 3x2, 第5點無資料; Y = (R + X * 2^1) / 10^1
+	xs := []int{0, 5, 10, 15, 125}
+	w := &bitWriter{}
+	for _, x := range xs {
+		w.write(x, 8)
+	}
+	buf := message(10,
+		sec1(testRef),
+		sec3(3, 2, 25, 120, 24, 122, 1, 1, 0),
+		sec4(0, 3, 6, 1),
+		section(5, drsHead(len(xs), 0, 100, 1, 1, 8)),
+		sec6([]bool{true, true, true, true, false, true}),
+		section(7, w.buf),
+	)
+	// 前後的垃圾資料要略過
+	buf = append([]byte("junk"), append(buf, "tail"...)...)
+
+	msgs, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("%d messages", len(msgs))
+	}
+	m := msgs[0]
+	if m.Param() != "10.0.3" || m.Surface != 1 || !m.ValidTime().Equal(testRef.Add(6 * time.Hour)) {
+		t.Errorf("param %v surface %v valid %v", m.Param(), m.Surface, m.ValidTime())
+	}
+	if m.Grid.Ni != 3 || m.Grid.Nj != 2 || m.Grid.La1 != 25 || m.Grid.Lo2 != 122 || m.Grid.Di != 1 {
+		t.Errorf("grid %+v", m.Grid)
+	}
+	sameValues(t, "values", m.Values, []float64{10, 11, 12, 13, math.NaN(), 35})
+
+	// 轉成 grid 後 row 0 為最南
+	g, err := m.ToGrid("浪高", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.La1 != 25 || g.La2 != 24 || g.Lo1 != 120 || g.Lo2 != 122 {
+		t.Errorf("grid %v-%v %v-%v", g.La2, g.La1, g.Lo1, g.Lo2)
+	}
+	got := make([]float64, len(g.Data["浪高"]))
+	for i, x := range g.Data["浪高"] {
+		got[i] = float64(x)
+	}
+	sameValues(t, "grid", got, []float64{13, math.NaN(), 35, 10, 11, 12})
+}
+
+// nbits = 0: 全部為 R
+func TestDecodeConstant(t *testing.T) {
+	buf := message(10,
+		sec1(testRef),
+		sec3(2, 1, 25, 120, 25, 121, 1, 1, 0x40),
+		sec4(0, 10, 0, 1),
+		section(5, drsHead(2, 0, 270, 0, 0, 0)),
+		sec6(nil),
+		section(7, nil),
+	)
+	msgs, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameValues(t, "values", msgs[0].Values, []float64{270, 270})
+}
+
+// 5.2 complex packing, primary missing value substitution
+func TestDecodeComplex(t *testing.T) {
+	// group 0: 寬度0, ref = 2^3-1 >> 兩點皆無資料
+	// group 1: ref 2, 寬度2, x = 0, 3(無資料), 1
+	// group 2: ref 5, 寬度0 >> 5
+	spec := complexSpec{missingMgmt: 1, ngroups: 3, widthRef: 0, widthBits: 2, lenRef: 1, lenInc: 1, lenLast: 1, lenBits: 2}
+	w := &bitWriter{}
+	for _, r := range []int{7, 2, 5} {
+		w.write(r, 3)
+	}
+	w.align()
+	for _, x := range []int{0, 2, 0} {
+		w.write(x, 2)
+	}
+	w.align()
+	for _, x := range []int{1, 2, 0} { // 2, 3, (last)
+		w.write(x, 2)
+	}
+	w.align()
+	for _, x := range []int{0, 3, 1} {
+		w.write(x, 2)
+	}
+
+	buf := message(10,
+		sec1(testRef),
+		sec3(3, 2, 24, 120, 25, 122, 1, 1, 0x40),
+		sec4(0, 10, 3, 1),
+		drsComplex(6, 2, 0, 0, 0, 3, spec),
+		sec6(nil),
+		section(7, w.buf),
+	)
+	msgs, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nan := math.NaN()
+	sameValues(t, "values", msgs[0].Values, []float64{nan, nan, 2, nan, 3, 5})
+}
+
+// 5.3 complex packing + 二階 spatial differencing
+func TestDecodeSpatialDiff(t *testing.T) {
+	// 原始整數 (減去 R 前)
+	orig := []int{10, 12, 15, 19, 22, 30, 31, 45}
+	// 二階差分: d[i] = v[i] - 2v[i-1] + v[i-2] = 1, 1, -1, 5, -7, 13; 最小值 -7
+	minsd := -7
+	packed := []int{0, 0}
+	for i := 2; i < len(orig); i++ {
+		packed = append(packed, orig[i] - 2 * orig[i-1] + orig[i-2] - minsd)
+	}
+	// packed = 0 0 | 8 8 6 | 12 0 20
+	groups := [][]int{{0, 0}, {8, 8, 6}, {12, 0, 20}}
+	refs := []int{0, 6, 0}
+	widths := []int{0, 2, 5}
+	spec := complexSpec{ngroups: 3, widthRef: 0, widthBits: 3, lenRef: 2, lenInc: 1, lenLast: 3, lenBits: 1, order: 2, extraBytes: 2}
+
+	w := &bitWriter{}
+	ival := make([]byte, 6)
+	putSint(ival[0:2], orig[0])
+	putSint(ival[2:4], orig[1])
+	putSint(ival[4:6], minsd)
+	w.bytes(ival)
+	for _, r := range refs {
+		w.write(r, 3)
+	}
+	w.align()
+	for _, x := range widths {
+		w.write(x, 3)
+	}
+	w.align()
+	for _, x := range []int{0, 1, 1} {
+		w.write(x, 1)
+	}
+	w.align()
+	k := 0
+	for g, vals := range groups {
+		for _, v := range vals {
+			if v != packed[k] {
+				t.Fatalf("bad test groups at %d", k)
+			}
+			k++
+			if widths[g] > 0 {
+				w.write(v - refs[g], widths[g])
+			}
+		}
+	}
+
+	// Y = (1000 + X) / 100
+	buf := message(10,
+		sec1(testRef),
+		sec3(4, 2, 24, 120, 25, 123, 1, 1, 0x40),
+		sec4(0, 11, 9, 1),
+		drsComplex(len(orig), 3, 1000, 0, 2, 3, spec),
+		sec6(nil),
+		section(7, w.buf),
+	)
+	msgs, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]float64, len(orig))
+	for i, v := range orig {
+		want[i] = float64(1000 + v) / 100
+	}
+	sameValues(t, "values", msgs[0].Values, want)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, err := Decode([]byte("nothing here")); err != ErrMagic {
+		t.Errorf("err = %v, want ErrMagic", err)
+	}
+	buf := message(10,
+		sec1(testRef),
+		sec3(2, 1, 25, 120, 25, 121, 1, 1, 0x40),
+		sec4(0, 3, 0, 1),
+		section(5, drsHead(2, 40, 0, 0, 0, 8)), // JPEG2000
+		sec6(nil),
+		section(7, []byte{1, 2}),
+	)
+	_, err := Decode(buf)
+	if e, ok := err.(*ErrTemplate); !ok || e.Section != 5 || e.Template != 40 {
+		t.Errorf("err = %v, want template 5.40", err)
+	}
+	// 長度超過實際資料
+	if _, err := Decode(bytes.TrimSuffix(buf, []byte("7777"))); err != ErrShort {
+		t.Errorf("err = %v, want ErrShort", err)
+	}
+}
+
+// header 的點數/群組數與格點數或資料段不符: 必須在配置前失敗
+func TestDecodeMalformed(t *testing.T) {
+	grid := sec3(3, 2, 24, 120, 25, 122, 1, 1, 0x40)
+	data := make([]byte, 6)
+	spec := complexSpec{ngroups: 2, widthRef: 0, widthBits: 2, lenRef: 3, lenInc: 1, lenLast: 3, lenBits: 2}
+	with := func(f func(c *complexSpec)) complexSpec {
+		c := spec
+		f(&c)
+		return c
+	}
+	cases := []struct {
+		name string
+		grid []byte
+		drs []byte
+		data []byte
+		want string
+	}{
+		{"simple count", grid, section(5, drsHead(0x7fffffff, 0, 0, 0, 0, 8)), data, "values for 6 points"},
+		{"constant count", grid, section(5, drsHead(0x7fffffff, 0, 0, 0, 0, 0)), nil, "values for 6 points"},
+		{"simple truncated", grid, section(5, drsHead(6, 0, 0, 0, 0, 16)), data, ErrShort.Error()},
+		{"simple bits", grid, section(5, drsHead(6, 0, 0, 0, 0, 64)), data, "bits per value"},
+		{"grid points", sec3(0x4000, 0x4000, 24, 120, 25, 122, 1, 1, 0x40), section(5, drsHead(6, 0, 0, 0, 0, 8)), data, "exceeds"},
+		{"complex count", grid, drsComplex(0x7fffffff, 2, 0, 0, 0, 3, spec), data, "values for 6 points"},
+		{"complex groups", grid, drsComplex(6, 2, 0, 0, 0, 3, with(func(c *complexSpec) { c.ngroups = 0x7fffffff })), data, "groups for 6 values"},
+		{"complex truncated", grid, drsComplex(6, 2, 0, 0, 0, 3, with(func(c *complexSpec) { c.ngroups = 6; c.lenRef = 1; c.lenLast = 1 })), []byte{0}, ErrShort.Error()},
+		{"complex lengths", grid, drsComplex(6, 2, 0, 0, 0, 3, with(func(c *complexSpec) { c.lenLast = 0xffffffff })), data, "group lengths"},
+		{"complex width bits", grid, drsComplex(6, 2, 0, 0, 0, 3, with(func(c *complexSpec) { c.widthBits = 200 })), data, "bits"},
+		{"complex group width", grid, drsComplex(6, 2, 0, 0, 0, 3, with(func(c *complexSpec) { c.widthRef = 250 })), data, "group width 250"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := message(10, sec1(testRef), tc.grid, sec4(0, 3, 0, 1), tc.drs, sec6(nil), section(7, tc.data))
+			_, err := Decode(buf)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package grib2
+
+/*
+* NWW3 (WAVEWATCH III) 波浪模式 >> VectorGrid
+* key與單位與 F-A0020-001 的輸出相同, 方便共用後續流程:
+*   浪高: cm, 週期: 0.01秒, 浪向: 度 (來向)
+* 週期取尖峰週期 (10.0.34 PWPER); NCEP gfswave 沒有輸出尖峰週期,
+* 只有主波的平均週期 (10.0.11 PERPW), 沒有 PWPER 時才用 PERPW 代替
+* 只取海面 (第一層固定面 1) 的欄位, 同一時間同一個key重複時保留第一個 (例: ensemble 各成員)
+*/
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+type WaveVar struct {
+	Key string // grid key
+	Scale float64 // GRIB2 的值乘上 Scale
+	Units string
+	Desc string
+	Rank int // 多個參數對應到同一個key時取大的
+}
+
+// discipline.category.number >> grid key
+// 10.0.x: Oceanographic products, Waves (Code table 4.2-10-0)
+var WaveVars = map[string]WaveVar{
+	"10.0.3": {"浪高", 100, "cm", "示性波高(HTSGW)", 1},
+	"10.0.34": {"週期", 100, "0.01 s", "尖峰週期(PWPER)", 2},
+	"10.0.11": {"週期", 100, "0.01 s", "主波平均週期(PERPW)", 1},
+	"10.0.10": {"浪向", 1, "degree", "主波向(DIRPW)", 1},
+}
+
+// Code table 4.5: ground or water surface
+const SurfaceWater = 1
+
+// 裁切範圍 minLon, minLat, maxLon, maxLat; nil 表示全部
+type BBox []float64
+
+// 依有效時間合併, 每個時間一個grid, 回傳依時間排序
+// 浪高/週期/浪向以外的欄位及非海面的欄位會略過
+func WaveGrids(msgs []*Message, bbox BBox) ([]*lib.VectorGrid, []time.Time, error) {
+	if bbox != nil && len(bbox) != 4 {
+		return nil, nil, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+
+	byTime := make(map[int64]*lib.VectorGrid)
+	src := make(map[int64]map[string]WaveVar) // 已填入的key來自哪個參數
+	times := make([]time.Time, 0, 16)
+	for _, m := range msgs {
+		wv, ok := WaveVars[m.Param()]
+		if !ok {
+			lib.Vln(5, "[grib2]skip", m.Param(), m.ValidTime())
+			continue
+		}
+		if m.Surface != SurfaceWater {
+			lib.Vln(4, "[grib2]skip surface", m.Param(), m.Surface, m.ValidTime())
+			continue
+		}
+		t := m.ValidTime()
+		if prev, ok := src[t.Unix()][wv.Key]; ok && prev.Rank >= wv.Rank {
+			if prev.Rank == wv.Rank {
+				lib.Vln(2, "[grib2]duplicate, keep first", m.Param(), wv.Key, t)
+			}
+			continue
+		}
+
+		g, err := m.ToGrid(wv.Key, bbox)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v: %v", m.Param(), err)
+		}
+		for i, x := range g.Data[wv.Key] {
+			if !x.IsNaN() {
+				g.Data[wv.Key][i] = lib.JsonFloat(math.Round(float64(x) * wv.Scale))
+			}
+		}
+		g.CalcRange(wv.Key)
+		g.Units[wv.Key] = wv.Units
+
+		dst, ok := byTime[t.Unix()]
+		if !ok {
+			byTime[t.Unix()] = g
+			src[t.Unix()] = map[string]WaveVar{wv.Key: wv}
+			times = append(times, t)
+			continue
+		}
+		if dst.Nx != g.Nx || dst.Ny != g.Ny {
+			return nil, nil, fmt.Errorf("grid size mismatch at %v: %vx%v, %vx%v", t, dst.Nx, dst.Ny, g.Nx, g.Ny)
+		}
+		dst.Data[wv.Key] = g.Data[wv.Key]
+		dst.DataRange[wv.Key] = g.DataRange[wv.Key]
+		dst.Units[wv.Key] = wv.Units
+		src[t.Unix()][wv.Key] = wv
+	}
+	if len(times) == 0 {
+		return nil, nil, errors.New("no wave parameter found")
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	grids := make([]*lib.VectorGrid, len(times))
+	for i, t := range times {
+		// 說明沿用 "NWW3 浪高說明;NWW3 週期說明" 的格式, 依key排序
+		keys := make([]string, 0, len(src[t.Unix()]))
+		for k := range src[t.Unix()] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		descs := make([]string, len(keys))
+		for j, k := range keys {
+			descs[j] = "NWW3 " + src[t.Unix()][k].Desc
+		}
+		grids[i] = byTime[t.Unix()]
+		grids[i].Desc = strings.Join(descs, ";")
+	}
+	return grids, times, nil
+}
+
+// 轉成 VectorGrid: row 0 為最南, 由西往東
+func (m *Message) ToGrid(key string, bbox BBox) (*lib.VectorGrid, error) {
+	g := m.Grid
+	if g.Ni < 1 || g.Nj < 1 || len(m.Values) != g.Ni*g.Nj {
+		return nil, fmt.Errorf("bad grid %vx%v with %v values", g.Ni, g.Nj, len(m.Values))
+	}
+	if g.ScanMode&0x20 != 0 {
+		return nil, errors.New("column-major scanning not supported")
+	}
+
+	// 各軸座標 (由西往東, 由南往北)
+	lon0, lat0 := g.Lo1, g.La1
+	if g.ScanMode&0x80 != 0 {
+		lon0 = g.Lo2
+	}
+	if g.ScanMode&0x40 == 0 {
+		lat0 = g.La2
+	}
+	di, dj := g.Di, g.Dj
+	if g.Ni > 1 && di == 0 {
+		di = math.Abs(g.Lo2-g.Lo1) / float64(g.Ni-1)
+	}
+	if g.Nj > 1 && dj == 0 {
+		dj = math.Abs(g.La2-g.La1) / float64(g.Nj-1)
+	}
+
+	x0, x1, y0, y1 := 0, g.Ni-1, 0, g.Nj-1
+	if bbox != nil {
+		minLon, maxLon := bbox[0], bbox[2]
+		// 0~360 的網格
+		for minLon < lon0 {
+			minLon += 360
+			maxLon += 360
+		}
+		x0 = int(math.Ceil((minLon - lon0) / di - 1e-6))
+		x1 = int(math.Floor((maxLon - lon0) / di + 1e-6))
+		y0 = int(math.Ceil((bbox[1] - lat0) / dj - 1e-6))
+		y1 = int(math.Floor((bbox[3] - lat0) / dj + 1e-6))
+		if x0 < 0 {
+			x0 = 0
+		}
+		if y0 < 0 {
+			y0 = 0
+		}
+		if x1 > g.Ni-1 {
+			x1 = g.Ni - 1
+		}
+		if y1 > g.Nj-1 {
+			y1 = g.Nj - 1
+		}
+		if x0 > x1 || y0 > y1 {
+			return nil, fmt.Errorf("bbox %v out of grid", []float64(bbox))
+		}
+	}
+
+	nx, ny := x1-x0+1, y1-y0+1
+	arr := make([]lib.JsonFloat, nx*ny)
+	for r := 0; r < ny; r++ {
+		// 檔案內的 row
+		sr := y0 + r
+		if g.ScanMode&0x40 == 0 {
+			sr = g.Nj - 1 - sr
+		}
+		for c := 0; c < nx; c++ {
+			sc := x0 + c
+			if g.ScanMode&0x80 != 0 {
+				sc = g.Ni - 1 - sc
+			}
+			arr[r*nx+c] = lib.JsonFloat(m.Values[sr*g.Ni+sc])
+		}
+	}
+
+	out := lib.NewVectorGrid()
+	out.Nx = nx
+	out.Ny = ny
+	out.Lo1 = float32(lon0 + float64(x0)*di)
+	out.Lo2 = float32(lon0 + float64(x1)*di)
+	out.La2 = float32(lat0 + float64(y0)*dj)
+	out.La1 = float32(lat0 + float64(y1)*dj)
+	out.Time = m.ValidTime().Format(time.RFC3339)
+	out.Desc = m.Param()
+	out.Data[key] = arr
+	out.CalcRange(key)
+	return out, nil
+}
@@ -0,0 +1,74 @@
+package grib2
+
+import (
+	"testing"
+	"time"
+)
+
+func waveMsg(number int, surface int, hours int, v float64) *Message {
+	return &Message{
+		Discipline: 10,
+		RefTime: testRef,
+		Category: 0,
+		Number: number,
+		Forecast: time.Duration(hours) * time.Hour,
+		Surface: surface,
+		Grid: Grid{Ni: 2, Nj: 1, La1: 25, Lo1: 120, La2: 25, Lo2: 121, Di: 1, Dj: 1, ScanMode: 0x40},
+		Values: []float64{v, v + 1},
+	}
+}
+
+func TestWaveGrids(t *testing.T) {
+	msgs := []*Message{
+		waveMsg(3, 1, 0, 1.5), // 浪高 1.5 m
+		waveMsg(11, 1, 0, 8), // PERPW, 有 PWPER 時不用
+		waveMsg(34, 1, 0, 9.25), // PWPER
+		waveMsg(10, 1, 0, 270),
+		waveMsg(3, 241, 0, 7), // 非海面 (swell 分量等)
+		waveMsg(3, 1, 0, 9), // 重複 (例: ensemble 成員), 保留第一個
+		waveMsg(11, 1, 3, 7.5), // 只有 PERPW
+		waveMsg(3, 1, 3, 2),
+		waveMsg(2, 1, 3, 99), // 其他參數
+	}
+	grids, times, err := WaveGrids(msgs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grids) != 2 || !times[0].Equal(testRef) || !times[1].Equal(testRef.Add(3 * time.Hour)) {
+		t.Fatalf("grids %d times %v", len(grids), times)
+	}
+
+	check := func(i int, key string, want ...float32) {
+		t.Helper()
+		got := grids[i].Data[key]
+		if len(got) != len(want) {
+			t.Errorf("%d %v = %v, want %v", i, key, got, want)
+			return
+		}
+		for j := range want {
+			if float32(got[j]) != want[j] {
+				t.Errorf("%d %v = %v, want %v", i, key, got, want)
+				return
+			}
+		}
+	}
+	check(0, "浪高", 150, 250)
+	check(0, "週期", 925, 1025)
+	check(0, "浪向", 270, 271)
+	check(1, "浪高", 200, 300)
+	check(1, "週期", 750, 850)
+	if _, ok := grids[1].Data["浪向"]; ok {
+		t.Errorf("unexpected 浪向 at +3h")
+	}
+	if d := grids[0].Desc; d != "NWW3 主波向(DIRPW);NWW3 示性波高(HTSGW);NWW3 尖峰週期(PWPER)" {
+		t.Errorf("desc = %v", d)
+	}
+	if d := grids[1].Desc; d != "NWW3 示性波高(HTSGW);NWW3 主波平均週期(PERPW)" {
+		t.Errorf("desc = %v", d)
+	}
+
+	// 只有非海面的欄位
+	if _, _, err := WaveGrids([]*Message{waveMsg(3, 241, 0, 1)}, nil); err == nil {
+		t.Error("no error without surface fields")
+	}
+}
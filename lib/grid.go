@@ -0,0 +1,168 @@
+package lib
+
+/*
+* 共通的格點資料結構
+* Data 內為1D-array, 由南往北逐列(row)排列, 每列由西往東
+* 第0列為緯度 La2 (最南), 最後一列為緯度 La1 (最北)
+*/
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"encoding/json"
+)
+
+type VectorGrid struct {
+	// 原點 經度, 緯度
+	Lo1 float32 `json:"lo1"`
+	La1 float32 `json:"la1"`
+
+	// 終點 經度, 緯度
+	Lo2 float32 `json:"lo2"`
+	La2 float32 `json:"la2"`
+
+	Nx int `json:"nx"` // 經度格數
+	Ny int `json:"ny"` // 緯度格數
+
+	Time string `json:"time"` // just copy now
+	Desc string `json:"Description"`  // just copy
+
+	DataRange map[string][]JsonFloat `json:"drange"`
+
+	Data map[string][]JsonFloat `json:"d"`
+
+	Units map[string]string `json:"-"` // 原始資料的單位(measures), 不輸出到json
+
+	// 原始座標 (由西往東, 由南往北), 有時才檢查間距, 不輸出到json
+	Lons []float64 `json:"-"`
+	Lats []float64 `json:"-"`
+}
+
+func NewVectorGrid() *VectorGrid {
+	vg := &VectorGrid{}
+	vg.Data = make(map[string][]JsonFloat, 2)
+	vg.DataRange = make(map[string][]JsonFloat, 2)
+	vg.Units = make(map[string]string, 2)
+	return vg
+}
+
+// NaN <> ""
+type JsonFloat float32
+
+func (value JsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(value)) {
+		return []byte("\"\""), nil
+	}
+	return []byte(fmt.Sprintf("%v", value)), nil
+}
+
+func (value *JsonFloat) UnmarshalJSON(b []byte) error {
+	str := string(b)
+	if str == "\"\"" || str == "null" {
+		*value = JsonFloat(math.NaN())
+		return nil
+	}
+	v, err := strconv.ParseFloat(str, 32)
+	if err != nil {
+		return err
+	}
+	*value = JsonFloat(v)
+	return nil
+}
+
+func (value JsonFloat) IsNaN() bool {
+	return math.IsNaN(float64(value))
+}
+
+// 重新計算 drange (忽略NaN), 全為NaN時移除
+func (vg *VectorGrid) CalcRange(key string) {
+	arr, ok := vg.Data[key]
+	if !ok {
+		return
+	}
+	var minMax []JsonFloat
+	for _, v := range arr {
+		if v.IsNaN() {
+			continue
+		}
+		if minMax == nil {
+			minMax = []JsonFloat{v, v}
+			continue
+		}
+		if v < minMax[0] {
+			minMax[0] = v
+		}
+		if v > minMax[1] {
+			minMax[1] = v
+		}
+	}
+	if minMax == nil {
+		delete(vg.DataRange, key)
+		return
+	}
+	vg.DataRange[key] = minMax
+}
+
+// 經度/緯度 間距
+func (vg *VectorGrid) Dx() float32 {
+	if vg.Nx < 2 {
+		return 0
+	}
+	return (vg.Lo2 - vg.Lo1) / float32(vg.Nx - 1)
+}
+
+func (vg *VectorGrid) Dy() float32 {
+	if vg.Ny < 2 {
+		return 0
+	}
+	return (vg.La1 - vg.La2) / float32(vg.Ny - 1)
+}
+
+// row: 0 == 最南, col: 0 == 最西
+func (vg *VectorGrid) At(key string, row int, col int) JsonFloat {
+	arr, ok := vg.Data[key]
+	idx := row * vg.Nx + col
+	if !ok || row < 0 || col < 0 || col >= vg.Nx || idx >= len(arr) {
+		return JsonFloat(math.NaN())
+	}
+	return arr[idx]
+}
+
+// Time 欄位沒有時區時當作UTC
+func (vg *VectorGrid) ParseTime() (time.Time, error) {
+	layouts := []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05",
+	}
+	var err error
+	for _, layout := range layouts {
+		var t time.Time
+		t, err = time.Parse(layout, vg.Time)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func ReadGridFile(fp string) (*VectorGrid, error) {
+	fd, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	grid := NewVectorGrid()
+	dec := json.NewDecoder(fd)
+	err = dec.Decode(grid)
+	if err != nil {
+		return nil, err
+	}
+	return grid, nil
+}
@@ -0,0 +1,102 @@
+package lib
+
+/*
+* log/slog 分級log, 可輸出 text 或 JSON (給log收集器)
+* -v 對應: 0 關閉, 1 error, 2 warn, 3 info, 4 debug, 5+ 更細的debug
+* 常用欄位: dataset, run_id, lead, file, duration
+* 舊的 Vln/Vf 仍可用, 依 level 轉成對應的 slog level, 訊息不變
+* 舊程式慣用 level 2 記錯誤 ("[get]err"), 訊息有 err/fail 時升為 error
+*/
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// 由main依 -v 參數設定 (SetupLog)
+var Verbosity = 3
+
+var logLevel = new(slog.LevelVar)
+
+// 欄位在編碼前先遮蔽 (編碼時 " \ 會被跳脫), 輸出再經過 RedactWriter
+var Logger = newLogger(os.Stderr, "text")
+
+// -v >> slog level
+func Level(verbosity int) slog.Level {
+	switch {
+	case verbosity <= 0:
+		return slog.LevelError + 4
+	case verbosity == 1:
+		return slog.LevelError
+	case verbosity == 2:
+		return slog.LevelWarn
+	case verbosity == 3:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug - slog.Level(verbosity - 4)
+	}
+}
+
+func newLogger(w io.Writer, format string) *slog.Logger {
+	opt := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: redactAttr}
+	w = RedactWriter(w)
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opt))
+	}
+	return slog.New(slog.NewTextHandler(w, opt))
+}
+
+// msg 及字串/error 欄位
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(Redact(err.Error()))
+		}
+	}
+	return a
+}
+
+// format: "text" 或 "json"; 標準 log 套件也導到同一個 Logger (level info)
+func SetupLog(verbosity int, format string) error {
+	switch format {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("unknown log format %q (text, json)", format)
+	}
+	Verbosity = verbosity
+	logLevel.Set(Level(verbosity))
+	Logger = newLogger(os.Stderr, format)
+	slog.SetDefault(Logger)
+	log.SetFlags(0)
+	return nil
+}
+
+func legacyLevel(level int, msg string) slog.Level {
+	lv := Level(level)
+	if lv == slog.LevelWarn && (strings.Contains(msg, "err") || strings.Contains(msg, "fail")) {
+		return slog.LevelError
+	}
+	return lv
+}
+
+// ==== log ====
+func Vf(level int, format string, v ...interface{}) {
+	if level <= Verbosity {
+		msg := fmt.Sprintf(format, v...)
+		Logger.Log(context.Background(), legacyLevel(level, msg), msg)
+	}
+}
+func Vln(level int, v ...interface{}) {
+	if level <= Verbosity {
+		msg := strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+		Logger.Log(context.Background(), legacyLevel(level, msg), msg)
+	}
+}
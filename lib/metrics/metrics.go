@@ -0,0 +1,187 @@
+package metrics
+
+/*
+* Prometheus text exposition format (0.0.4), 不依賴 client_golang
+* 常駐時以 HTTP (/metrics) 提供, 單次執行時寫成 node_exporter textfile collector 的 .prom 檔
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Type string
+
+const (
+	Gauge Type = "gauge"
+	Counter Type = "counter"
+)
+
+type family struct {
+	name string
+	help string
+	typ Type
+	values map[string]float64 // 已格式化的labels >> 值
+}
+
+type Registry struct {
+	mu sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// 預設的registry
+var Default = NewRegistry()
+
+// 先宣告才能 Set/Add
+func (r *Registry) Describe(name string, typ Type, help string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		return
+	}
+	r.families[name] = &family{name: name, help: help, typ: typ, values: make(map[string]float64)}
+}
+
+// labels: key, value, key, value...
+func (r *Registry) Set(name string, v float64, labels ...string) {
+	r.update(name, labels, func(old float64) float64 { return v })
+}
+
+func (r *Registry) Add(name string, v float64, labels ...string) {
+	r.update(name, labels, func(old float64) float64 { return old + v })
+}
+
+func (r *Registry) update(name string, labels []string, fn func(float64) float64) {
+	key := formatLabels(labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.families[name]
+	if !ok {
+		panic("metrics: " + name + " not described")
+	}
+	f.values[key] = fn(f.values[key])
+}
+
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name, f := range r.families {
+		if len(f.values) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(&buf, "# HELP %v %v\n", name, escapeHelp(f.help))
+		fmt.Fprintf(&buf, "# TYPE %v %v\n", name, f.typ)
+		keys := make([]string, 0, len(f.values))
+		for k := range f.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&buf, "%v%v %v\n", name, k, formatValue(f.values[k]))
+		}
+	}
+	r.mu.Unlock()
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// 先寫暫存檔再 rename, node_exporter 不會讀到寫一半的檔案
+func (r *Registry) WriteTextfile(fp string) error {
+	var buf bytes.Buffer
+	err := r.WriteText(&buf)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fp), "."+filepath.Base(fp)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fp)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func Describe(name string, typ Type, help string) { Default.Describe(name, typ, help) }
+func Set(name string, v float64, labels ...string) { Default.Set(name, v, labels...) }
+func Add(name string, v float64, labels ...string) { Default.Add(name, v, labels...) }
+func WriteTextfile(fp string) error { return Default.WriteTextfile(fp) }
+func Handler() http.Handler { return Default.Handler() }
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	if len(labels) % 2 != 0 {
+		panic("metrics: odd label list")
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		parts = append(parts, labels[i]+"=\""+escapeLabel(labels[i+1])+"\"")
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	// timestamp 等整數不用科學記號
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func text(t *testing.T, r *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// HELP/TYPE 各一行, family 與 labels 依名稱排序, 沒有值的 family 不輸出
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	r.Describe("b_total", Counter, "Runs.")
+	r.Describe("a_bytes", Gauge, "Size.")
+	r.Describe("c_unused", Gauge, "Never set.")
+	r.Add("b_total", 1, "dataset", "X", "result", "ok")
+	r.Add("b_total", 2, "dataset", "X", "result", "ok")
+	r.Add("b_total", 1, "dataset", "A", "result", "error")
+	r.Set("a_bytes", 10)
+	r.Set("a_bytes", 1234)
+
+	want := "# HELP a_bytes Size.\n" +
+		"# TYPE a_bytes gauge\n" +
+		"a_bytes 1234\n" +
+		"# HELP b_total Runs.\n" +
+		"# TYPE b_total counter\n" +
+		"b_total{dataset=\"A\",result=\"error\"} 1\n" +
+		"b_total{dataset=\"X\",result=\"ok\"} 3\n"
+	if got := text(t, r); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+
+	// 重複宣告不清掉已有的值
+	r.Describe("a_bytes", Counter, "Other.")
+	if got := text(t, r); got != want {
+		t.Errorf("after describe again:\n%v", got)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	r := NewRegistry()
+	r.Describe("m", Gauge, "Path C:\\tmp\nsecond \"line\".")
+	r.Set("m", 1, "var", "a\\b\n\"c\"")
+
+	want := "# HELP m Path C:\\\\tmp\\nsecond \"line\".\n" +
+		"# TYPE m gauge\n" +
+		"m{var=\"a\\\\b\\n\\\"c\\\"\"} 1\n"
+	if got := text(t, r); got != want {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+
+	// 中文 label 不轉義
+	if got := formatLabels([]string{"dataset", "F-A0020-001", "var", "浪高"}); got != `{dataset="F-A0020-001",var="浪高"}` {
+		t.Errorf("labels %v", got)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	cases := []struct {
+		v float64
+		want string
+	}{
+		{0, "0"},
+		{-3, "-3"},
+		{1700000000, "1700000000"},
+		{0.25, "0.25"},
+		{1.5e-7, "1.5e-07"},
+		{1e20, "1e+20"},
+		{math.NaN(), "NaN"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+	}
+	for _, tc := range cases {
+		if got := formatValue(tc.v); got != tc.want {
+			t.Errorf("formatValue(%v) = %q, want %q", tc.v, got, tc.want)
+		}
+	}
+}
+
+func TestPanic(t *testing.T) {
+	r := NewRegistry()
+	r.Describe("m", Gauge, "M.")
+	for name, fn := range map[string]func(){
+		"not described": func() { r.Set("x", 1) },
+		"odd labels": func() { r.Set("m", 1, "dataset") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v: no panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Describe("m", Gauge, "M.")
+	r.Set("m", 2)
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("content type %q", ct)
+	}
+	if got := w.Body.String(); got != text(t, r) {
+		t.Errorf("body %q", got)
+	}
+}
+
+// 寫完只留下目標檔, 覆寫舊檔
+func TestWriteTextfile(t *testing.T) {
+	dir := t.TempDir()
+	fp := filepath.Join(dir, "oac.prom")
+	if err := os.WriteFile(fp, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	r.Describe("m", Gauge, "M.")
+	r.Set("m", 1, "dataset", "X")
+	if err := r.WriteTextfile(fp); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(fp)
+	if err != nil || string(b) != text(t, r) {
+		t.Errorf("content %q, err %v", b, err)
+	}
+	if st, err := os.Stat(fp); err != nil || st.Mode().Perm() != 0644 {
+		t.Errorf("mode %v, err %v", st.Mode(), err)
+	}
+	if ents, _ := os.ReadDir(dir); len(ents) != 1 {
+		t.Errorf("files left: %v", ents)
+	}
+
+	if err := r.WriteTextfile(filepath.Join(dir, "no", "oac.prom")); err == nil {
+		t.Error("no error for missing directory")
+	}
+}
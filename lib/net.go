@@ -0,0 +1,332 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var UA = "OAC bot"
+
+// scheme 為目標網址的 scheme (https, http, sftp), 環境變數的 proxy 依此選擇
+type DialFunc func(scheme string, network string, addr string) (net.Conn, error)
+
+// 給 http.Transport 用, scheme 取自目標網址; nil 時為 nil (http.Transport 預設直連)
+func (dial DialFunc) ForURL(rawurl string) func(network, addr string) (net.Conn, error) {
+	if dial == nil {
+		return nil
+	}
+	scheme := ""
+	if u, err := url.Parse(rawurl); err == nil {
+		scheme = strings.ToLower(u.Scheme)
+	}
+	return func(network, addr string) (net.Conn, error) {
+		return dial(scheme, network, addr)
+	}
+}
+
+// 直連或透過 proxy, proxyAddr 見 ParseProxy
+// proxyAddr 空白時用環境變數 HTTPS_PROXY (https 目標) / HTTP_PROXY (其他), 都沒有時直連
+// 符合 NO_PROXY 的目標一律直連
+func NewDialFunc(proxyAddr string, timeout time.Duration) DialFunc {
+	direct := func(address string) (net.Conn, error) {
+		return net.DialTimeout("tcp", address, timeout)
+	}
+
+	var pick func(scheme string, address string) (*url.URL, error)
+	if proxyAddr != "" {
+		p, err := ParseProxy(proxyAddr)
+		pick = func(string, string) (*url.URL, error) { return p, err }
+	} else {
+		pick = proxyFromEnv()
+	}
+	noProxy := getEnvAny("NO_PROXY", "no_proxy")
+
+	return func(scheme string, network string, address string) (net.Conn, error) {
+		if network != "tcp" && network != "tcp4" && network != "tcp6" {
+			return nil, errors.New("only support tcp")
+		}
+		p, err := pick(scheme, address)
+		if err != nil {
+			return nil, err
+		}
+		if p == nil || matchNoProxy(address, noProxy) {
+			return direct(address)
+		}
+		return DialProxy(p, address, timeout)
+	}
+}
+
+func GetUrl(url string, dialFunc DialFunc, connTimeout time.Duration) ([]byte, error) {
+	resBody, err := GetUrlFd(url, dialFunc, connTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer resBody.Close()
+
+	data, err := ioutil.ReadAll(resBody)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// GetUrl/GetUrlFd 整次請求的期限 (含讀取 body)
+var GetTimeout = 180 * time.Second
+
+func GetUrlFd(url string, dialFunc DialFunc, connTimeout time.Duration) (io.ReadCloser, error) {
+	return GetUrlFdHeader(url, nil, dialFunc, connTimeout, GetTimeout)
+}
+
+// 非 2xx 的回應
+type StatusError struct {
+	Code int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return "http status " + e.Status
+}
+
+// 額外的 header, 例如 Authorization
+// maxTime: 整次請求的期限 (含讀取 body), 0: 不限制; 需要重試/續傳時用 Download
+func GetUrlFdHeader(url string, header http.Header, dialFunc DialFunc, connTimeout time.Duration, maxTime time.Duration) (io.ReadCloser, error) {
+	var netTransport = &http.Transport{
+		Dial: dialFunc.ForURL(url),
+		TLSHandshakeTimeout: connTimeout,
+	}
+
+	var netClient = &http.Client{
+		Timeout: maxTime,
+		Transport: netTransport,
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, RedactError(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Connection", "close")
+	req.Header.Set("User-Agent", UA)
+	req.Close = true
+	res, err := netClient.Do(req)
+	if err != nil {
+		return nil, RedactError(err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, &StatusError{res.StatusCode, res.Status}
+	}
+	return res.Body, nil
+}
+
+// 氣象署(原氣象局) open data 授權碼 (CWA-... / CWB-...)
+// 網址內有 %v 時代入 query (例: ?Authorization=%v), 沒有時改放在 Authorization header
+func AuthRequest(urlTmpl string, token string) (string, http.Header) {
+	if strings.Contains(urlTmpl, "%v") {
+		return fmt.Sprintf(urlTmpl, token), nil
+	}
+	header := make(http.Header)
+	if token != "" {
+		header.Set("Authorization", token)
+	}
+	return urlTmpl, header
+}
+
+// ==== proxy ====
+
+/*
+* SOCKS5 client (RFC 1928), 只支援 CONNECT
+* proxy 位址可帶帳密 (RFC 1929): user:pass@127.0.0.1:5005
+* 目標為 IPv4/IPv6 時直接送位址, 其他送網域名稱由 proxy 解析
+* 握手期間有 deadline, 失敗時一律關閉連線
+*/
+
+const (
+	socksVersion = 0x05
+	socksAuthNone = 0x00
+	socksAuthPassword = 0x02
+	socksAuthNoAcceptable = 0xff
+	socksAuthVersion = 0x01 // RFC 1929 子協商版本
+	socksCmdConnect = 0x01
+	socksAtypIPv4 = 0x01
+	socksAtypDomain = 0x03
+	socksAtypIPv6 = 0x04
+)
+
+var socksReplies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// user:pass@host:port >> host:port, user, pass
+func SplitSocksAddr(socksAddr string) (string, string, string) {
+	i := strings.LastIndex(socksAddr, "@")
+	if i < 0 {
+		return socksAddr, "", ""
+	}
+	user, pass := socksAddr[:i], ""
+	if j := strings.Index(user, ":"); j >= 0 {
+		user, pass = user[:j], user[j+1:]
+	}
+	return socksAddr[i+1:], user, pass
+}
+
+func MakeConnection(targetAddr string, socksAddr string, timeout time.Duration) (net.Conn, error) {
+	proxyAddr, user, pass := SplitSocksAddr(socksAddr)
+	return socksDial(targetAddr, proxyAddr, user, pass, timeout)
+}
+
+func socksDial(targetAddr string, proxyAddr string, user string, pass string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", proxyAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	err = socksHandshake(conn, targetAddr, user, pass)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks5 %v via %v: %v", targetAddr, proxyAddr, err)
+	}
+	// 之後由 http client 自己的 timeout 控制
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func socksHandshake(conn net.Conn, targetAddr string, user string, pass string) error {
+	host, portStr, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return fmt.Errorf("bad port %q", portStr)
+	}
+
+	// 方法協商
+	methods := []byte{socksAuthNone}
+	if user != "" {
+		methods = []byte{socksAuthNone, socksAuthPassword}
+	}
+	req := append([]byte{socksVersion, byte(len(methods))}, methods...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var b [2]byte
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		return err
+	}
+	if b[0] != socksVersion {
+		return fmt.Errorf("bad version %v", b[0])
+	}
+	switch b[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if user == "" {
+			return errors.New("proxy requires username/password")
+		}
+		if err := socksAuth(conn, user, pass); err != nil {
+			return err
+		}
+	case socksAuthNoAcceptable:
+		return errors.New("no acceptable authentication method")
+	default:
+		return fmt.Errorf("unsupported authentication method %v", b[1])
+	}
+
+	// CONNECT
+	req = []byte{socksVersion, socksCmdConnect, 0x00}
+	ip := net.ParseIP(host)
+	switch {
+	case ip != nil && ip.To4() != nil:
+		req = append(req, socksAtypIPv4)
+		req = append(req, ip.To4()...)
+	case ip != nil:
+		req = append(req, socksAtypIPv6)
+		req = append(req, ip.To16()...)
+	default:
+		if len(host) < 1 || len(host) > 255 {
+			return fmt.Errorf("bad host name length %v", len(host))
+		}
+		req = append(req, socksAtypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// VER REP RSV ATYP BND.ADDR BND.PORT, 依 ATYP 讀完整個回應
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != socksVersion {
+		return fmt.Errorf("bad version %v", hdr[0])
+	}
+	if hdr[1] != 0x00 {
+		if msg, ok := socksReplies[hdr[1]]; ok {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("reply %v", hdr[1])
+	}
+	var n int
+	switch hdr[3] {
+	case socksAtypIPv4:
+		n = net.IPv4len
+	case socksAtypIPv6:
+		n = net.IPv6len
+	case socksAtypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return err
+		}
+		n = int(l[0])
+	default:
+		return fmt.Errorf("bad address type %v", hdr[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, n+2))
+	return err
+}
+
+// RFC 1929
+func socksAuth(conn net.Conn, user string, pass string) error {
+	if len(user) > 255 || len(pass) > 255 {
+		return errors.New("username or password too long")
+	}
+	req := []byte{socksAuthVersion, byte(len(user))}
+	req = append(req, user...)
+	req = append(req, byte(len(pass)))
+	req = append(req, pass...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var b [2]byte
+	if _, err := io.ReadFull(conn, b[:]); err != nil {
+		return err
+	}
+	if b[0] != socksAuthVersion {
+		return fmt.Errorf("bad authentication version %v", b[0])
+	}
+	if b[1] != 0x00 {
+		return errors.New("authentication failed")
+	}
+	return nil
+}
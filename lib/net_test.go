@@ -0,0 +1,221 @@
+package lib
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 整次請求的期限由呼叫端決定, 包含讀取 body
+func TestGetUrlFdHeaderMaxTime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "CWA-test" {
+			http.Error(w, "no token", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("<xml>"))
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("</xml>"))
+	}))
+	defer srv.Close()
+
+	header := http.Header{"Authorization": {"CWA-test"}}
+	cases := []struct {
+		name string
+		maxTime time.Duration
+		wantErr bool
+	}{
+		{"deadline", 100 * time.Millisecond, true},
+		{"enough", 5 * time.Second, false},
+		{"no limit", 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fd, err := GetUrlFdHeader(srv.URL, header, nil, time.Second, tc.maxTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fd.Close()
+			body, err := ioutil.ReadAll(fd)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("read %q, err = %v", body, err)
+			}
+			if !tc.wantErr && string(body) != "<xml></xml>" {
+				t.Errorf("body %q", body)
+			}
+		})
+	}
+
+	_, err := GetUrlFdHeader(srv.URL, nil, nil, time.Second, time.Second)
+	if se, ok := err.(*StatusError); !ok || se.Code != http.StatusUnauthorized {
+		t.Errorf("err = %v, want 401", err)
+	}
+}
+
+// ==== SOCKS5 ====
+
+// 最小的 SOCKS5 server, 依設定回應, 記下收到的內容
+type socksServer struct {
+	method byte // 選擇的認證方式
+	authReply []byte // RFC 1929 回應, nil: 0x01 0x00
+	rep byte
+	bindAtyp byte
+
+	methods []byte
+	user string
+	pass string
+	target string
+}
+
+func (s *socksServer) serve(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.handle(conn)
+	}()
+	return ln.Addr().String()
+}
+
+func (s *socksServer) handle(conn net.Conn) {
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil
+		}
+		return b
+	}
+	str := func() string {
+		l := read(1)
+		if l == nil {
+			return ""
+		}
+		return string(read(int(l[0])))
+	}
+
+	h := read(2)
+	if h == nil {
+		return
+	}
+	s.methods = read(int(h[1]))
+	conn.Write([]byte{socksVersion, s.method})
+	if s.method == socksAuthPassword {
+		if read(1) == nil {
+			return
+		}
+		s.user, s.pass = str(), str()
+		reply := s.authReply
+		if reply == nil {
+			reply = []byte{socksAuthVersion, 0x00}
+		}
+		conn.Write(reply)
+		if reply[0] != socksAuthVersion || reply[1] != 0x00 {
+			return
+		}
+	}
+	if s.method != socksAuthNone && s.method != socksAuthPassword {
+		return
+	}
+
+	req := read(4)
+	if req == nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case socksAtypIPv4:
+		host = net.IP(read(4)).String()
+	case socksAtypIPv6:
+		host = net.IP(read(16)).String()
+	case socksAtypDomain:
+		host = str()
+	}
+	p := read(2)
+	if p == nil {
+		return
+	}
+	s.target = net.JoinHostPort(host, strconv.Itoa(int(p[0]) << 8 | int(p[1])))
+
+	reply := []byte{socksVersion, s.rep, 0x00, s.bindAtyp}
+	switch s.bindAtyp {
+	case socksAtypIPv4:
+		reply = append(reply, 10, 0, 0, 1)
+	case socksAtypIPv6:
+		reply = append(reply, net.ParseIP("2001:db8::1")...)
+	case socksAtypDomain:
+		reply = append(reply, 9)
+		reply = append(reply, "proxy.lan"...)
+	}
+	reply = append(reply, 0x1f, 0x90)
+	// 回應後馬上送資料, 握手不能多讀
+	conn.Write(append(reply, "hello"...))
+}
+
+func TestSocksDial(t *testing.T) {
+	cases := []struct {
+		name string
+		srv socksServer
+		target string
+		auth string
+		want string // 空白: 成功
+	}{
+		{"no auth, domain, bind IPv4", socksServer{method: socksAuthNone, bindAtyp: socksAtypIPv4}, "example.com:80", "", ""},
+		{"password, IPv4, bind IPv6", socksServer{method: socksAuthPassword, bindAtyp: socksAtypIPv6}, "10.1.2.3:443", "u:p@", ""},
+		{"IPv6, bind domain", socksServer{method: socksAuthNone, bindAtyp: socksAtypDomain}, "[2001:db8::2]:8080", "", ""},
+		{"auth failed", socksServer{method: socksAuthPassword, authReply: []byte{socksAuthVersion, 0x01}}, "example.com:80", "u:bad@", "authentication failed"},
+		{"auth version", socksServer{method: socksAuthPassword, authReply: []byte{socksVersion, 0x00}}, "example.com:80", "u:p@", "bad authentication version 5"},
+		{"password required", socksServer{method: socksAuthPassword}, "example.com:80", "", "requires username"},
+		{"no acceptable method", socksServer{method: socksAuthNoAcceptable}, "example.com:80", "", "no acceptable"},
+		{"refused", socksServer{method: socksAuthNone, rep: 0x05, bindAtyp: socksAtypIPv4}, "example.com:80", "", "connection refused"},
+		{"bad bind type", socksServer{method: socksAuthNone, bindAtyp: 0x09}, "example.com:80", "", "bad address type 9"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := tc.srv
+			addr := s.serve(t)
+			conn, err := MakeConnection(tc.target, tc.auth + addr, time.Second)
+			if tc.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.want) {
+					t.Errorf("err = %v, want %q", err, tc.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			b := make([]byte, 5)
+			if _, err := io.ReadFull(conn, b); err != nil || string(b) != "hello" {
+				t.Errorf("tunnel read %q, err %v", b, err)
+			}
+			if s.target != tc.target {
+				t.Errorf("target %q, want %q", s.target, tc.target)
+			}
+			if tc.auth != "" {
+				if s.user != "u" || s.pass != "p" || len(s.methods) != 2 {
+					t.Errorf("user %q pass %q methods %v", s.user, s.pass, s.methods)
+				}
+			} else if len(s.methods) != 1 || s.methods[0] != socksAuthNone {
+				t.Errorf("methods %v", s.methods)
+			}
+		})
+	}
+
+	if _, err := MakeConnection("example.com:0", "127.0.0.1:1", time.Second); err == nil {
+		t.Error("no error for bad port")
+	}
+}
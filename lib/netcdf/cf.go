@@ -0,0 +1,175 @@
+package netcdf
+
+/*
+* VectorGrid >> CF-1.6 NetCDF
+* dimensions: time, lat, lon
+* lat 由南往北遞增 (La2 >> La1), lon 由西往東遞增 (Lo1 >> Lo2)
+* 資料為 (time, lat, lon) row-major, 每個時間的排列與 grid.json 的 d 完全相同
+*/
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+type VarMeta struct {
+	Name string // NetCDF變數名
+	StandardName string
+	LongName string
+	Units string // 空白時用原始資料的measures
+	Scale float32 // scale_factor, 0: 沒有; 存的值與 grid.json 相同, 乘上後為 Units
+}
+
+// grid.json 的 key >> NetCDF 變數
+// 名稱沿用氣象局OCM NetCDF的命名 (u, v, temp, salt, zeta)
+// 單位須為 UDUNITS 可解析的: F-A0020-001 浪高為公分, 週期為 0.01 秒
+var CFVars = map[string]VarMeta{
+	"X": {"u", "eastward_sea_water_velocity", "橫向流速", "m s-1", 0},
+	"Y": {"v", "northward_sea_water_velocity", "直向流速", "m s-1", 0},
+	"海表溫度": {"temp", "sea_surface_temperature", "海表溫度", "degree_Celsius", 0},
+	"海表鹽度": {"salt", "sea_surface_salinity", "海表鹽度", "1e-3", 0},
+	"海高": {"zeta", "sea_surface_height_above_mean_sea_level", "海高", "m", 0},
+	"浪高": {"hs", "sea_surface_wave_significant_height", "浪高", "cm", 0},
+	"週期": {"period", "sea_surface_wave_period_at_variance_spectral_density_maximum", "週期", "s", 0.01},
+	"浪向": {"dir", "sea_surface_wave_from_direction", "浪向", "degree", 0},
+}
+
+const TimeUnits = "hours since 1970-01-01 00:00:00"
+
+var nameRx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 未列在CFVars內的key, 能當名稱就直接用
+func metaFor(key string, n int) VarMeta {
+	if m, ok := CFVars[key]; ok {
+		return m
+	}
+	name := key
+	if !nameRx.MatchString(name) {
+		name = fmt.Sprintf("var%d", n)
+	}
+	return VarMeta{Name: name, LongName: key}
+}
+
+// 經緯度取到小數點後6位, 避免float累加誤差
+func axis(v0 float32, d float32, n int) []float32 {
+	out := make([]float32, n)
+	for i := range out {
+		v := float64(v0) + float64(d) * float64(i)
+		out[i] = float32(math.Round(v * 1e6) / 1e6)
+	}
+	return out
+}
+
+func sameShape(a *lib.VectorGrid, b *lib.VectorGrid) bool {
+	return a.Nx == b.Nx && a.Ny == b.Ny &&
+		a.Lo1 == b.Lo1 && a.Lo2 == b.Lo2 &&
+		a.La1 == b.La1 && a.La2 == b.La2
+}
+
+// 多個時間的格點合成一個檔, 所有grid需同範圍同格數
+func FromGrids(grids []*lib.VectorGrid, times []time.Time) (*File, error) {
+	if len(grids) == 0 || len(grids) != len(times) {
+		return nil, errors.New("grids and times mismatch")
+	}
+	g0 := grids[0]
+	if g0.Nx < 1 || g0.Ny < 1 {
+		return nil, errors.New("empty grid")
+	}
+	for _, g := range grids[1:] {
+		if !sameShape(g0, g) {
+			return nil, errors.New("grids with different shape")
+		}
+	}
+
+	f := NewFile()
+	dimT := f.AddDim("time", len(grids))
+	dimY := f.AddDim("lat", g0.Ny)
+	dimX := f.AddDim("lon", g0.Nx)
+
+	f.Attrs = append(f.Attrs,
+		StringAttr("Conventions", "CF-1.6"),
+		StringAttr("title", g0.Desc),
+		StringAttr("source", "https://opendata.cwa.gov.tw/"),
+		StringAttr("history", time.Now().UTC().Format(time.RFC3339) + " created by oac-opendata-converters"),
+		StringAttr("comment", "lat increases from south to north; each time step is laid out exactly like the d arrays in *.grid.json"),
+	)
+
+	hours := make([]float64, len(times))
+	for i, t := range times {
+		hours[i] = float64(t.Unix()) / 3600
+	}
+	f.AddVar("time", Double, []int{dimT}, hours,
+		StringAttr("standard_name", "time"),
+		StringAttr("long_name", "time"),
+		StringAttr("units", TimeUnits),
+		StringAttr("calendar", "gregorian"),
+		StringAttr("axis", "T"),
+	)
+	f.AddVar("lat", Float, []int{dimY}, axis(g0.La2, g0.Dy(), g0.Ny),
+		StringAttr("standard_name", "latitude"),
+		StringAttr("long_name", "latitude"),
+		StringAttr("units", "degrees_north"),
+		StringAttr("axis", "Y"),
+	)
+	f.AddVar("lon", Float, []int{dimX}, axis(g0.Lo1, g0.Dx(), g0.Nx),
+		StringAttr("standard_name", "longitude"),
+		StringAttr("long_name", "longitude"),
+		StringAttr("units", "degrees_east"),
+		StringAttr("axis", "X"),
+	)
+
+	// 所有時間的key聯集
+	keySet := make(map[string]bool)
+	for _, g := range grids {
+		for k := range g.Data {
+			keySet[k] = true
+		}
+	}
+	keys := make([]string, 0, len(keySet))
+	for k := range keySet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sz := g0.Nx * g0.Ny
+	for n, k := range keys {
+		meta := metaFor(k, n)
+		data := make([]float32, sz * len(grids))
+		units := meta.Units
+		for i, g := range grids {
+			arr := g.Data[k]
+			if units == "" {
+				units = g.Units[k]
+			}
+			out := data[i * sz : (i + 1) * sz]
+			for j := range out {
+				if j >= len(arr) || arr[j].IsNaN() {
+					out[j] = FillFloat
+					continue
+				}
+				out[j] = float32(arr[j])
+			}
+		}
+
+		attrs := make([]Attr, 0, 6)
+		if meta.StandardName != "" {
+			attrs = append(attrs, StringAttr("standard_name", meta.StandardName))
+		}
+		attrs = append(attrs, StringAttr("long_name", meta.LongName))
+		if units != "" {
+			attrs = append(attrs, StringAttr("units", units))
+		}
+		if meta.Scale != 0 {
+			attrs = append(attrs, FloatAttr("scale_factor", meta.Scale))
+		}
+		attrs = append(attrs, FloatAttr("_FillValue", FillFloat))
+		f.AddVar(meta.Name, Float, []int{dimT, dimY, dimX}, data, attrs...)
+	}
+	return f, nil
+}
@@ -0,0 +1,152 @@
+package netcdf
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+func testGrid(hs []float32, period []float32, u []float32) *lib.VectorGrid {
+	g := lib.NewVectorGrid()
+	g.Lo1, g.Lo2 = 118, 120
+	g.La1, g.La2 = 23, 22
+	g.Nx, g.Ny = 3, 2
+	g.Desc = "test"
+	set := func(key string, vals []float32, units string) {
+		arr := make([]lib.JsonFloat, len(vals))
+		for i, v := range vals {
+			arr[i] = lib.JsonFloat(v)
+		}
+		g.Data[key] = arr
+		g.Units[key] = units
+	}
+	set("浪高", hs, "公分")
+	set("週期", period, "0.01秒")
+	set("X", u, "公尺/秒")
+	return g
+}
+
+// FromGrids >> Write >> Decode >> ToGrids
+func TestCFRoundTrip(t *testing.T) {
+	nan := float32(math.NaN())
+	grids := []*lib.VectorGrid{
+		testGrid([]float32{10, 20, nan, 40, 50, 60}, []float32{205, 310, 999, nan, 812, 100}, []float32{0.5, -0.25, 0, 1, nan, 2}),
+		testGrid([]float32{11, 21, 31, nan, 51, 61}, []float32{206, nan, 998, 400, 813, 101}, []float32{nan, -0.5, 0.125, 1, 1.5, 2}),
+	}
+	t0 := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	times := []time.Time{t0, t0.Add(3 * time.Hour)}
+
+	f, err := FromGrids(grids, times)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantDims := []Dim{{"time", 2}, {"lat", 2}, {"lon", 3}}
+	if len(rf.Dims) != len(wantDims) {
+		t.Fatalf("dims = %v, want %v", rf.Dims, wantDims)
+	}
+	for i, d := range wantDims {
+		if rf.Dims[i] != d {
+			t.Errorf("dim %d = %v, want %v", i, rf.Dims[i], d)
+		}
+	}
+	if a, ok := rf.Attr("Conventions"); !ok || a.String() != "CF-1.6" {
+		t.Errorf("Conventions = %v", a)
+	}
+
+	attrs := []struct {
+		name string
+		standard string
+		units string
+		scale float64
+	}{
+		{"hs", "sea_surface_wave_significant_height", "cm", 0},
+		{"period", "sea_surface_wave_period_at_variance_spectral_density_maximum", "s", 0.01},
+		{"u", "eastward_sea_water_velocity", "m s-1", 0},
+	}
+	for _, c := range attrs {
+		v, ok := rf.Var(c.name)
+		if !ok {
+			t.Errorf("%v: missing", c.name)
+			continue
+		}
+		if len(v.Dims) != 3 || v.Type != Float {
+			t.Errorf("%v: dims %v type %v", c.name, v.Dims, v.Type)
+		}
+		if a, ok := v.Attr("standard_name"); !ok || a.String() != c.standard {
+			t.Errorf("%v: standard_name = %v, want %v", c.name, a, c.standard)
+		}
+		if a, ok := v.Attr("units"); !ok || a.String() != c.units {
+			t.Errorf("%v: units = %v, want %v", c.name, a, c.units)
+		}
+		a, ok := v.Attr("scale_factor")
+		switch {
+		case c.scale == 0 && ok:
+			t.Errorf("%v: unexpected scale_factor %v", c.name, a.Float64s())
+		case c.scale != 0 && (!ok || math.Abs(a.Float64s()[0] - c.scale) > 1e-9):
+			t.Errorf("%v: scale_factor = %v, want %v", c.name, a, c.scale)
+		}
+		a, ok = v.Attr("_FillValue")
+		if !ok || a.Type != Float || a.Float64s()[0] != float64(FillFloat) {
+			t.Errorf("%v: _FillValue = %v", c.name, a)
+		}
+	}
+
+	// 存的值與 grid.json 相同, NaN 為 _FillValue
+	v, _ := rf.Var("period")
+	raw := v.Data.([]float32)
+	for i, g := range grids {
+		for j, x := range g.Data["週期"] {
+			got := raw[i * 6 + j]
+			if x.IsNaN() {
+				if got != FillFloat {
+					t.Errorf("period[%d][%d] = %v, want _FillValue", i, j, got)
+				}
+				continue
+			}
+			if got != float32(x) {
+				t.Errorf("period[%d][%d] = %v, want %v", i, j, got, x)
+			}
+		}
+	}
+
+	back, btimes, err := ToGrids(rf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back) != len(grids) {
+		t.Fatalf("%d grids, want %d", len(back), len(grids))
+	}
+	for i, g := range back {
+		if !btimes[i].Equal(times[i]) {
+			t.Errorf("time %d = %v, want %v", i, btimes[i], times[i])
+		}
+		w := grids[i]
+		if g.Nx != w.Nx || g.Ny != w.Ny || g.Lo1 != w.Lo1 || g.Lo2 != w.Lo2 || g.La1 != w.La1 || g.La2 != w.La2 {
+			t.Errorf("grid %d: %v,%v %v-%v %v-%v", i, g.Nx, g.Ny, g.Lo1, g.Lo2, g.La2, g.La1)
+		}
+		for _, key := range []string{"浪高", "週期", "X"} {
+			got, want := g.Data[key], w.Data[key]
+			if len(got) != len(want) {
+				t.Errorf("grid %d %v: %d values, want %d", i, key, len(got), len(want))
+				continue
+			}
+			for j := range want {
+				if want[j].IsNaN() != got[j].IsNaN() || (!want[j].IsNaN() && math.Abs(float64(got[j] - want[j])) > 1e-3) {
+					t.Errorf("grid %d %v[%d] = %v, want %v", i, key, j, got[j], want[j])
+				}
+			}
+		}
+	}
+}
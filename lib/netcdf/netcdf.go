@@ -0,0 +1,280 @@
+package netcdf
+
+/*
+* NetCDF classic format (CDF-1, CDF-2 64-bit offset), 不依賴cgo/libnetcdf
+* 格式說明: https://docs.unidata.ucar.edu/netcdf-c/current/file_format_specifications.html
+* 全部為 big-endian, header內的各區塊補齊到4 bytes
+*/
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Type int32
+
+const (
+	Byte Type = 1
+	Char Type = 2
+	Short Type = 3
+	Int Type = 4
+	Float Type = 5
+	Double Type = 6
+)
+
+const (
+	tagDimension = 0x0A
+	tagVariable = 0x0B
+	tagAttribute = 0x0C
+)
+
+// NC_FILL_FLOAT / NC_FILL_DOUBLE
+const (
+	FillFloat float32 = 9.9692099683868690e+36
+	FillDouble float64 = 9.9692099683868690e+36
+)
+
+var (
+	ErrMagic = errors.New("not a NetCDF classic file")
+	ErrType = errors.New("unknown nc_type")
+)
+
+func (t Type) Size() int {
+	switch t {
+	case Byte, Char:
+		return 1
+	case Short:
+		return 2
+	case Int, Float:
+		return 4
+	case Double:
+		return 8
+	}
+	return 0
+}
+
+func (t Type) String() string {
+	switch t {
+	case Byte:
+		return "byte"
+	case Char:
+		return "char"
+	case Short:
+		return "short"
+	case Int:
+		return "int"
+	case Float:
+		return "float"
+	case Double:
+		return "double"
+	}
+	return fmt.Sprintf("type(%d)", int32(t))
+}
+
+// Len == 0 為 record(unlimited) dimension
+type Dim struct {
+	Name string
+	Len int
+}
+
+// Value 依 Type:
+// Byte: []int8, Char: string, Short: []int16, Int: []int32, Float: []float32, Double: []float64
+type Attr struct {
+	Name string
+	Type Type
+	Value interface{}
+}
+
+func StringAttr(name string, v string) Attr {
+	return Attr{name, Char, v}
+}
+
+func ShortAttr(name string, v ...int16) Attr {
+	return Attr{name, Short, v}
+}
+
+func IntAttr(name string, v ...int32) Attr {
+	return Attr{name, Int, v}
+}
+
+func FloatAttr(name string, v ...float32) Attr {
+	return Attr{name, Float, v}
+}
+
+func DoubleAttr(name string, v ...float64) Attr {
+	return Attr{name, Double, v}
+}
+
+// 依 Type 轉成 float64
+func (a *Attr) Float64s() []float64 {
+	switch v := a.Value.(type) {
+	case []int8:
+		out := make([]float64, len(v))
+		for i, x := range v {
+			out[i] = float64(x)
+		}
+		return out
+	case []int16:
+		out := make([]float64, len(v))
+		for i, x := range v {
+			out[i] = float64(x)
+		}
+		return out
+	case []int32:
+		out := make([]float64, len(v))
+		for i, x := range v {
+			out[i] = float64(x)
+		}
+		return out
+	case []float32:
+		out := make([]float64, len(v))
+		for i, x := range v {
+			out[i] = float64(x)
+		}
+		return out
+	case []float64:
+		return v
+	}
+	return nil
+}
+
+func (a *Attr) String() string {
+	if s, ok := a.Value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(a.Value)
+}
+
+func (a *Attr) count() int {
+	switch v := a.Value.(type) {
+	case string:
+		return len(v)
+	case []int8:
+		return len(v)
+	case []int16:
+		return len(v)
+	case []int32:
+		return len(v)
+	case []float32:
+		return len(v)
+	case []float64:
+		return len(v)
+	}
+	return 0
+}
+
+// Data 型態同 Attr.Value (Char 為 []byte), 依 Dims 的順序 row-major 排列
+type Var struct {
+	Name string
+	Dims []int // dim id
+	Attrs []Attr
+	Type Type
+	Data interface{}
+
+	// for reader
+	vsize int64
+	begin int64
+}
+
+func (v *Var) Attr(name string) (*Attr, bool) {
+	for i := range v.Attrs {
+		if v.Attrs[i].Name == name {
+			return &v.Attrs[i], true
+		}
+	}
+	return nil, false
+}
+
+type File struct {
+	Version int // 1: classic, 2: 64-bit offset
+	NumRecs int
+	Dims []Dim
+	Attrs []Attr
+	Vars []*Var
+}
+
+func NewFile() *File {
+	return &File{
+		Version: 1,
+		Dims: make([]Dim, 0, 4),
+		Attrs: make([]Attr, 0, 8),
+		Vars: make([]*Var, 0, 8),
+	}
+}
+
+func (f *File) AddDim(name string, n int) int {
+	f.Dims = append(f.Dims, Dim{name, n})
+	return len(f.Dims) - 1
+}
+
+func (f *File) DimID(name string) int {
+	for i, d := range f.Dims {
+		if d.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *File) AddVar(name string, typ Type, dims []int, data interface{}, attrs ...Attr) *Var {
+	v := &Var{
+		Name: name,
+		Dims: dims,
+		Attrs: attrs,
+		Type: typ,
+		Data: data,
+	}
+	f.Vars = append(f.Vars, v)
+	return v
+}
+
+func (f *File) Var(name string) (*Var, bool) {
+	for _, v := range f.Vars {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (f *File) Attr(name string) (*Attr, bool) {
+	for i := range f.Attrs {
+		if f.Attrs[i].Name == name {
+			return &f.Attrs[i], true
+		}
+	}
+	return nil, false
+}
+
+// 是否為 record variable (第一維為unlimited)
+func (f *File) isRecord(v *Var) bool {
+	return len(v.Dims) > 0 && f.Dims[v.Dims[0]].Len == 0
+}
+
+// 每個record(或整個非record變數)的元素數
+func (f *File) recElems(v *Var) int {
+	n := 1
+	for i, id := range v.Dims {
+		if i == 0 && f.isRecord(v) {
+			continue
+		}
+		n *= f.Dims[id].Len
+	}
+	return n
+}
+
+// 變數的shape, record dimension 以 NumRecs 代入
+func (f *File) Shape(v *Var) []int {
+	shape := make([]int, len(v.Dims))
+	for i, id := range v.Dims {
+		shape[i] = f.Dims[id].Len
+		if shape[i] == 0 {
+			shape[i] = f.NumRecs
+		}
+	}
+	return shape
+}
+
+func pad4(n int64) int64 {
+	return (n + 3) &^ 3
+}
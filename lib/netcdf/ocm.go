@@ -0,0 +1,249 @@
+package netcdf
+
+/*
+* 中央氣象局 OCM 海流模式 NetCDF >> VectorGrid
+* 變數為 (time, [depth,] lat, lon), 只取第一層(表層)
+* 每個時間輸出一個grid, key與 M-B0071 的輸出相同, 方便共用後續流程
+* FromGrids 寫出的檔案 (含 hs/period/dir) 也可讀回, 數值換回 grid.json 的單位
+*/
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+// OCM變數名 >> grid key
+// OPeNDAP上的檔案用大寫代碼, 模式原始輸出則是 u/v/temp/salt/zeta
+var OCMVars = map[string]string{
+	"UCURR": "X",
+	"u": "X",
+	"VCURR": "Y",
+	"v": "Y",
+	"SST": "海表溫度",
+	"temp": "海表溫度",
+	"SALT": "海表鹽度",
+	"salt": "海表鹽度",
+	"WL": "海高",
+	"zeta": "海高",
+}
+
+// OCM 部分檔案沒有宣告 _FillValue, 直接用 -999 表示無資料
+const ocmMissing = -999
+
+var (
+	latNames = []string{"lat", "latitude", "y"}
+	lonNames = []string{"lon", "longitude", "x"}
+)
+
+func (f *File) findVar(names []string) (*Var, bool) {
+	for _, name := range names {
+		if v, ok := f.Var(name); ok && len(v.Dims) == 1 {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// "hours since 1800-01-01 00:00:00" >> 單位, 起點
+func ParseTimeUnits(units string) (time.Duration, time.Time, error) {
+	parts := strings.SplitN(strings.TrimSpace(units), " since ", 2)
+	if len(parts) != 2 {
+		return 0, time.Time{}, fmt.Errorf("bad time units: %v", units)
+	}
+
+	var step time.Duration
+	switch strings.ToLower(parts[0]) {
+	case "days", "day", "d":
+		step = 24 * time.Hour
+	case "hours", "hour", "hr", "h":
+		step = time.Hour
+	case "minutes", "minute", "min":
+		step = time.Minute
+	case "seconds", "second", "sec", "s":
+		step = time.Second
+	default:
+		return 0, time.Time{}, fmt.Errorf("bad time units: %v", units)
+	}
+
+	ref := strings.TrimSpace(parts[1])
+	ref = strings.TrimSuffix(ref, " UTC")
+	ref = strings.TrimSuffix(ref, "Z")
+	layouts := []string{
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+		"2006-1-2 15:04:05",
+		"2006-1-2",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, ref); err == nil {
+			return step, t, nil
+		}
+	}
+	return 0, time.Time{}, fmt.Errorf("bad time origin: %v", units)
+}
+
+func (f *File) times() ([]time.Time, error) {
+	v, ok := f.Var("time")
+	if !ok {
+		return nil, errors.New("no time variable")
+	}
+	a, ok := v.Attr("units")
+	if !ok {
+		return nil, errors.New("time without units")
+	}
+	step, t0, err := ParseTimeUnits(a.String())
+	if err != nil {
+		return nil, err
+	}
+	vals := v.Unpack()
+	out := make([]time.Time, len(vals))
+	for i, x := range vals {
+		out[i] = t0.Add(time.Duration(x * float64(step)))
+	}
+	return out, nil
+}
+
+// NetCDF變數名 >> grid key, CFVars 有 Scale 時數值需除回
+func gridKey(name string) (string, float32, bool) {
+	if key, ok := OCMVars[name]; ok {
+		return key, 0, true
+	}
+	for key, meta := range CFVars {
+		if meta.Name == name {
+			return key, meta.Scale, true
+		}
+	}
+	return "", 0, false
+}
+
+// 每個時間一個grid, 回傳依時間排序
+func ToGrids(f *File) ([]*lib.VectorGrid, []time.Time, error) {
+	latVar, ok := f.findVar(latNames)
+	if !ok {
+		return nil, nil, errors.New("no lat variable")
+	}
+	lonVar, ok := f.findVar(lonNames)
+	if !ok {
+		return nil, nil, errors.New("no lon variable")
+	}
+	lat := latVar.Unpack()
+	lon := lonVar.Unpack()
+	ny, nx := len(lat), len(lon)
+	if nx < 1 || ny < 1 {
+		return nil, nil, errors.New("empty lat/lon")
+	}
+	latDim := latVar.Dims[0]
+	lonDim := lonVar.Dims[0]
+
+	// VectorGrid 由南往北, 由西往東
+	flipY := ny > 1 && lat[0] > lat[ny-1]
+	flipX := nx > 1 && lon[0] > lon[nx-1]
+
+	times, err := f.times()
+	if err != nil {
+		return nil, nil, err
+	}
+	timeVar, _ := f.Var("time")
+	timeDim := timeVar.Dims[0]
+
+	desc := "OCM"
+	if a, ok := f.Attr("title"); ok {
+		desc = a.String()
+	}
+
+	grids := make([]*lib.VectorGrid, len(times))
+	for i := range grids {
+		g := lib.NewVectorGrid()
+		g.Nx = nx
+		g.Ny = ny
+		g.Lo1 = float32(math.Min(lon[0], lon[nx-1]))
+		g.Lo2 = float32(math.Max(lon[0], lon[nx-1]))
+		g.La1 = float32(math.Max(lat[0], lat[ny-1]))
+		g.La2 = float32(math.Min(lat[0], lat[ny-1]))
+		g.Time = times[i].Format(time.RFC3339)
+		g.Desc = desc
+		grids[i] = g
+	}
+
+	found := 0
+	for _, v := range f.Vars {
+		key, scale, ok := gridKey(v.Name)
+		if !ok {
+			continue
+		}
+		n := len(v.Dims)
+		if n < 3 || v.Dims[0] != timeDim || v.Dims[n-2] != latDim || v.Dims[n-1] != lonDim {
+			lib.Vln(3, "[ocm]skip variable, unexpected dimensions", v.Name, v.Dims)
+			continue
+		}
+		// time 與 lat 之間的維度(depth)都取第0層
+		shape := f.Shape(v)
+		perTime := 1
+		for _, s := range shape[1:] {
+			perTime *= s
+		}
+
+		vals := v.Unpack()
+		units := ""
+		if a, ok := v.Attr("units"); ok {
+			units = a.String()
+		}
+		for t, g := range grids {
+			base := t * perTime
+			if base + nx * ny > len(vals) {
+				break
+			}
+			arr := make([]lib.JsonFloat, nx * ny)
+			for r := 0; r < ny; r++ {
+				sr := r
+				if flipY {
+					sr = ny - 1 - r
+				}
+				for c := 0; c < nx; c++ {
+					sc := c
+					if flipX {
+						sc = nx - 1 - c
+					}
+					x := vals[base + sr * nx + sc]
+					if x == ocmMissing {
+						x = math.NaN()
+					}
+					if scale != 0 {
+						x /= float64(scale)
+					}
+					arr[r * nx + c] = lib.JsonFloat(x)
+				}
+			}
+			g.Data[key] = arr
+			g.CalcRange(key)
+			if units != "" && scale == 0 {
+				g.Units[key] = units
+			}
+		}
+		found++
+	}
+	if found == 0 {
+		return nil, nil, errors.New("no OCM variable found")
+	}
+
+	idx := make([]int, len(grids))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return times[idx[a]].Before(times[idx[b]]) })
+	outG := make([]*lib.VectorGrid, len(grids))
+	outT := make([]time.Time, len(grids))
+	for i, j := range idx {
+		outG[i] = grids[j]
+		outT[i] = times[j]
+	}
+	return outG, outT, nil
+}
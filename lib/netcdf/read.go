@@ -0,0 +1,391 @@
+package netcdf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+const streamingRecs = 0xFFFFFFFF
+
+type reader struct {
+	buf []byte
+	off int64
+	version int
+	err error
+}
+
+func (r *reader) need(n int64) bool {
+	if r.err != nil {
+		return false
+	}
+	if n < 0 || r.off + n > int64(len(r.buf)) {
+		r.err = errors.New("unexpected end of header")
+		return false
+	}
+	return true
+}
+
+func (r *reader) u32() uint32 {
+	if !r.need(4) {
+		return 0
+	}
+	v := be.Uint32(r.buf[r.off:])
+	r.off += 4
+	return v
+}
+
+func (r *reader) offset() int64 {
+	if r.version == 1 {
+		return int64(r.u32())
+	}
+	if !r.need(8) {
+		return 0
+	}
+	v := be.Uint64(r.buf[r.off:])
+	r.off += 8
+	return int64(v)
+}
+
+func (r *reader) bytes(n int64) []byte {
+	if !r.need(pad4(n)) {
+		return nil
+	}
+	b := r.buf[r.off : r.off + n]
+	r.off += pad4(n)
+	return b
+}
+
+func (r *reader) name() string {
+	n := r.u32()
+	return string(r.bytes(int64(n)))
+}
+
+// header 內的元素數: 每個元素至少 minSize bytes, 超過剩下的長度就是壞檔
+// 先檢查才配置, 避免 0x7fffffff 之類的數字讓 make 用光記憶體
+func (r *reader) count(n uint32, minSize int64) int {
+	if r.err != nil {
+		return 0
+	}
+	if int64(n) > (int64(len(r.buf)) - r.off) / minSize {
+		r.err = fmt.Errorf("count %v exceeds header size", n)
+		return 0
+	}
+	return int(n)
+}
+
+// ABSENT 或 tag + nelems
+func (r *reader) list(tag uint32, minSize int64) int {
+	t := r.u32()
+	n := r.u32()
+	if r.err != nil {
+		return 0
+	}
+	if t == 0 && n == 0 {
+		return 0
+	}
+	if t != tag {
+		r.err = fmt.Errorf("bad list tag %#x, want %#x", t, tag)
+		return 0
+	}
+	return r.count(n, minSize)
+}
+
+// 各 list 元素的最小長度 (名稱為空白, 沒有屬性)
+const (
+	minDimSize = 8 // name, dim_length
+	minAttrSize = 12 // name, nc_type, nelems
+	minVarSize = 28 // name, nelems, vatt_list, nc_type, vsize, begin
+)
+
+func decodeValues(typ Type, b []byte, n int) (interface{}, error) {
+	switch typ {
+	case Byte:
+		out := make([]int8, n)
+		for i := range out {
+			out[i] = int8(b[i])
+		}
+		return out, nil
+	case Char:
+		return string(b[:n]), nil
+	case Short:
+		out := make([]int16, n)
+		for i := range out {
+			out[i] = int16(be.Uint16(b[2*i:]))
+		}
+		return out, nil
+	case Int:
+		out := make([]int32, n)
+		for i := range out {
+			out[i] = int32(be.Uint32(b[4*i:]))
+		}
+		return out, nil
+	case Float:
+		out := make([]float32, n)
+		for i := range out {
+			out[i] = math.Float32frombits(be.Uint32(b[4*i:]))
+		}
+		return out, nil
+	case Double:
+		out := make([]float64, n)
+		for i := range out {
+			out[i] = math.Float64frombits(be.Uint64(b[8*i:]))
+		}
+		return out, nil
+	}
+	return nil, ErrType
+}
+
+func (r *reader) attrs() []Attr {
+	n := r.list(tagAttribute, minAttrSize)
+	out := make([]Attr, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		name := r.name()
+		typ := Type(r.u32())
+		cnt := int(r.u32())
+		if r.err == nil && typ.Size() == 0 {
+			r.err = ErrType
+		}
+		b := r.bytes(int64(cnt) * int64(typ.Size()))
+		if r.err != nil {
+			break
+		}
+		val, err := decodeValues(typ, b, cnt)
+		if err != nil {
+			r.err = err
+			break
+		}
+		out = append(out, Attr{name, typ, val})
+	}
+	return out
+}
+
+// 整個檔讀進記憶體後解析, 所有變數的資料都會載入 Var.Data
+func Decode(buf []byte) (*File, error) {
+	if len(buf) < 4 || buf[0] != 'C' || buf[1] != 'D' || buf[2] != 'F' {
+		return nil, ErrMagic
+	}
+	r := &reader{buf: buf, off: 4, version: int(buf[3])}
+	if r.version != 1 && r.version != 2 {
+		return nil, fmt.Errorf("unsupported NetCDF version %v", r.version)
+	}
+
+	f := &File{Version: r.version}
+	numRecs := r.u32()
+
+	nd := r.list(tagDimension, minDimSize)
+	f.Dims = make([]Dim, 0, nd)
+	for i := 0; i < nd && r.err == nil; i++ {
+		name := r.name()
+		f.Dims = append(f.Dims, Dim{name, int(r.u32())})
+	}
+
+	f.Attrs = r.attrs()
+
+	nv := r.list(tagVariable, minVarSize)
+	f.Vars = make([]*Var, 0, nv)
+	for i := 0; i < nv && r.err == nil; i++ {
+		v := &Var{}
+		v.Name = r.name()
+		n := r.count(r.u32(), 4)
+		v.Dims = make([]int, 0, n)
+		for j := 0; j < n && r.err == nil; j++ {
+			id := int(r.u32())
+			if id >= len(f.Dims) {
+				r.err = fmt.Errorf("variable %v: bad dimension id %v", v.Name, id)
+				break
+			}
+			v.Dims = append(v.Dims, id)
+		}
+		v.Attrs = r.attrs()
+		v.Type = Type(r.u32())
+		v.vsize = int64(r.u32())
+		v.begin = r.offset()
+		if r.err == nil && v.Type.Size() == 0 {
+			r.err = ErrType
+		}
+		f.Vars = append(f.Vars, v)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// record variables
+	recSize := int64(0)
+	recVars := 0
+	firstRec := int64(-1)
+	for _, v := range f.Vars {
+		if !f.isRecord(v) {
+			continue
+		}
+		recVars++
+		recSize += v.vsize
+		if firstRec < 0 || v.begin < firstRec {
+			firstRec = v.begin
+		}
+	}
+	// 只有一個record variable時, 每個record不補齊
+	if recVars == 1 {
+		for _, v := range f.Vars {
+			if f.isRecord(v) {
+				recSize, _ = f.recBytes(v, int64(len(buf))) // 太大時 loadVar 會失敗
+			}
+		}
+	}
+	if numRecs == streamingRecs {
+		numRecs = 0
+		if recSize > 0 && firstRec >= 0 {
+			numRecs = uint32((int64(len(buf)) - firstRec) / recSize)
+		}
+	}
+	f.NumRecs = int(numRecs)
+
+	for _, v := range f.Vars {
+		err := f.loadVar(buf, v, recSize)
+		if err != nil {
+			return nil, fmt.Errorf("variable %v: %v", v.Name, err)
+		}
+	}
+	return f, nil
+}
+
+// 每個record(或整個非record變數)的 bytes, 超過 limit 時回傳錯誤 (dimension 相乘可能溢位)
+func (f *File) recBytes(v *Var, limit int64) (int64, error) {
+	sz := int64(v.Type.Size())
+	for i, id := range v.Dims {
+		if i == 0 && f.isRecord(v) {
+			continue
+		}
+		n := int64(f.Dims[id].Len)
+		if n == 0 {
+			return 0, nil
+		}
+		if sz > limit / n {
+			return 0, errors.New("data out of file")
+		}
+		sz *= n
+	}
+	return sz, nil
+}
+
+// 先確認範圍在檔案內才配置
+func (f *File) loadVar(buf []byte, v *Var, recSize int64) error {
+	size := int64(len(buf))
+	sz, err := f.recBytes(v, size)
+	if err != nil {
+		return err
+	}
+	n := int(sz) / v.Type.Size()
+	if !f.isRecord(v) {
+		if v.begin < 0 || v.begin > size - sz {
+			return errors.New("data out of file")
+		}
+		val, err := decodeValues(v.Type, buf[v.begin:], n)
+		if err != nil {
+			return err
+		}
+		if v.Type == Char {
+			val = []byte(val.(string))
+		}
+		v.Data = val
+		return nil
+	}
+
+	// record: 逐筆取出再接起來, 最後一筆 begin+(numrecs-1)*recSize+sz 必須在檔案內
+	if f.NumRecs > 0 && sz > 0 {
+		if v.begin < 0 || v.begin > size - sz || recSize < sz {
+			return errors.New("record out of file")
+		}
+		if f.NumRecs > 1 && recSize > (size - sz - v.begin) / int64(f.NumRecs - 1) {
+			return errors.New("record out of file")
+		}
+	} else {
+		sz = 0
+	}
+	all := make([]byte, 0, sz * int64(f.NumRecs))
+	for i := 0; i < f.NumRecs && sz > 0; i++ {
+		off := v.begin + int64(i) * recSize
+		if off < 0 || off + sz > int64(len(buf)) {
+			return errors.New("record out of file")
+		}
+		all = append(all, buf[off : off + sz]...)
+	}
+	val, err := decodeValues(v.Type, all, n * f.NumRecs)
+	if err != nil {
+		return err
+	}
+	if v.Type == Char {
+		val = []byte(val.(string))
+	}
+	v.Data = val
+	return nil
+}
+
+func ReadFile(fp string) (*File, error) {
+	buf, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(buf)
+}
+
+// 依 CF 規則還原數值: 先比對 _FillValue/missing_value (packed值), 再套用 scale_factor/add_offset
+// 無效值為 NaN; 浮點數沒有 _FillValue 時用預設的 NC_FILL
+func (v *Var) Unpack() []float64 {
+	var raw []float64
+	switch d := v.Data.(type) {
+	case []byte:
+		raw = make([]float64, len(d))
+		for i, x := range d {
+			raw[i] = float64(x)
+		}
+	default:
+		a := Attr{Value: v.Data}
+		raw = a.Float64s()
+	}
+
+	missing := make([]float64, 0, 2)
+	if a, ok := v.Attr("_FillValue"); ok {
+		missing = append(missing, a.Float64s()...)
+	} else {
+		switch v.Type {
+		case Float:
+			missing = append(missing, float64(FillFloat))
+		case Double:
+			missing = append(missing, FillDouble)
+		}
+	}
+	if a, ok := v.Attr("missing_value"); ok {
+		missing = append(missing, a.Float64s()...)
+	}
+
+	scale, offset := 1.0, 0.0
+	if a, ok := v.Attr("scale_factor"); ok {
+		if s := a.Float64s(); len(s) > 0 {
+			scale = s[0]
+		}
+	}
+	if a, ok := v.Attr("add_offset"); ok {
+		if s := a.Float64s(); len(s) > 0 {
+			offset = s[0]
+		}
+	}
+
+	out := make([]float64, len(raw))
+	for i, x := range raw {
+		bad := math.IsNaN(x)
+		for _, m := range missing {
+			if x == m {
+				bad = true
+				break
+			}
+		}
+		if bad {
+			out[i] = math.NaN()
+			continue
+		}
+		out[i] = x * scale + offset
+	}
+	return out
+}
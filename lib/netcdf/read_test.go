@@ -0,0 +1,98 @@
+package netcdf
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type hdr []byte
+
+func (h hdr) u32(v ...uint32) hdr {
+	for _, x := range v {
+		h = binary.BigEndian.AppendUint32(h, x)
+	}
+	return h
+}
+
+func (h hdr) name(s string) hdr {
+	h = h.u32(uint32(len(s)))
+	h = append(h, s...)
+	for len(h) % 4 != 0 {
+		h = append(h, 0)
+	}
+	return h
+}
+
+// 一個 int 變數 v, dims 為 d0, d1, ... (長度 0 為 record dimension)
+// begin 為 0 時資料接在 header 後面, 之後補 data 個 int
+func testFile(numRecs uint32, dimLen []uint32, vsize uint32, begin uint32, data int) []byte {
+	h := hdr("CDF\x01").u32(numRecs)
+	h = h.u32(tagDimension, uint32(len(dimLen)))
+	for i, n := range dimLen {
+		h = h.name("d" + string(rune('0' + i))).u32(n)
+	}
+	h = h.u32(0, 0) // 沒有全域屬性
+	h = h.u32(tagVariable, 1).name("v").u32(uint32(len(dimLen)))
+	for i := range dimLen {
+		h = h.u32(uint32(i))
+	}
+	h = h.u32(0, 0, uint32(Int), vsize)
+	if begin == 0 {
+		begin = uint32(len(h) + 4)
+	}
+	h = h.u32(begin)
+	for i := 0; i < data; i++ {
+		h = h.u32(uint32(i + 1))
+	}
+	return h
+}
+
+func TestDecodeRecord(t *testing.T) {
+	f, err := Decode(testFile(2, []uint32{0, 2}, 8, 0, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, ok := f.Var("v")
+	if !ok || f.NumRecs != 2 {
+		t.Fatalf("var %v, numrecs %v", ok, f.NumRecs)
+	}
+	got, _ := v.Data.([]int32)
+	if len(got) != 4 || got[0] != 1 || got[3] != 4 {
+		t.Errorf("data %v", v.Data)
+	}
+}
+
+// 壞掉的 header 必須回傳錯誤, 不能先依 header 內的數字配置記憶體
+func TestDecodeMalformed(t *testing.T) {
+	cases := []struct {
+		name string
+		buf []byte
+		want string
+	}{
+		{"dimension count", hdr("CDF\x01").u32(0, tagDimension, 0x7fffffff), "exceeds"},
+		{"attribute count", hdr("CDF\x01").u32(0, 0, 0, tagAttribute, 0x7fffffff), "exceeds"},
+		{"variable count", hdr("CDF\x01").u32(0, 0, 0, 0, 0, tagVariable, 0xffffffff), "exceeds"},
+		{"variable dims", hdr("CDF\x01").u32(0, 0, 0, 0, 0, tagVariable, 1).name("v").u32(0x7fffffff), "exceeds"},
+		{"attribute values", hdr("CDF\x01").u32(0, 0, 0, tagAttribute, 1).name("a").u32(uint32(Double), 0x7fffffff), "end of header"},
+		{"attribute type", hdr("CDF\x01").u32(0, 0, 0, tagAttribute, 1).name("a").u32(9, 0), "nc_type"},
+		{"list tag", hdr("CDF\x01").u32(0, tagVariable, 1), "tag"},
+		{"dimension id", hdr("CDF\x01").u32(0, 0, 0, 0, 0, tagVariable, 1).name("v").u32(1, 3, 0, 0, uint32(Int), 4, 0), "dimension id"},
+		{"numrecs", testFile(0x7fffffff, []uint32{0, 2}, 8, 0, 4), "record out of file"},
+		{"record begin", testFile(2, []uint32{0, 2}, 8, 0xfffffff0, 4), "record out of file"},
+		{"record size overflow", testFile(2, []uint32{0, 0x10000, 0x10000, 0x10000}, 8, 0, 4), "out of file"},
+		{"data size overflow", testFile(0, []uint32{0x10000, 0x10000, 0x10000, 0x10000}, 8, 0, 4), "out of file"},
+		{"data begin", testFile(0, []uint32{2}, 8, 0xfffffff0, 2), "out of file"},
+		{"short data", testFile(0, []uint32{4}, 16, 0, 2), "out of file"},
+		{"version", []byte("CDF\x05"), "version"},
+		{"magic", []byte("HDF\x01"), "not a NetCDF"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode(tc.buf)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package netcdf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"encoding/binary"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+var be = binary.BigEndian
+
+type writer struct {
+	w *bufio.Writer
+	n int64
+	err error
+}
+
+func (w *writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	w.err = err
+}
+
+func (w *writer) u32(v uint32) {
+	var b [4]byte
+	be.PutUint32(b[:], v)
+	w.write(b[:])
+}
+
+func (w *writer) u64(v uint64) {
+	var b [8]byte
+	be.PutUint64(b[:], v)
+	w.write(b[:])
+}
+
+func (w *writer) pad() {
+	if n := pad4(w.n) - w.n; n > 0 {
+		w.write(make([]byte, n))
+	}
+}
+
+func (w *writer) name(s string) {
+	w.u32(uint32(len(s)))
+	w.write([]byte(s))
+	w.pad()
+}
+
+func (w *writer) values(typ Type, val interface{}) {
+	switch v := val.(type) {
+	case string:
+		w.write([]byte(v))
+	case []byte:
+		w.write(v)
+	case []int8:
+		b := make([]byte, len(v))
+		for i, x := range v {
+			b[i] = byte(x)
+		}
+		w.write(b)
+	case []int16:
+		b := make([]byte, 2 * len(v))
+		for i, x := range v {
+			be.PutUint16(b[2*i:], uint16(x))
+		}
+		w.write(b)
+	case []int32:
+		b := make([]byte, 4 * len(v))
+		for i, x := range v {
+			be.PutUint32(b[4*i:], uint32(x))
+		}
+		w.write(b)
+	case []float32:
+		b := make([]byte, 4 * len(v))
+		for i, x := range v {
+			be.PutUint32(b[4*i:], math.Float32bits(x))
+		}
+		w.write(b)
+	case []float64:
+		b := make([]byte, 8 * len(v))
+		for i, x := range v {
+			be.PutUint64(b[8*i:], math.Float64bits(x))
+		}
+		w.write(b)
+	}
+	w.pad()
+}
+
+func (w *writer) attrs(list []Attr) {
+	if len(list) == 0 {
+		w.u32(0)
+		w.u32(0)
+		return
+	}
+	w.u32(tagAttribute)
+	w.u32(uint32(len(list)))
+	for i := range list {
+		a := &list[i]
+		w.name(a.Name)
+		w.u32(uint32(a.Type))
+		w.u32(uint32(a.count()))
+		w.values(a.Type, a.Value)
+	}
+}
+
+func dataLen(val interface{}) int {
+	switch v := val.(type) {
+	case []byte:
+		return len(v)
+	case string:
+		return len(v)
+	}
+	a := Attr{Value: val}
+	return a.count()
+}
+
+func checkType(typ Type, val interface{}) bool {
+	switch val.(type) {
+	case []byte, string:
+		return typ == Char
+	case []int8:
+		return typ == Byte
+	case []int16:
+		return typ == Short
+	case []int32:
+		return typ == Int
+	case []float32:
+		return typ == Float
+	case []float64:
+		return typ == Double
+	}
+	return false
+}
+
+// 只支援固定大小的變數 (無record dimension)
+func (f *File) check() error {
+	for _, d := range f.Dims {
+		if d.Len <= 0 {
+			return fmt.Errorf("dimension %v: record dimension not supported by writer", d.Name)
+		}
+	}
+	for i := range f.Attrs {
+		if !checkType(f.Attrs[i].Type, f.Attrs[i].Value) {
+			return fmt.Errorf("global attribute %v: value does not match %v", f.Attrs[i].Name, f.Attrs[i].Type)
+		}
+	}
+	for _, v := range f.Vars {
+		for _, id := range v.Dims {
+			if id < 0 || id >= len(f.Dims) {
+				return fmt.Errorf("variable %v: bad dimension id %v", v.Name, id)
+			}
+		}
+		if !checkType(v.Type, v.Data) {
+			return fmt.Errorf("variable %v: data does not match %v", v.Name, v.Type)
+		}
+		if n := dataLen(v.Data); n != f.recElems(v) {
+			return fmt.Errorf("variable %v: data length %v, want %v", v.Name, n, f.recElems(v))
+		}
+		for i := range v.Attrs {
+			if !checkType(v.Attrs[i].Type, v.Attrs[i].Value) {
+				return fmt.Errorf("variable %v attribute %v: value does not match %v", v.Name, v.Attrs[i].Name, v.Attrs[i].Type)
+			}
+		}
+	}
+	return nil
+}
+
+// 只算header長度, 用來決定各變數的 begin
+func (f *File) headerSize(version int) int64 {
+	cw := &writer{w: bufio.NewWriter(io.Discard)}
+	f.writeHeader(cw, version)
+	return cw.n
+}
+
+func (f *File) writeHeader(w *writer, version int) {
+	w.write([]byte{'C', 'D', 'F', byte(version)})
+	w.u32(uint32(f.NumRecs))
+
+	if len(f.Dims) == 0 {
+		w.u32(0)
+		w.u32(0)
+	} else {
+		w.u32(tagDimension)
+		w.u32(uint32(len(f.Dims)))
+		for _, d := range f.Dims {
+			w.name(d.Name)
+			w.u32(uint32(d.Len))
+		}
+	}
+
+	w.attrs(f.Attrs)
+
+	if len(f.Vars) == 0 {
+		w.u32(0)
+		w.u32(0)
+		return
+	}
+	w.u32(tagVariable)
+	w.u32(uint32(len(f.Vars)))
+	for _, v := range f.Vars {
+		w.name(v.Name)
+		w.u32(uint32(len(v.Dims)))
+		for _, id := range v.Dims {
+			w.u32(uint32(id))
+		}
+		w.attrs(v.Attrs)
+		w.u32(uint32(v.Type))
+		vsize := v.vsize
+		if vsize > math.MaxUint32 {
+			vsize = math.MaxUint32
+		}
+		w.u32(uint32(vsize))
+		if version == 1 {
+			w.u32(uint32(v.begin))
+		} else {
+			w.u64(uint64(v.begin))
+		}
+	}
+}
+
+// 依序排版: header, 各變數資料 (各自補齊到4 bytes)
+func (f *File) layout(version int) int64 {
+	for _, v := range f.Vars {
+		v.vsize = pad4(int64(f.recElems(v) * v.Type.Size()))
+	}
+	off := f.headerSize(version)
+	for _, v := range f.Vars {
+		v.begin = off
+		off += v.vsize
+	}
+	return off
+}
+
+// 檔案超過2GiB時自動改用 64-bit offset (CDF-2)
+func (f *File) Write(out io.Writer) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+
+	version := f.Version
+	if version != 2 {
+		version = 1
+	}
+	end := f.layout(version)
+	if version == 1 && end > math.MaxInt32 {
+		version = 2
+		end = f.layout(version)
+	}
+	lib.Vln(5, "[nc]layout", version, end)
+
+	w := &writer{w: bufio.NewWriterSize(out, 64 * 1024)}
+	f.writeHeader(w, version)
+	for _, v := range f.Vars {
+		if w.n != v.begin {
+			return errors.New("layout mismatch")
+		}
+		w.values(v.Type, v.Data)
+	}
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,234 @@
+package lib
+
+/*
+* proxy 設定, 下載及所有上傳 (web hook, S3, SFTP) 共用同一個 DialFunc
+*	socks5://[user:pass@]host[:1080]  SOCKS5 (socks5h:// 相同, 一律由 proxy 解析網域名稱)
+*	http://[user:pass@]host[:80]      HTTP CONNECT
+*	https://[user:pass@]host[:443]    HTTP CONNECT, 與 proxy 之間為 TLS
+*	[user:pass@]host:port             舊的寫法, 視為 SOCKS5
+* 一律以 CONNECT 建立通道, http 目標也一樣 (不使用 absolute-form 的 GET)
+* 環境變數: HTTPS_PROXY (https 目標), HTTP_PROXY (其他, 含 sftp), NO_PROXY; 小寫亦可
+*	NO_PROXY: 以 "," 分隔, "*" 全部, 網域 (example.com 或 .example.com 含子網域), IP, CIDR, 可加 :port
+*	環境變數的 proxy 不用在 localhost/loopback
+*/
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var proxyDefaultPort = map[string]string{
+	"socks5": "1080",
+	"socks5h": "1080",
+	"http": "80",
+	"https": "443",
+}
+
+// 解析 proxy 設定, 並登記密碼 (log 遮蔽)
+func ParseProxy(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	if !strings.Contains(raw, "://") {
+		host, user, pass := SplitSocksAddr(raw)
+		u := &url.URL{Scheme: "socks5", Host: host}
+		if user != "" {
+			u.User = url.UserPassword(user, pass)
+		}
+		AddSecret(pass)
+		return u, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, RedactError(err)
+	}
+	if _, ok := proxyDefaultPort[u.Scheme]; !ok {
+		return nil, fmt.Errorf("proxy %v: unsupported scheme %q (socks5, http, https)", RedactURL(raw), u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy %v: no host", RedactURL(raw))
+	}
+	AddSecretURL(raw)
+	return u, nil
+}
+
+func proxyHostPort(p *url.URL) string {
+	if p.Port() != "" {
+		return p.Host
+	}
+	return net.JoinHostPort(p.Hostname(), proxyDefaultPort[p.Scheme])
+}
+
+// 經由 proxy 連到 targetAddr (host:port), 失敗時連線已關閉
+func DialProxy(p *url.URL, targetAddr string, timeout time.Duration) (net.Conn, error) {
+	switch p.Scheme {
+	case "socks5", "socks5h":
+		user, pass := "", ""
+		if p.User != nil {
+			user = p.User.Username()
+			pass, _ = p.User.Password()
+		}
+		return socksDial(targetAddr, proxyHostPort(p), user, pass, timeout)
+	case "http", "https":
+		return httpConnect(p, targetAddr, timeout)
+	}
+	return nil, fmt.Errorf("proxy: unsupported scheme %q", p.Scheme)
+}
+
+func httpConnect(p *url.URL, targetAddr string, timeout time.Duration) (net.Conn, error) {
+	proxyAddr := proxyHostPort(p)
+	conn, err := net.DialTimeout("tcp", proxyAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	fail := func(err error) (net.Conn, error) {
+		conn.Close()
+		return nil, fmt.Errorf("http proxy %v via %v: %v", targetAddr, proxyAddr, err)
+	}
+
+	if p.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: p.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			return fail(err)
+		}
+		conn = tc
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL: &url.URL{Opaque: targetAddr},
+		Host: targetAddr,
+		Header: make(http.Header),
+	}
+	req.Header.Set("User-Agent", UA)
+	if p.User != nil {
+		pass, _ := p.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(p.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic " + auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return fail(err)
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return fail(err)
+	}
+	if res.StatusCode != 200 {
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+		return fail(fmt.Errorf("CONNECT: %v", res.Status))
+	}
+	conn.SetDeadline(time.Time{})
+	return &bufConn{Conn: conn, r: br}, nil
+}
+
+// CONNECT 回應後 proxy 已送出的資料可能已經讀進 bufio.Reader,
+// 先讀完 bufio.Reader 內的, 之後直接讀連線
+type bufConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufConn) Read(b []byte) (int, error) {
+	if c.r != nil {
+		if c.r.Buffered() > 0 {
+			return c.r.Read(b)
+		}
+		c.r = nil
+	}
+	return c.Conn.Read(b)
+}
+
+func getEnvAny(names ...string) string {
+	for _, n := range names {
+		if v := os.Getenv(n); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// 依目標網址的 scheme 選 HTTPS_PROXY / HTTP_PROXY (與 port 無關), 設定錯誤時每次連線都回報
+func proxyFromEnv() func(scheme string, address string) (*url.URL, error) {
+	httpsProxy, errHttps := ParseProxy(getEnvAny("HTTPS_PROXY", "https_proxy"))
+	httpProxy, errHttp := ParseProxy(getEnvAny("HTTP_PROXY", "http_proxy"))
+	return func(scheme string, address string) (*url.URL, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if host == "localhost" || isLoopback(host) {
+			return nil, nil
+		}
+		if scheme == "https" {
+			return httpsProxy, errHttps
+		}
+		return httpProxy, errHttp
+	}
+}
+
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// NO_PROXY 比對, address 為 host:port
+func matchNoProxy(address string, list string) bool {
+	if list == "" {
+		return false
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+		entry = strings.Trim(entry, "[]")
+		if eip := net.ParseIP(entry); eip != nil {
+			if ip != nil && eip.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "." + entry) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,456 @@
+package s3
+
+/*
+* 上傳輸出檔到 S3 相容的物件儲存 (AWS S3, MinIO...), 只用標準函式庫
+* 簽章: AWS Signature Version 4, path-style (https://endpoint/bucket/key)
+* Content-Type 依副檔名; *.gz / *.br 為預先壓縮的檔案, 以去掉副檔名的 key 上傳並設定 Content-Encoding
+* 多檔輸出 (PutDir): 各檔案先上傳, index.json 最後, 之後才刪除本地已移除的舊檔
+* 網路錯誤及 5xx 會重試 (等待時間每次加倍)
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+const (
+	DefaultRegion = "us-east-1"
+
+	// index.json 常更新, 其他檔案名稱含模式時間
+	DefaultCacheControl = "public, max-age=3600"
+	DefaultIndexCacheControl = "no-cache"
+
+	maxBackoff = 2 * time.Minute
+	timeFormat = "20060102T150405Z"
+)
+
+// 副檔名 >> Content-Type, 沒列出的用 mime.TypeByExtension
+var contentTypes = map[string]string{
+	".json": "application/json",
+	".geojson": "application/geo+json",
+	".nc": "application/x-netcdf",
+}
+
+// 預先壓縮的副檔名 >> Content-Encoding
+var encodings = map[string]string{
+	".gz": "gzip",
+	".br": "br",
+}
+
+type Options struct {
+	URL string // https://endpoint/bucket/prefix/
+	Region string // 空白: us-east-1 (MinIO 預設)
+	AccessKey string
+	SecretKey string
+	CAFile string // 自訂CA (PEM), 空白用系統CA
+
+	CacheControl string // 空白: DefaultCacheControl
+	IndexCacheControl string // 空白: DefaultIndexCacheControl
+	Gzip bool // 上傳前壓縮 json/geojson (Content-Encoding: gzip)
+
+	Retries int // 失敗後重試次數
+	Backoff time.Duration // 第一次重試前等待, 之後每次加倍
+	Timeout time.Duration // 每次請求
+	Dial lib.DialFunc // nil: 直連
+}
+
+type Client struct {
+	opt Options
+	endpoint *url.URL // scheme + host
+	bucket string
+	prefix string // 空白或以 "/" 結尾
+	client *http.Client
+}
+
+func New(opt *Options) (*Client, error) {
+	o := *opt
+	if o.Region == "" {
+		o.Region = DefaultRegion
+	}
+	if o.CacheControl == "" {
+		o.CacheControl = DefaultCacheControl
+	}
+	if o.IndexCacheControl == "" {
+		o.IndexCacheControl = DefaultIndexCacheControl
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 60 * time.Second
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = time.Second
+	}
+	if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.Dial == nil {
+		o.Dial = lib.NewDialFunc("", 10 * time.Second)
+	}
+	if o.AccessKey == "" || o.SecretKey == "" {
+		return nil, errors.New("s3: missing access key or secret key")
+	}
+
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return nil, lib.RedactError(err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("s3: bad url %q, expect http(s)://endpoint/bucket/prefix", lib.Redact(o.URL))
+	}
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if parts[0] == "" {
+		return nil, fmt.Errorf("s3: no bucket in url %q", lib.Redact(o.URL))
+	}
+	prefix := ""
+	if len(parts) > 1 {
+		prefix = strings.Trim(parts[1], "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%v: no certificate found", o.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := &http.Transport{
+		Dial: o.Dial.ForURL(o.URL),
+		TLSHandshakeTimeout: o.Timeout,
+		TLSClientConfig: tlsConfig,
+	}
+	return &Client{
+		opt: o,
+		endpoint: &url.URL{Scheme: u.Scheme, Host: u.Host},
+		bucket: parts[0],
+		prefix: prefix,
+		client: &http.Client{Timeout: o.Timeout, Transport: transport},
+	}, nil
+}
+
+// 回應非 2xx, Code/Message 取自 S3 的 XML 錯誤
+type StatusError struct {
+	Status int
+	Code string
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: http status %v", e.Status)
+	}
+	return fmt.Sprintf("s3: http status %v: %v: %v", e.Status, e.Code, e.Message)
+}
+
+func retryable(err error) bool {
+	if se, ok := err.(*StatusError); ok {
+		return se.Status >= 500
+	}
+	return true
+}
+
+// 多檔輸出: 先上傳 files, 最後上傳 index, 再刪除 deleted (本地已移除的舊檔)
+// index 為空白時不刪除; 先刪舊檔會讓還在用舊 index.json 的讀者拿不到檔案
+func (c *Client) PutDir(dir string, files []string, index string, deleted []string) error {
+	for _, fn := range files {
+		if fn == index {
+			continue
+		}
+		err := c.PutFile(filepath.Join(dir, fn), fn)
+		if err != nil {
+			return err
+		}
+	}
+	if index == "" {
+		return nil
+	}
+	err := c.PutFile(filepath.Join(dir, index), index)
+	if err != nil {
+		return err
+	}
+	for _, fn := range deleted {
+		err := c.Delete(fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 上傳本地檔案, name 為相對於 prefix 的 key
+func (c *Client) PutFile(fp string, name string) error {
+	body, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return err
+	}
+	key, header := c.objectHeader(name)
+	if c.opt.Gzip && header.Get("Content-Encoding") == "" && compressible(key) {
+		body, err = gzipBytes(body)
+		if err != nil {
+			return err
+		}
+		header.Set("Content-Encoding", "gzip")
+	}
+	_, err = c.do("PUT", key, header, body)
+	if err != nil {
+		return fmt.Errorf("put %v: %w", key, err)
+	}
+	lib.Logger.Info("uploaded", "object", c.prefix + key, "size", len(body), "encoding", header.Get("Content-Encoding"))
+	return nil
+}
+
+// 不存在的物件也算成功 (S3 回 204)
+func (c *Client) Delete(name string) error {
+	key, _ := stripEncoding(name)
+	_, err := c.do("DELETE", key, nil, nil)
+	if err != nil {
+		return fmt.Errorf("delete %v: %w", key, err)
+	}
+	lib.Logger.Info("deleted", "object", c.prefix + key)
+	return nil
+}
+
+// x.json.gz >> x.json, gzip
+func stripEncoding(name string) (string, string) {
+	ext := path.Ext(name)
+	if enc, ok := encodings[ext]; ok {
+		return strings.TrimSuffix(name, ext), enc
+	}
+	return name, ""
+}
+
+func (c *Client) objectHeader(name string) (string, http.Header) {
+	key, enc := stripEncoding(name)
+	header := make(http.Header)
+	header.Set("Content-Type", ContentType(key))
+	if enc != "" {
+		header.Set("Content-Encoding", enc)
+	}
+	if path.Base(key) == "index.json" {
+		header.Set("Cache-Control", c.opt.IndexCacheControl)
+	} else {
+		header.Set("Cache-Control", c.opt.CacheControl)
+	}
+	return key, header
+}
+
+func ContentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := contentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func compressible(key string) bool {
+	ext := strings.ToLower(path.Ext(key))
+	return ext == ".json" || ext == ".geojson"
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	w, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// 依設定重試
+func (c *Client) do(method string, key string, header http.Header, body []byte) ([]byte, error) {
+	wait := c.opt.Backoff
+	for attempt := 0; ; attempt++ {
+		out, err := c.request(method, key, header, body)
+		if err == nil {
+			return out, nil
+		}
+		if attempt >= c.opt.Retries || !retryable(err) {
+			return nil, err
+		}
+		lib.Logger.Warn("s3 retry", "method", method, "object", key, "attempt", attempt+1, "wait", wait, "err", err)
+		time.Sleep(wait)
+		wait *= 2
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+}
+
+func (c *Client) request(method string, key string, header http.Header, body []byte) ([]byte, error) {
+	u := *c.endpoint
+	setPath(&u, "/" + c.bucket + "/" + c.prefix + key)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, lib.RedactError(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", lib.UA)
+	c.sign(req, body, time.Now())
+	req.Close = true
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, lib.RedactError(err)
+	}
+	defer res.Body.Close()
+
+	out, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, lib.RedactError(err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		se := &StatusError{Status: res.StatusCode}
+		var e struct {
+			Code string
+			Message string
+		}
+		if xml.Unmarshal(out, &e) == nil {
+			se.Code = e.Code
+			se.Message = lib.Redact(e.Message)
+		}
+		return nil, se
+	}
+	return out, nil
+}
+
+// ==== AWS Signature Version 4 ====
+
+// 送出的路徑以 uriEncode 編碼 (+ ! * $ 等也編碼), 與簽章的 canonical URI 相同;
+// 只設定 Path 時 Go 送出 EscapedPath(), 這些字元不會編碼, S3 驗證簽章會失敗
+func setPath(u *url.URL, p string) {
+	u.Path = p
+	u.RawPath = uriEncode(p, false)
+}
+
+// 加上 x-amz-date, x-amz-content-sha256, Authorization
+func (c *Client) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(timeFormat)
+	day := now.Format("20060102")
+	payload := hashHex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	// host 及所有要送出的 header 都簽 (User-Agent 除外, proxy 可能改寫)
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "user-agent" || lk == "authorization" {
+			continue
+		}
+		names = append(names, lk)
+		values[lk] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + values[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonReq := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(), // 與送出的相同, 見 setPath
+		canonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		payload,
+	}, "\n")
+
+	scope := day + "/" + c.opt.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonReq))
+
+	key := hmacSHA256([]byte("AWS4" + c.opt.SecretKey), day)
+	key = hmacSHA256(key, c.opt.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=" + c.opt.AccessKey + "/" + scope +
+		", SignedHeaders=" + signed + ", Signature=" + sig)
+}
+
+func hashHex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k, true) + "=" + uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// RFC 3986: 只保留 A-Z a-z 0-9 - _ . ~ (路徑的 "/" 另外保留)
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,656 @@
+package sftp
+
+/*
+* 以 SFTP 上傳輸出檔 (舊站台只開 SFTP)
+* 只實作上傳需要的 SFTP v3 (draft-ietf-secsh-filexfer-02): open/write/close, rename, remove, mkdir, readdir
+* 金鑰登入, 主機金鑰一律以 known_hosts 檢查
+* 每個檔案先寫到暫存檔名 (.NAME.part), 寫完才 rename, 讀取端不會讀到寫一半的檔案
+*	伺服器支援 posix-rename@openssh.com 時直接覆蓋; 不支援時先刪除舊檔再 rename
+* 多檔輸出 (PutDir): 各檔案先上傳, index.json 最後, 再刪除遠端資料夾內符合 Stale 但不在這次輸出的檔案
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+// packet types
+const (
+	fxpInit = 1
+	fxpVersion = 2
+	fxpOpen = 3
+	fxpClose = 4
+	fxpWrite = 6
+	fxpOpendir = 11
+	fxpReaddir = 12
+	fxpRemove = 13
+	fxpMkdir = 14
+	fxpRename = 18
+	fxpStatus = 101
+	fxpHandle = 102
+	fxpName = 104
+	fxpExtended = 200
+)
+
+// status codes
+const (
+	fxOK = 0
+	fxEOF = 1
+	fxNoSuchFile = 2
+)
+
+// open flags / attrs
+const (
+	fxfWrite = 0x02
+	fxfCreat = 0x08
+	fxfTrunc = 0x10
+
+	attrSize = 0x1
+	attrUIDGID = 0x2
+	attrPermissions = 0x4
+	attrACModTime = 0x8
+	attrExtended = 0x80000000
+)
+
+const (
+	posixRename = "posix-rename@openssh.com"
+	chunkSize = 32 * 1024
+	partSuffix = ".part"
+)
+
+type Options struct {
+	URL string // sftp://user@host:22/path/to/dir
+	KeyFile string // 私鑰 (OpenSSH/PEM, 不支援密碼保護)
+	KnownHosts string // 空白: ~/.ssh/known_hosts
+
+	// 遠端舊檔: 符合且不在這次輸出的檔案會被刪除, nil: 不刪除
+	Stale func(name string) bool
+
+	Timeout time.Duration // 連線+整次上傳
+	Dial lib.DialFunc // nil: 直連
+}
+
+type Client struct {
+	opt Options
+	addr string
+	dir string
+	config *ssh.ClientConfig
+}
+
+func New(opt *Options) (*Client, error) {
+	o := *opt
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Minute
+	}
+	if o.Dial == nil {
+		o.Dial = lib.NewDialFunc("", 10 * time.Second)
+	}
+
+	u, err := url.Parse(o.URL)
+	if err != nil {
+		return nil, lib.RedactError(err)
+	}
+	if u.Scheme != "sftp" || u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("sftp: bad url %q, expect sftp://user@host[:port]/dir", lib.Redact(o.URL))
+	}
+	if _, hasPass := u.User.Password(); hasPass {
+		return nil, errors.New("sftp: password login not supported, use a key file")
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	dir := u.Path
+	if dir == "" {
+		dir = "."
+	}
+
+	if o.KeyFile == "" {
+		return nil, errors.New("sftp: missing key file")
+	}
+	pem, err := ioutil.ReadFile(o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", o.KeyFile, err)
+	}
+
+	if o.KnownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		o.KnownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKey, err := knownhosts.New(o.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		opt: o,
+		addr: addr,
+		dir: dir,
+		config: &ssh.ClientConfig{
+			User: u.User.Username(),
+			Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKey,
+			Timeout: o.Timeout,
+			ClientVersion: "SSH-2.0-" + strings.ReplaceAll(lib.UA, " ", "_"),
+		},
+	}, nil
+}
+
+// 多檔輸出: 先上傳 files, 最後上傳 index, 再刪除遠端的舊檔
+// deleted 只為了與 webhook/s3 相同的介面, 遠端舊檔以 Stale 比對資料夾內容決定 (前幾次上傳失敗也能清掉)
+func (c *Client) PutDir(dir string, files []string, index string, deleted []string) error {
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.mkdirAll(c.dir)
+	for _, fn := range files {
+		if fn == index {
+			continue
+		}
+		err := conn.putFile(filepath.Join(dir, fn), path.Join(c.dir, fn))
+		if err != nil {
+			return err
+		}
+	}
+	if index == "" {
+		return nil
+	}
+	err = conn.putFile(filepath.Join(dir, index), path.Join(c.dir, index))
+	if err != nil {
+		return err
+	}
+	if c.opt.Stale == nil {
+		return nil
+	}
+
+	names, err := conn.readDir(c.dir)
+	if err != nil {
+		return err
+	}
+	keep := make(map[string]bool, len(files))
+	for _, fn := range files {
+		keep[fn] = true
+	}
+	for _, name := range names {
+		// 之前中斷留下的暫存檔也一併刪除
+		base := name
+		if strings.HasPrefix(name, ".") && strings.HasSuffix(name, partSuffix) {
+			base = strings.TrimSuffix(name[1:], partSuffix)
+		} else if keep[name] {
+			continue
+		}
+		if !c.opt.Stale(base) {
+			continue
+		}
+		err := conn.remove(path.Join(c.dir, name))
+		if err != nil {
+			return err
+		}
+		lib.Logger.Info("sftp removed", "file", name)
+	}
+	return nil
+}
+
+// ==== SFTP session ====
+
+type conn struct {
+	ssh *ssh.Client
+	session *ssh.Session
+	w io.WriteCloser
+	r io.Reader
+	id uint32
+	ext map[string]string
+}
+
+func (c *Client) connect() (*conn, error) {
+	raw, err := c.opt.Dial("sftp", "tcp", c.addr)
+	if err != nil {
+		return nil, lib.RedactError(err)
+	}
+	// 整次上傳的期限, 避免卡住 serve
+	raw.SetDeadline(time.Now().Add(c.opt.Timeout))
+
+	sc, chans, reqs, err := ssh.NewClientConn(raw, c.addr, c.config)
+	if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("sftp: %v: %v", c.addr, err)
+	}
+	client := ssh.NewClient(sc, chans, reqs)
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	cn := &conn{ssh: client, session: session}
+	cn.w, err = session.StdinPipe()
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	cn.r, err = session.StdoutPipe()
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	err = session.RequestSubsystem("sftp")
+	if err != nil {
+		cn.Close()
+		return nil, fmt.Errorf("sftp: subsystem: %v", err)
+	}
+	err = cn.init()
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+func (cn *conn) Close() error {
+	if cn.session != nil {
+		cn.session.Close()
+	}
+	return cn.ssh.Close()
+}
+
+func (cn *conn) init() error {
+	var b buffer
+	b.u32(3)
+	err := cn.send(fxpInit, b)
+	if err != nil {
+		return err
+	}
+	typ, data, err := cn.recv()
+	if err != nil {
+		return err
+	}
+	if typ != fxpVersion {
+		return fmt.Errorf("sftp: unexpected packet %v, expect version", typ)
+	}
+	p := parser(data)
+	version := p.u32()
+	if version < 3 {
+		return fmt.Errorf("sftp: server version %v", version)
+	}
+	cn.ext = make(map[string]string)
+	for len(p) > 0 && p.ok() {
+		name := p.str()
+		cn.ext[name] = p.str()
+	}
+	return nil
+}
+
+// 先寫到 .NAME.part, 再 rename
+func (cn *conn) putFile(local string, remote string) error {
+	fd, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	tmp := path.Join(path.Dir(remote), "." + path.Base(remote) + partSuffix)
+	handle, err := cn.open(tmp)
+	if err != nil {
+		return fmt.Errorf("sftp: open %v: %v", tmp, err)
+	}
+	size, err := cn.write(handle, fd)
+	errClose := cn.close(handle)
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		cn.remove(tmp)
+		return fmt.Errorf("sftp: write %v: %v", tmp, err)
+	}
+	err = cn.rename(tmp, remote)
+	if err != nil {
+		cn.remove(tmp)
+		return fmt.Errorf("sftp: rename %v: %v", remote, err)
+	}
+	lib.Logger.Info("sftp uploaded", "file", path.Base(remote), "size", size)
+	return nil
+}
+
+func (cn *conn) open(name string) (string, error) {
+	var b buffer
+	b.str(name)
+	b.u32(fxfWrite | fxfCreat | fxfTrunc)
+	b.u32(attrPermissions)
+	b.u32(0644)
+	return cn.handle(fxpOpen, b)
+}
+
+func (cn *conn) write(handle string, r io.Reader) (int64, error) {
+	buf := make([]byte, chunkSize)
+	var off int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			var b buffer
+			b.str(handle)
+			b.u64(uint64(off))
+			b.bytes(buf[:n])
+			if err := cn.status(fxpWrite, b); err != nil {
+				return off, err
+			}
+			off += int64(n)
+		}
+		if err == io.EOF {
+			return off, nil
+		}
+		if err != nil {
+			return off, err
+		}
+	}
+}
+
+func (cn *conn) close(handle string) error {
+	var b buffer
+	b.str(handle)
+	return cn.status(fxpClose, b)
+}
+
+// 支援 posix-rename 時覆蓋舊檔; 否則 (SFTP v3 的 rename 不覆蓋) 先刪除舊檔
+func (cn *conn) rename(from string, to string) error {
+	var b buffer
+	if _, ok := cn.ext[posixRename]; ok {
+		b.str(posixRename)
+		b.str(from)
+		b.str(to)
+		return cn.status(fxpExtended, b)
+	}
+	err := cn.remove(to)
+	if err != nil && !isNotExist(err) {
+		return err
+	}
+	b.str(from)
+	b.str(to)
+	return cn.status(fxpRename, b)
+}
+
+func (cn *conn) remove(name string) error {
+	var b buffer
+	b.str(name)
+	return cn.status(fxpRemove, b)
+}
+
+// 已存在的資料夾會失敗, 忽略錯誤, 之後的上傳會回報真正的問題
+func (cn *conn) mkdirAll(dir string) {
+	if dir == "." || dir == "/" || dir == "" {
+		return
+	}
+	cn.mkdirAll(path.Dir(dir))
+	var b buffer
+	b.str(dir)
+	b.u32(attrPermissions)
+	b.u32(0755)
+	cn.status(fxpMkdir, b)
+}
+
+// 資料夾內的檔名, 已排序
+func (cn *conn) readDir(dir string) ([]string, error) {
+	var b buffer
+	b.str(dir)
+	handle, err := cn.handle(fxpOpendir, b)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: opendir %v: %v", dir, err)
+	}
+	defer cn.close(handle)
+
+	var names []string
+	for {
+		var b buffer
+		b.str(handle)
+		typ, data, err := cn.request(fxpReaddir, b)
+		if err != nil {
+			return nil, err
+		}
+		if typ == fxpStatus {
+			err := statusError(data)
+			if se, ok := err.(*StatusError); ok && se.Code == fxEOF {
+				break
+			}
+			if err == nil {
+				err = errors.New("sftp: readdir: unexpected status")
+			}
+			return nil, err
+		}
+		if typ != fxpName {
+			return nil, fmt.Errorf("sftp: unexpected packet %v, expect name", typ)
+		}
+		p := parser(data)
+		count := p.u32()
+		for i := uint32(0); i < count && p.ok(); i++ {
+			name := p.str()
+			p.str() // longname
+			p.attrs()
+			if name != "." && name != ".." {
+				names = append(names, name)
+			}
+		}
+		if !p.ok() {
+			return nil, errors.New("sftp: readdir: short packet")
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// 回應為 handle 的請求 (open, opendir)
+func (cn *conn) handle(typ byte, b buffer) (string, error) {
+	rtyp, data, err := cn.request(typ, b)
+	if err != nil {
+		return "", err
+	}
+	switch rtyp {
+	case fxpHandle:
+		p := parser(data)
+		h := p.str()
+		if !p.ok() {
+			return "", errors.New("sftp: short handle")
+		}
+		return h, nil
+	case fxpStatus:
+		if err := statusError(data); err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("sftp: unexpected packet %v, expect handle", rtyp)
+}
+
+// 回應為 status 的請求
+func (cn *conn) status(typ byte, b buffer) error {
+	rtyp, data, err := cn.request(typ, b)
+	if err != nil {
+		return err
+	}
+	if rtyp != fxpStatus {
+		return fmt.Errorf("sftp: unexpected packet %v, expect status", rtyp)
+	}
+	return statusError(data)
+}
+
+// 一次只有一個請求, 回應的 id 必須相同
+func (cn *conn) request(typ byte, b buffer) (byte, []byte, error) {
+	cn.id++
+	id := cn.id
+	var req buffer
+	req.u32(id)
+	req = append(req, b...)
+	err := cn.send(typ, req)
+	if err != nil {
+		return 0, nil, err
+	}
+	rtyp, data, err := cn.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != id {
+		return 0, nil, errors.New("sftp: response id mismatch")
+	}
+	return rtyp, data[4:], nil
+}
+
+func (cn *conn) send(typ byte, payload []byte) error {
+	hdr := make([]byte, 5, 5 + len(payload))
+	binary.BigEndian.PutUint32(hdr, uint32(len(payload) + 1))
+	hdr[4] = typ
+	_, err := cn.w.Write(append(hdr, payload...))
+	return err
+}
+
+// 最大 256KB, 比一般伺服器的 readdir/status 大很多
+func (cn *conn) recv() (byte, []byte, error) {
+	var hdr [5]byte
+	_, err := io.ReadFull(cn.r, hdr[:])
+	if err != nil {
+		return 0, nil, fmt.Errorf("sftp: read: %v", err)
+	}
+	n := binary.BigEndian.Uint32(hdr[:4])
+	if n < 1 || n > 256 * 1024 {
+		return 0, nil, fmt.Errorf("sftp: bad packet length %v", n)
+	}
+	data := make([]byte, n-1)
+	_, err = io.ReadFull(cn.r, data)
+	if err != nil {
+		return 0, nil, fmt.Errorf("sftp: read: %v", err)
+	}
+	return hdr[4], data, nil
+}
+
+// SSH_FXP_STATUS, 非 OK
+type StatusError struct {
+	Code uint32
+	Msg string
+}
+
+func (e *StatusError) Error() string {
+	if e.Msg == "" {
+		return fmt.Sprintf("sftp: status %v", e.Code)
+	}
+	return fmt.Sprintf("sftp: status %v: %v", e.Code, e.Msg)
+}
+
+func statusError(data []byte) error {
+	p := parser(data)
+	code := p.u32()
+	msg := p.str()
+	if !p.ok() {
+		return errors.New("sftp: short status")
+	}
+	if code == fxOK {
+		return nil
+	}
+	return &StatusError{code, msg}
+}
+
+func isNotExist(err error) bool {
+	se, ok := err.(*StatusError)
+	return ok && se.Code == fxNoSuchFile
+}
+
+// ==== encoding ====
+
+type buffer []byte
+
+func (b *buffer) u32(v uint32) {
+	*b = binary.BigEndian.AppendUint32(*b, v)
+}
+
+func (b *buffer) u64(v uint64) {
+	*b = binary.BigEndian.AppendUint64(*b, v)
+}
+
+func (b *buffer) str(s string) {
+	b.u32(uint32(len(s)))
+	*b = append(*b, s...)
+}
+
+func (b *buffer) bytes(v []byte) {
+	b.u32(uint32(len(v)))
+	*b = append(*b, v...)
+}
+
+// 讀到不夠長時變成 nil, 之後都回傳零值, 最後以 ok() 檢查
+type parser []byte
+
+func (p *parser) ok() bool {
+	return *p != nil
+}
+
+func (p *parser) u32() uint32 {
+	if len(*p) < 4 {
+		*p = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(*p)
+	*p = (*p)[4:]
+	return v
+}
+
+func (p *parser) str() string {
+	n := p.u32()
+	if !p.ok() || uint32(len(*p)) < n {
+		*p = nil
+		return ""
+	}
+	s := string((*p)[:n])
+	*p = (*p)[n:]
+	return s
+}
+
+func (p *parser) skip(n int) {
+	if len(*p) < n {
+		*p = nil
+		return
+	}
+	*p = (*p)[n:]
+}
+
+func (p *parser) attrs() {
+	flags := p.u32()
+	if flags & attrSize != 0 {
+		p.skip(8)
+	}
+	if flags & attrUIDGID != 0 {
+		p.skip(8)
+	}
+	if flags & attrPermissions != 0 {
+		p.skip(4)
+	}
+	if flags & attrACModTime != 0 {
+		p.skip(8)
+	}
+	if flags & attrExtended != 0 {
+		n := p.u32()
+		for i := uint32(0); i < n && p.ok(); i++ {
+			p.str()
+			p.str()
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package validate
+
+/*
+* 輸出前檢查 VectorGrid, 有任何問題就不輸出
+*	nx*ny 與每個陣列長度相同
+*	範圍與描述(Description內的"經度110-126度，緯度7-36度")相符
+*	有原始座標時(Lons/Lats), 座標遞增且間距相同
+*	必要的變數都有, 數值在物理範圍內
+*/
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+// 變數 >> 合理範圍 (單位同輸出), 沒列出的不檢查
+var PhysicalRanges = map[string][2]float64{
+	"X": {-10, 10}, // m/s
+	"Y": {-10, 10},
+	"海表溫度": {-5, 45}, // °C
+	"海表鹽度": {0, 45}, // psu
+	"海高": {-10, 10}, // m
+	"浪高": {0, 3000}, // cm
+	"週期": {0, 5000}, // 0.01 s
+	"浪向": {0, 360}, // degree
+}
+
+type Rules struct {
+	Required []string // 必要的變數
+	Ranges map[string][2]float64 // nil 時用 PhysicalRanges
+	Nx, Ny int // 0: 不檢查
+}
+
+// 所有問題
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid grid: " + strings.Join(e.Problems, "; ")
+}
+
+// r 可為 nil (只做結構檢查)
+func Check(g *lib.VectorGrid, r *Rules) error {
+	c := &checker{g: g}
+	c.structure()
+	c.coords()
+	c.description()
+	if r != nil {
+		c.rules(r)
+	} else {
+		c.ranges(PhysicalRanges)
+	}
+	if len(c.problems) == 0 {
+		return nil
+	}
+	return &Error{c.problems}
+}
+
+type checker struct {
+	g *lib.VectorGrid
+	problems []string
+}
+
+func (c *checker) add(format string, v ...interface{}) {
+	c.problems = append(c.problems, fmt.Sprintf(format, v...))
+}
+
+// 依名稱排序, 輸出的問題順序固定
+func (c *checker) keys() []string {
+	keys := make([]string, 0, len(c.g.Data))
+	for k := range c.g.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *checker) structure() {
+	g := c.g
+	if g.Nx < 1 || g.Ny < 1 {
+		c.add("empty grid %vx%v", g.Nx, g.Ny)
+	}
+	if g.La1 < g.La2 {
+		c.add("la1 %v < la2 %v", g.La1, g.La2)
+	}
+	if g.Lo1 > g.Lo2 {
+		c.add("lo1 %v > lo2 %v", g.Lo1, g.Lo2)
+	}
+	if _, err := g.ParseTime(); err != nil {
+		c.add("time %q: %v", g.Time, err)
+	}
+	if len(g.Data) == 0 {
+		c.add("no variable")
+	}
+	for _, k := range c.keys() {
+		arr := g.Data[k]
+		if len(arr) != g.Nx * g.Ny {
+			c.add("%v: len %v != nx*ny %v", k, len(arr), g.Nx * g.Ny)
+		}
+	}
+}
+
+// 原始座標: 數量, 遞增, 間距相同, 與 lo1/lo2/la1/la2 一致
+func (c *checker) coords() {
+	g := c.g
+	c.axis("lon", g.Lons, g.Nx, float64(g.Lo1), float64(g.Lo2))
+	c.axis("lat", g.Lats, g.Ny, float64(g.La2), float64(g.La1))
+}
+
+func (c *checker) axis(name string, v []float64, n int, first float64, last float64) {
+	if v == nil {
+		return
+	}
+	if len(v) != n {
+		c.add("%v: %v coordinates for %v cells", name, len(v), n)
+		return
+	}
+	if n < 2 {
+		return
+	}
+	step := (v[n-1] - v[0]) / float64(n-1)
+	tol := math.Max(1e-4, math.Abs(step) * 0.01)
+	for i := 1; i < n; i++ {
+		d := v[i] - v[i-1]
+		if d <= 0 {
+			c.add("%v: not increasing at %v (%v, %v)", name, i, v[i-1], v[i])
+			return
+		}
+		if math.Abs(d - step) > tol {
+			c.add("%v: uneven spacing at %v (%v, expect %v)", name, i, d, step)
+			return
+		}
+	}
+	if math.Abs(v[0] - first) > tol || math.Abs(v[n-1] - last) > tol {
+		c.add("%v: coordinates %v~%v != grid %v~%v", name, v[0], v[n-1], first, last)
+	}
+}
+
+// 範圍經度109.90-126.10度，緯度9.40-36.10度 / 東經110~126度、北緯7~36度
+var (
+	descLonRx = regexp.MustCompile(`(?:經度|東經)\s*(-?[0-9.]+)\s*[-~～至到]\s*(-?[0-9.]+)\s*度`)
+	descLatRx = regexp.MustCompile(`(?:緯度|北緯)\s*(-?[0-9.]+)\s*[-~～至到]\s*(-?[0-9.]+)\s*度`)
+)
+
+// 描述的範圍可能是格點的邊界(多半格), 容許差一格
+func (c *checker) description() {
+	g := c.g
+	tol := math.Max(float64(g.Dx()), float64(g.Dy())) + 1e-3
+	for _, desc := range strings.Split(g.Desc, ";") {
+		if lo, ok := descRange(descLonRx, desc); ok {
+			if math.Abs(float64(g.Lo1) - lo[0]) > tol || math.Abs(float64(g.Lo2) - lo[1]) > tol {
+				c.add("lon %v~%v does not match description %v~%v", g.Lo1, g.Lo2, lo[0], lo[1])
+			}
+		}
+		if la, ok := descRange(descLatRx, desc); ok {
+			if math.Abs(float64(g.La2) - la[0]) > tol || math.Abs(float64(g.La1) - la[1]) > tol {
+				c.add("lat %v~%v does not match description %v~%v", g.La2, g.La1, la[0], la[1])
+			}
+		}
+	}
+}
+
+func descRange(rx *regexp.Regexp, desc string) ([2]float64, bool) {
+	m := rx.FindStringSubmatch(desc)
+	if m == nil {
+		return [2]float64{}, false
+	}
+	a, err1 := strconv.ParseFloat(m[1], 64)
+	b, err2 := strconv.ParseFloat(m[2], 64)
+	if err1 != nil || err2 != nil {
+		return [2]float64{}, false
+	}
+	return [2]float64{math.Min(a, b), math.Max(a, b)}, true
+}
+
+func (c *checker) rules(r *Rules) {
+	g := c.g
+	if r.Nx > 0 && g.Nx != r.Nx {
+		c.add("nx %v, expect %v", g.Nx, r.Nx)
+	}
+	if r.Ny > 0 && g.Ny != r.Ny {
+		c.add("ny %v, expect %v", g.Ny, r.Ny)
+	}
+	for _, k := range r.Required {
+		if _, ok := g.Data[k]; !ok {
+			c.add("missing variable %v", k)
+		}
+	}
+	ranges := r.Ranges
+	if ranges == nil {
+		ranges = PhysicalRanges
+	}
+	c.ranges(ranges)
+}
+
+// NaN 不算, Inf 算超出
+func (c *checker) ranges(ranges map[string][2]float64) {
+	for _, k := range c.keys() {
+		arr := c.g.Data[k]
+		valid := 0
+		bad := 0
+		var badV lib.JsonFloat
+		rg, hasRange := ranges[k]
+		for _, v := range arr {
+			if v.IsNaN() {
+				continue
+			}
+			valid++
+			if math.IsInf(float64(v), 0) || (hasRange && (float64(v) < rg[0] || float64(v) > rg[1])) {
+				if bad == 0 {
+					badV = v
+				}
+				bad++
+			}
+		}
+		if valid == 0 && len(arr) > 0 {
+			c.add("%v: no valid value", k)
+		}
+		if bad > 0 {
+			c.add("%v: %v values out of range [%v, %v] (e.g. %v)", k, bad, rg[0], rg[1], badV)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package validate
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+// 3x2, 東經 120~122, 北緯 22~23
+func testGrid() *lib.VectorGrid {
+	g := lib.NewVectorGrid()
+	g.Lo1, g.Lo2 = 120, 122
+	g.La1, g.La2 = 23, 22
+	g.Nx, g.Ny = 3, 2
+	g.Time = "2024-01-01T00:00:00+08:00"
+	g.Desc = "海流模式(範圍經度120-122度，緯度22-23度)"
+	g.Data["海表溫度"] = []lib.JsonFloat{25, 26, lib.JsonFloat(math.NaN()), 27, 28, 29}
+	g.Data["海高"] = []lib.JsonFloat{0.1, 0.2, 0.3, 0.4, 0.5, 0.6}
+	g.Lons = []float64{120, 121, 122}
+	g.Lats = []float64{22, 23}
+	return g
+}
+
+func TestCheck(t *testing.T) {
+	nan := lib.JsonFloat(math.NaN())
+	rules := &Rules{Required: []string{"海表溫度", "海高"}, Nx: 3, Ny: 2}
+	cases := []struct {
+		name string
+		edit func(g *lib.VectorGrid)
+		rules *Rules
+		want string // 空白: 通過
+	}{
+		{"ok", func(g *lib.VectorGrid) {}, rules, ""},
+		{"ok without rules", func(g *lib.VectorGrid) {}, nil, ""},
+		{"ok without coordinates", func(g *lib.VectorGrid) { g.Lons, g.Lats = nil, nil }, rules, ""},
+		{"empty grid", func(g *lib.VectorGrid) { g.Nx, g.Ny = 0, 0 }, nil, "empty grid 0x0"},
+		{"array length", func(g *lib.VectorGrid) { g.Data["海高"] = g.Data["海高"][:5] }, nil, "海高: len 5 != nx*ny 6"},
+		{"nx from rules", func(g *lib.VectorGrid) {}, &Rules{Nx: 4}, "nx 3, expect 4"},
+		{"ny from rules", func(g *lib.VectorGrid) {}, &Rules{Ny: 3}, "ny 2, expect 3"},
+		{"la1 < la2", func(g *lib.VectorGrid) { g.La1, g.La2 = 22, 23; g.Lats = nil }, nil, "la1 22 < la2 23"},
+		{"lo1 > lo2", func(g *lib.VectorGrid) { g.Lo1, g.Lo2 = 122, 120; g.Lons = nil }, nil, "lo1 122 > lo2 120"},
+		{"time", func(g *lib.VectorGrid) { g.Time = "yesterday" }, nil, "time \"yesterday\""},
+		{"no variable", func(g *lib.VectorGrid) { g.Data = map[string][]lib.JsonFloat{} }, nil, "no variable"},
+		{"coordinate count", func(g *lib.VectorGrid) { g.Lons = []float64{120, 122} }, nil, "lon: 2 coordinates for 3 cells"},
+		{"not increasing", func(g *lib.VectorGrid) { g.Lons = []float64{120, 120, 122} }, nil, "lon: not increasing at 1"},
+		{"uneven spacing", func(g *lib.VectorGrid) { g.Lons = []float64{120, 120.5, 122} }, nil, "lon: uneven spacing at 1 (0.5, expect 1)"},
+		{"coordinates != grid", func(g *lib.VectorGrid) { g.Lats = []float64{21, 22} }, nil, "lat: coordinates 21~22 != grid 22~23"},
+		{"lon description", func(g *lib.VectorGrid) { g.Desc = "範圍經度110-126度，緯度22-23度" }, nil, "lon 120~122 does not match description 110~126"},
+		{"lat description", func(g *lib.VectorGrid) { g.Desc = "東經120~122度、北緯7~36度" }, nil, "lat 22~23 does not match description 7~36"},
+		{"description within a cell", func(g *lib.VectorGrid) { g.Desc = "範圍經度119.50-122.50度，緯度21.50-23.50度" }, nil, ""},
+		{"required", func(g *lib.VectorGrid) { delete(g.Data, "海高") }, rules, "missing variable 海高"},
+		{"range", func(g *lib.VectorGrid) { g.Data["海表溫度"][1] = 60 }, nil, "海表溫度: 1 values out of range [-5, 45] (e.g. 60)"},
+		{"range from rules", func(g *lib.VectorGrid) {}, &Rules{Ranges: map[string][2]float64{"海高": {0, 0.5}}}, "海高: 1 values out of range [0, 0.5]"},
+		{"inf", func(g *lib.VectorGrid) { g.Data["海高"][0] = lib.JsonFloat(math.Inf(1)) }, nil, "海高: 1 values out of range"},
+		{"all NaN", func(g *lib.VectorGrid) { g.Data["海高"] = []lib.JsonFloat{nan, nan, nan, nan, nan, nan} }, nil, "海高: no valid value"},
+		{"unknown variable not range checked", func(g *lib.VectorGrid) { g.Data["other"] = []lib.JsonFloat{1e9, 0, 0, 0, 0, 0} }, nil, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := testGrid()
+			tc.edit(g)
+			err := Check(g, tc.rules)
+			if tc.want == "" {
+				if err != nil {
+					t.Errorf("err = %v", err)
+				}
+				return
+			}
+			ve, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("err = %v, want *Error", err)
+			}
+			if !strings.Contains(strings.Join(ve.Problems, "\n"), tc.want) {
+				t.Errorf("problems %q, want %q", ve.Problems, tc.want)
+			}
+		})
+	}
+}
+
+// 所有問題一起回報, 順序固定
+func TestCheckAllProblems(t *testing.T) {
+	g := testGrid()
+	g.Data["海高"] = g.Data["海高"][:4]
+	g.Data["海表溫度"][0] = 99
+	err := Check(g, &Rules{Required: []string{"X"}})
+	ve, ok := err.(*Error)
+	if !ok || len(ve.Problems) != 3 {
+		t.Fatalf("err = %v", err)
+	}
+	want := []string{"海高: len 4 != nx*ny 6", "missing variable X", "海表溫度: 1 values out of range [-5, 45] (e.g. 99)"}
+	for i, p := range want {
+		if ve.Problems[i] != p {
+			t.Errorf("problem %d = %q, want %q", i, ve.Problems[i], p)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package webhook
+
+/*
+* 上傳輸出檔到線上站台 (multipart, 欄位 "file")
+* 預設檢查TLS憑證, 可另外指定CA (PEM)
+* 有 secret 時簽章: X-OAC-Timestamp 為 unix 秒數, X-OAC-Signature 為
+*	"sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body))
+* 接收端應檢查簽章及時間差 (例如5分鐘內) 以防重送
+* 網路錯誤及 5xx 會重試 (等待時間每次加倍), 非 2xx 都算失敗
+* 多檔輸出 (PostDir): 各檔案先上傳, index.json 最後, 並附上 delete 欄位列出接收端應刪除的舊檔
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+)
+
+const (
+	HeaderTimestamp = "X-OAC-Timestamp"
+	HeaderSignature = "X-OAC-Signature"
+
+	// 隨 index.json 送出, 要刪除的舊檔 (JSON array)
+	FieldDelete = "delete"
+
+	maxBackoff = 2 * time.Minute
+)
+
+type Options struct {
+	URL string
+	Secret string // HMAC-SHA256 key, 空白不簽章
+	CAFile string // 自訂CA (PEM), 空白用系統CA
+	Insecure bool // 不檢查憑證, 只供測試
+
+	Retries int // 失敗後重試次數
+	Backoff time.Duration // 第一次重試前等待, 之後每次加倍
+	Timeout time.Duration // 每次請求
+	Dial lib.DialFunc // nil: 直連
+}
+
+type Client struct {
+	opt Options
+	client *http.Client
+}
+
+func New(opt *Options) (*Client, error) {
+	o := *opt
+	if o.Timeout <= 0 {
+		o.Timeout = 60 * time.Second
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = time.Second
+	}
+	if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.Dial == nil {
+		o.Dial = lib.NewDialFunc("", 10 * time.Second)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.Insecure}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%v: no certificate found", o.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := &http.Transport{
+		Dial: o.Dial.ForURL(o.URL),
+		TLSHandshakeTimeout: o.Timeout,
+		TLSClientConfig: tlsConfig,
+	}
+	return &Client{
+		opt: o,
+		client: &http.Client{Timeout: o.Timeout, Transport: transport},
+	}, nil
+}
+
+// 回應非 2xx
+type StatusError struct {
+	Code int
+	Status string
+	Body string // 前 256 bytes
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return "webhook: http status " + e.Status
+	}
+	return "webhook: http status " + e.Status + ": " + e.Body
+}
+
+// 5xx 及網路錯誤可重試
+func retryable(err error) bool {
+	if se, ok := err.(*StatusError); ok {
+		return se.Code >= 500
+	}
+	return true
+}
+
+// 上傳一個檔案, 回傳回應內容
+func (c *Client) PostFile(fileName string, data io.Reader) ([]byte, error) {
+	return c.PostFileFields(fileName, data, nil)
+}
+
+// 上傳一個檔案並附上其他欄位
+func (c *Client) PostFileFields(fileName string, data io.Reader, fields map[string]string) ([]byte, error) {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		err := w.WriteField(k, fields[k])
+		if err != nil {
+			return nil, err
+		}
+	}
+	fw, err := w.CreateFormFile("file", fileName)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(fw, data)
+	if err != nil {
+		return nil, err
+	}
+	// 沒有 Close 就沒有結尾的 boundary
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return c.Post(w.FormDataContentType(), b.Bytes())
+}
+
+// 多檔輸出 (grid.json + index.json): 先逐一上傳 files, 最後上傳 index,
+// 並以 delete 欄位 (JSON array) 告知接收端要刪除的舊檔; index 為空白時不上傳也不通知刪除
+func (c *Client) PostDir(dir string, files []string, index string, deleted []string) error {
+	for _, fn := range files {
+		if fn == index {
+			continue
+		}
+		err := c.postPath(dir, fn, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if index == "" {
+		return nil
+	}
+	if deleted == nil {
+		deleted = []string{}
+	}
+	list, err := json.Marshal(deleted)
+	if err != nil {
+		return err
+	}
+	return c.postPath(dir, index, map[string]string{FieldDelete: string(list)})
+}
+
+func (c *Client) postPath(dir string, fn string, fields map[string]string) error {
+	fd, err := os.Open(filepath.Join(dir, fn))
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	_, err = c.PostFileFields(fn, fd, fields)
+	if err != nil {
+		return fmt.Errorf("post %v: %v", fn, err)
+	}
+	lib.Logger.Info("posted", "file", fn, "fields", len(fields))
+	return nil
+}
+
+// 送出 body, 依設定簽章及重試
+func (c *Client) Post(contentType string, body []byte) ([]byte, error) {
+	wait := c.opt.Backoff
+	for attempt := 0; ; attempt++ {
+		out, err := c.post(contentType, body)
+		if err == nil {
+			return out, nil
+		}
+		if attempt >= c.opt.Retries || !retryable(err) {
+			return nil, err
+		}
+		lib.Logger.Warn("webhook retry", "attempt", attempt+1, "wait", wait, "err", err)
+		time.Sleep(wait)
+		wait *= 2
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+}
+
+func (c *Client) post(contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest("POST", c.opt.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, lib.RedactError(err)
+	}
+	req.Header.Set("User-Agent", lib.UA)
+	req.Header.Set("Content-Type", contentType)
+	if c.opt.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, ts)
+		req.Header.Set(HeaderSignature, Sign(c.opt.Secret, ts, body))
+	}
+	req.Close = true
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, lib.RedactError(err)
+	}
+	defer res.Body.Close()
+
+	out, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, lib.RedactError(err)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg := out
+		if len(msg) > 256 {
+			msg = msg[:256]
+		}
+		return nil, &StatusError{Code: res.StatusCode, Status: res.Status, Body: lib.Redact(string(msg))}
+	}
+	return out, nil
+}
+
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body))
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// 接收端用: 檢查簽章及時間差
+func Verify(secret string, timestamp string, signature string, body []byte, maxSkew time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("webhook: bad timestamp %q", timestamp)
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if maxSkew > 0 && skew > maxSkew {
+		return fmt.Errorf("webhook: timestamp too old (%v)", skew)
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return fmt.Errorf("webhook: bad signature")
+	}
+	return nil
+}
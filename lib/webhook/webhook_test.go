@@ -0,0 +1,251 @@
+package webhook
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 記錄收到的請求
+type received struct {
+	File string
+	Fields map[string]string
+	Body string
+	Header http.Header
+}
+
+type recorder struct {
+	mu sync.Mutex
+	list []*received
+}
+
+func (rec *recorder) handler(t *testing.T, status func(n int) int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec.mu.Lock()
+		n := len(rec.list)
+		rec.mu.Unlock()
+		if code := status(n); code != http.StatusOK {
+			rec.mu.Lock()
+			rec.list = append(rec.list, &received{Header: r.Header})
+			rec.mu.Unlock()
+			http.Error(w, "nope", code)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("bad multipart: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got := &received{Fields: make(map[string]string), Body: string(body), Header: r.Header}
+		for k, v := range r.MultipartForm.Value {
+			got.Fields[k] = v[0]
+		}
+		if fhs := r.MultipartForm.File["file"]; len(fhs) == 1 {
+			got.File = fhs[0].Filename
+		}
+		rec.mu.Lock()
+		rec.list = append(rec.list, got)
+		rec.mu.Unlock()
+		w.Write([]byte("ok"))
+	}
+}
+
+func okStatus(n int) int {
+	return http.StatusOK
+}
+
+func TestSignature(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec.handler(t, okStatus))
+	defer srv.Close()
+
+	const secret = "hook-secret"
+	c, err := New(&Options{URL: srv.URL, Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := c.PostFile("a.grid.json", strings.NewReader(`{"nx":1}`))
+	if err != nil || string(out) != "ok" {
+		t.Fatalf("post = %q, %v", out, err)
+	}
+
+	got := rec.list[0]
+	ts := got.Header.Get(HeaderTimestamp)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Since(time.Unix(sec, 0)) > time.Minute {
+		t.Errorf("timestamp %q", ts)
+	}
+	sig := got.Header.Get(HeaderSignature)
+	if !strings.HasPrefix(sig, "sha256=") || sig != Sign(secret, ts, []byte(got.Body)) {
+		t.Errorf("signature %q", sig)
+	}
+	if err := Verify(secret, ts, sig, []byte(got.Body), 5 * time.Minute); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	if err := Verify("other", ts, sig, []byte(got.Body), 5 * time.Minute); err == nil {
+		t.Error("verify with wrong secret")
+	}
+	if err := Verify(secret, ts, sig, []byte(got.Body + "x"), 5 * time.Minute); err == nil {
+		t.Error("verify with changed body")
+	}
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	if err := Verify(secret, old, Sign(secret, old, []byte(got.Body)), []byte(got.Body), 5 * time.Minute); err == nil {
+		t.Error("verify old timestamp")
+	}
+
+	// 沒有 secret 不簽章
+	c, _ = New(&Options{URL: srv.URL})
+	if _, err := c.PostFile("b.grid.json", strings.NewReader("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if h := rec.list[1].Header; h.Get(HeaderSignature) != "" || h.Get(HeaderTimestamp) != "" {
+		t.Errorf("signed without secret: %v", h)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	cases := []struct {
+		name string
+		code int
+		retries int
+		wantErr bool
+		wantReq int
+	}{
+		{"5xx then ok", http.StatusBadGateway, 3, false, 3},
+		{"5xx exhausted", http.StatusServiceUnavailable, 1, true, 2},
+		{"4xx no retry", http.StatusForbidden, 3, true, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &recorder{}
+			// 前兩次失敗
+			srv := httptest.NewServer(rec.handler(t, func(n int) int {
+				if n < 2 {
+					return tc.code
+				}
+				return http.StatusOK
+			}))
+			defer srv.Close()
+
+			c, err := New(&Options{URL: srv.URL, Retries: tc.retries, Backoff: time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = c.PostFile("a.json", strings.NewReader("{}"))
+			if (err != nil) != tc.wantErr {
+				t.Errorf("err = %v", err)
+			}
+			if se, ok := err.(*StatusError); tc.wantErr && (!ok || se.Code != tc.code || !strings.Contains(se.Body, "nope")) {
+				t.Errorf("err = %#v, want StatusError %v", err, tc.code)
+			}
+			if len(rec.list) != tc.wantReq {
+				t.Errorf("%d requests, want %d", len(rec.list), tc.wantReq)
+			}
+		})
+	}
+}
+
+func TestCustomCA(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewTLSServer(rec.handler(t, okStatus))
+	defer srv.Close()
+
+	// 系統CA不認得 httptest 的憑證, 不重試
+	c, err := New(&Options{URL: srv.URL, Retries: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PostFile("a.json", strings.NewReader("{}")); err == nil {
+		t.Fatal("no TLS error without CA")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err = New(&Options{URL: srv.URL, CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PostFile("a.json", strings.NewReader("{}")); err != nil {
+		t.Fatalf("with CA: %v", err)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.pem")
+	os.WriteFile(bad, []byte("not a certificate"), 0600)
+	if _, err := New(&Options{URL: srv.URL, CAFile: bad}); err == nil {
+		t.Error("no error for CA file without certificate")
+	}
+}
+
+func TestPostDir(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec.handler(t, okStatus))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	files := []string{"index.json", "20072318.000.grid.json", "20072318.000.hs.geojson"}
+	for _, fn := range files {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := New(&Options{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := []string{"20072315.000.grid.json", "20072315.000.nc"}
+	if err := c.PostDir(dir, files, "index.json", removed); err != nil {
+		t.Fatal(err)
+	}
+
+	// index.json 最後, 只有它帶 delete
+	want := []string{"20072318.000.grid.json", "20072318.000.hs.geojson", "index.json"}
+	if len(rec.list) != len(want) {
+		t.Fatalf("%d posts, want %d", len(rec.list), len(want))
+	}
+	for i, got := range rec.list {
+		if got.File != want[i] {
+			t.Errorf("post %d = %v, want %v", i, got.File, want[i])
+		}
+		_, ok := got.Fields[FieldDelete]
+		if ok != (got.File == "index.json") {
+			t.Errorf("%v: delete field %v", got.File, got.Fields)
+		}
+	}
+	var del []string
+	if err := json.Unmarshal([]byte(rec.list[2].Fields[FieldDelete]), &del); err != nil || strings.Join(del, ",") != strings.Join(removed, ",") {
+		t.Errorf("delete = %q, %v", rec.list[2].Fields[FieldDelete], err)
+	}
+
+	// 沒有舊檔時送空的 array
+	rec.list = nil
+	if err := c.PostDir(dir, []string{"index.json"}, "index.json", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.list) != 1 || rec.list[0].Fields[FieldDelete] != "[]" {
+		t.Errorf("delete without removed: %+v", rec.list)
+	}
+
+	// 檔案不存在
+	if err := c.PostDir(dir, []string{"nope.json"}, "", nil); err == nil {
+		t.Error("no error for missing file")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+/*
+* inspect: 列出grid的大小、範圍、時間及各變數的值域
+* validate: 檢查grid是否完整 (lib/validate, 與輸出前的檢查相同), 有問題時回傳非0
+* 輸入可為 grid.json, 或加上 -d 直接解碼原始檔
+*/
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+	"github.com/OAC-TW/oac-opendata-converters/lib/dataset"
+	"github.com/OAC-TW/oac-opendata-converters/lib/validate"
+)
+
+type namedGrid struct {
+	Name string
+	Grid *lib.VectorGrid
+	Missing []string // 原始檔缺少/解析失敗的變數, 全部失敗時 Grid 為 nil
+}
+
+// FILE 為 grid.json, 指定 dsID 時為該資料集的原始檔
+func loadGrids(dsID string, files []string) ([]*namedGrid, error) {
+	var ds dataset.Dataset
+	if dsID != "" {
+		var err error
+		ds, err = dataset.Get(dsID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]*namedGrid, 0, len(files))
+	for _, fp := range files {
+		if ds == nil {
+			grid, err := lib.ReadGridFile(fp)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", fp, err)
+			}
+			out = append(out, &namedGrid{fp, grid, nil})
+			continue
+		}
+
+		raw, err := ioutil.ReadFile(fp)
+		if err != nil {
+			return nil, err
+		}
+		res, err := ds.Decode(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", fp, err)
+		}
+		for _, f := range res.Frames {
+			name := fmt.Sprintf("%v[%03d]", fp, f.Lead)
+			out = append(out, &namedGrid{name, f.Grid, f.Missing})
+		}
+	}
+	return out, nil
+}
+
+func sortedKeys(grid *lib.VectorGrid) []string {
+	keys := make([]string, 0, len(grid.Data))
+	for k := range grid.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ==== inspect ====
+
+var cmdInspect = &command{
+	Name: "inspect",
+	Args: "FILE...",
+	Short: "print size, bbox, time and value range of grids",
+}
+
+var inspectDataset *string
+
+func init() {
+	cmdInspect.Flags = func(fs *flag.FlagSet) {
+		inspectDataset = fs.String("d", "", "decode FILE as raw file of this dataset instead of grid.json")
+		addVerbosity(fs)
+	}
+	cmdInspect.Run = runInspect
+}
+
+func runInspect(fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	list, err := loadGrids(*inspectDataset, args)
+	if err != nil {
+		return err
+	}
+	for _, ng := range list {
+		g := ng.Grid
+		fmt.Printf("%v\n", ng.Name)
+		if len(ng.Missing) > 0 {
+			fmt.Printf("  missing: %v\n", strings.Join(ng.Missing, ", "))
+		}
+		if g == nil {
+			continue
+		}
+		fmt.Printf("  time: %v\n", g.Time)
+		fmt.Printf("  desc: %v\n", g.Desc)
+		fmt.Printf("  size: %v x %v\n", g.Nx, g.Ny)
+		fmt.Printf("  bbox: lon %v ~ %v, lat %v ~ %v\n", g.Lo1, g.Lo2, g.La2, g.La1)
+		for _, k := range sortedKeys(g) {
+			arr := g.Data[k]
+			nan := 0
+			for _, v := range arr {
+				if v.IsNaN() {
+					nan++
+				}
+			}
+			fmt.Printf("  %v: len %v, nan %v, range %v", k, len(arr), nan, g.DataRange[k])
+			if u := g.Units[k]; u != "" {
+				fmt.Printf(" (%v)", u)
+			}
+			fmt.Printf("\n")
+		}
+	}
+	return nil
+}
+
+// ==== validate ====
+
+var cmdValidate = &command{
+	Name: "validate",
+	Args: "FILE...",
+	Short: "check grids are complete, exit 1 on any problem",
+}
+
+var validateDataset *string
+
+func init() {
+	cmdValidate.Flags = func(fs *flag.FlagSet) {
+		validateDataset = fs.String("d", "", "decode FILE as raw file of this dataset instead of grid.json")
+		addVerbosity(fs)
+	}
+	cmdValidate.Run = runValidate
+}
+
+func runValidate(fs *flag.FlagSet, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	list, err := loadGrids(*validateDataset, args)
+	if err != nil {
+		return err
+	}
+	var rules *validate.Rules
+	if *validateDataset != "" {
+		ds, err := dataset.Get(*validateDataset)
+		if err != nil {
+			return err
+		}
+		rules = dataset.RulesFor(ds)
+	}
+	bad := 0
+	for _, ng := range list {
+		if ng.Grid == nil {
+			bad++
+			fmt.Fprintf(os.Stderr, "%v: no data, missing %v\n", ng.Name, strings.Join(ng.Missing, ", "))
+			continue
+		}
+		err := validate.Check(ng.Grid, rules)
+		if err == nil {
+			continue
+		}
+		bad++
+		if ve, ok := err.(*validate.Error); ok {
+			for _, p := range ve.Problems {
+				fmt.Fprintf(os.Stderr, "%v: %v\n", ng.Name, p)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", ng.Name, err)
+		}
+	}
+	if bad > 0 {
+		return fmt.Errorf("%v of %v grids invalid", bad, len(list))
+	}
+	lib.Logger.Info("valid", "grids", len(list))
+	return nil
+}
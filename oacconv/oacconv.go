@@ -0,0 +1,562 @@
+package main
+
+/*
+* 統一的轉換程式, 取代各資料集各自的 main()
+* oacconv <子命令> [參數] ...
+* 資料集由 lib/dataset 註冊, 所有子命令共用相同的下載/輸出參數
+*/
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+	"github.com/OAC-TW/oac-opendata-converters/lib/config"
+	"github.com/OAC-TW/oac-opendata-converters/lib/contour"
+	"github.com/OAC-TW/oac-opendata-converters/lib/dataset"
+	"github.com/OAC-TW/oac-opendata-converters/lib/metrics"
+	"github.com/OAC-TW/oac-opendata-converters/lib/s3"
+	"github.com/OAC-TW/oac-opendata-converters/lib/sftp"
+	"github.com/OAC-TW/oac-opendata-converters/lib/webhook"
+)
+
+type command struct {
+	Name string
+	Args string // 位置參數說明
+	Short string
+	Run func(fs *flag.FlagSet, args []string) error
+	Flags func(fs *flag.FlagSet)
+}
+
+var commands = []*command{
+	cmdFetch,
+	cmdConvert,
+	cmdInspect,
+	cmdValidate,
+	cmdServe,
+}
+
+// 使用方式錯誤, 印出子命令說明
+var errUsage = errors.New("usage")
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		if len(os.Args) > 2 {
+			if cmd := findCommand(os.Args[2]); cmd != nil {
+				newFlagSet(cmd).Usage()
+				return
+			}
+		}
+		usage()
+		return
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "oacconv: unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	fs := newFlagSet(cmd)
+	fs.Parse(os.Args[2:])
+	if err := lib.SetupLog(lib.Verbosity, logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, "oacconv:", err)
+		os.Exit(2)
+	}
+	err := cmd.Run(fs, fs.Args())
+	if err == errUsage {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		lib.Logger.Error("failed", "cmd", cmd.Name, "err", err)
+		os.Exit(1)
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	cmd.Flags(fs)
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "%v\n\nusage: oacconv %v [flags] %v\n\nflags:\n", cmd.Short, cmd.Name, cmd.Args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func usage() {
+	w := os.Stderr
+	fmt.Fprintf(w, "usage: oacconv <command> [flags] [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10v %v\n", cmd.Name, cmd.Short)
+	}
+	fmt.Fprintf(w, "\ndatasets:\n")
+	for _, id := range dataset.List() {
+		ds, _ := dataset.Get(id)
+		fmt.Fprintf(w, "  %-12v %v\n", id, ds.Description())
+	}
+	fmt.Fprintf(w, "\nrun 'oacconv help <command>' for the flags of each command\n")
+}
+
+// ==== 共用參數 ====
+
+func addNetFlags(fs *flag.FlagSet) {
+	addProxyFlag(fs)
+	fs.Int("timeout", 10, "connect timeout in Seconds (TCP and proxy handshake)")
+	fs.Int("tls-timeout", 10, "TLS handshake timeout in Seconds")
+	fs.Int("max-time", 600, "total download deadline in Seconds, including retries")
+	fs.Int("retry", 3, "download retries on network error, truncated data or 5xx (resume with Range when supported)")
+	fs.Int("max-size", 512, "max download size in MB, 0: no limit")
+	fs.String("auth", "", "open data token (授權碼), env OAC_TOKEN or OAC_TOKEN_FILE")
+	fs.String("u", "", "url, %v: token (without %v: send token in Authorization header), default: dataset source")
+	fs.String("ua", "OAC bot", "User-Agent")
+}
+
+// convert 沒有下載, 上傳 (hook, S3, SFTP) 仍經由 proxy
+func addProxyFlag(fs *flag.FlagSet) {
+	fs.String("x", "", "proxy URL socks5://[user:pass@]host:port, http://... or https://... (host:port: socks5), also used for uploads, env OAC_PROXY, HTTPS_PROXY/HTTP_PROXY")
+}
+
+func addOutFlags(fs *flag.FlagSet) {
+	fs.String("dir", ".", "output dir")
+	fs.Bool("nc", false, "also output NetCDF-3 (.nc)")
+	fs.String("contour", "", "contour levels, name:level,...;name:level,... (海表溫度:20,22,24,26,28,30)")
+	fs.String("partial", "skip", "time with missing/broken files: skip, partial (publish available variables) or abort")
+	fs.String("hook", "", "web hook URL, post every output file after writing, env OAC_HOOK or OAC_HOOK_FILE")
+	fs.String("hook-ca", "", "CA certificate (PEM) for web hook TLS, default system CAs")
+	fs.Int("hook-retry", 3, "web hook retries on network error or 5xx")
+	fs.String("s3", "", "S3 compatible storage, https://endpoint/bucket/prefix/, env OAC_S3 (key: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY)")
+	fs.String("s3-region", "", "S3 region, env AWS_REGION (default \"us-east-1\")")
+	fs.String("s3-ca", "", "CA certificate (PEM) for S3 TLS, default system CAs")
+	fs.String("s3-cache-control", "", "Cache-Control of uploaded files except index.json (no-cache) (default \""+s3.DefaultCacheControl+"\")")
+	fs.Bool("s3-gzip", false, "gzip json/geojson before upload (Content-Encoding: gzip)")
+	fs.Int("s3-retry", 3, "S3 retries on network error or 5xx")
+	fs.String("sftp", "", "SFTP target sftp://user@host[:port]/dir, env OAC_SFTP")
+	fs.String("sftp-key", "", "SSH private key file for -sftp, env OAC_SFTP_KEY")
+	fs.String("sftp-known-hosts", "", "known_hosts file for -sftp (default ~/.ssh/known_hosts)")
+}
+
+var logFormat string
+
+func addVerbosity(fs *flag.FlagSet) {
+	fs.IntVar(&lib.Verbosity, "v", 3, "verbosity for app (1 error, 2 warn, 3 info, 4 debug)")
+	fs.StringVar(&logFormat, "log", "text", "log format, text or json")
+}
+
+func addCommonFlags(fs *flag.FlagSet) {
+	fs.String("config", "", "config file (JSON), default env "+config.EnvConfig)
+	fs.String("textfile", "", "write Prometheus metrics to this file (node_exporter textfile collector, *.prom)")
+	addVerbosity(fs)
+}
+
+// 參數 > 設定檔
+func textfilePath(fs *flag.FlagSet, cfg *config.Config) string {
+	if fp := fs.Lookup("textfile").Value.String(); fp != "" {
+		return fp
+	}
+	return cfg.Textfile
+}
+
+// 成功或失敗都寫, 才看得到 oac_runs_total{result="error"}
+func writeTextfile(fs *flag.FlagSet, cfg *config.Config) {
+	fp := textfilePath(fs, cfg)
+	if fp == "" {
+		return
+	}
+	if err := metrics.WriteTextfile(fp); err != nil {
+		lib.Logger.Error("write metrics textfile failed", "file", fp, "err", err)
+	}
+}
+
+// 參數名 >> 設定欄位, set == false 時寫入所有參數的預設值
+// set == true 時只寫入有指定的參數 (flag.Visit), 0 或空白也覆蓋, 不經過 Merge (空白視為沒有設定)
+func applyFlags(s *config.Settings, fs *flag.FlagSet, set bool) {
+	apply := func(f *flag.Flag) {
+		v := f.DefValue
+		if set {
+			v = f.Value.String()
+		}
+		switch f.Name {
+		case "u":
+			s.URL = v
+		case "auth":
+			s.Token, s.TokenFile = v, ""
+		case "x":
+			s.Proxy = v
+		case "timeout":
+			s.Timeout, _ = strconv.Atoi(v)
+		case "tls-timeout":
+			s.TLSTimeout, _ = strconv.Atoi(v)
+		case "max-time":
+			s.MaxTime, _ = strconv.Atoi(v)
+		case "retry":
+			n, _ := strconv.Atoi(v)
+			s.Retry = &n
+		case "max-size":
+			n, _ := strconv.Atoi(v)
+			s.MaxSize = &n
+		case "ua":
+			s.UA = v
+		case "dir":
+			s.Dir = v
+		case "nc":
+			b := v == "true"
+			s.NetCDF = &b
+		case "contour":
+			s.Contour = v
+		case "partial":
+			s.Partial = v
+		case "hook":
+			s.Hook, s.HookFile = v, ""
+		case "hook-ca":
+			s.HookCA = v
+		case "hook-retry":
+			n, _ := strconv.Atoi(v)
+			s.HookRetry = &n
+		case "s3":
+			s.S3 = v
+		case "s3-region":
+			s.S3Region = v
+		case "s3-ca":
+			s.S3CA = v
+		case "s3-cache-control":
+			s.S3CacheControl = v
+		case "s3-gzip":
+			b := v == "true"
+			s.S3Gzip = &b
+		case "s3-retry":
+			n, _ := strconv.Atoi(v)
+			s.S3Retry = &n
+		case "sftp":
+			s.SFTP = v
+		case "sftp-key":
+			s.SFTPKey = v
+		case "sftp-known-hosts":
+			s.SFTPKnownHosts = v
+		}
+	}
+	if set {
+		fs.Visit(apply)
+	} else {
+		fs.VisitAll(apply)
+	}
+}
+
+func loadConfig(fs *flag.FlagSet) (*config.Config, error) {
+	return config.LoadDefault(fs.Lookup("config").Value.String())
+}
+
+// 預設值 < 設定檔 < 環境變數 < 有指定的參數
+func settingsFor(fs *flag.FlagSet, cfg *config.Config, id string) (*config.Settings, error) {
+	s := &config.Settings{}
+	applyFlags(s, fs, false)
+	s.Merge(cfg.For(id))
+	s.Merge(config.FromEnv())
+	applyFlags(s, fs, true)
+	err := s.ResolveSecrets()
+	if err != nil {
+		return nil, err
+	}
+	lib.AddSecret(s.Token)
+	lib.AddSecretURL(s.Hook)
+	lib.AddSecret(s.HookSecret)
+	lib.AddSecret(s.S3SecretKey)
+	// 順便登記 proxy 密碼
+	if _, err := lib.ParseProxy(s.Proxy); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func fetchOptions(s *config.Settings) *dataset.FetchOptions {
+	lib.UA = s.UA
+	retry, size := 3, 512
+	if s.Retry != nil {
+		retry = *s.Retry
+	}
+	if s.MaxSize != nil {
+		size = *s.MaxSize
+	}
+	return &dataset.FetchOptions{
+		URL: s.URL,
+		Token: s.Token,
+		Dial: dialer(s),
+		TLSTimeout: time.Duration(s.TLSTimeout) * time.Second,
+		Total: time.Duration(s.MaxTime) * time.Second,
+		Retries: retry,
+		MaxSize: int64(size) * 1024 * 1024,
+	}
+}
+
+func output(s *config.Settings) (*dataset.Output, error) {
+	levels, err := contour.ParseSpec(s.Contour)
+	if err != nil {
+		return nil, err
+	}
+	partial, err := dataset.ParsePartial(s.Partial)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(s.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &dataset.Output{
+		Dir: s.Dir,
+		NetCDF: s.NetCDF != nil && *s.NetCDF,
+		Contours: levels,
+		Partial: partial,
+	}, nil
+}
+
+// 下載及所有上傳共用, 有 proxy (或 HTTP(S)_PROXY) 時經由 proxy; convert 沒有 -timeout 時用 10 秒
+func dialer(s *config.Settings) lib.DialFunc {
+	timeout := time.Duration(s.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	return lib.NewDialFunc(s.Proxy, timeout)
+}
+
+// 多檔輸出的上傳目標: 各檔案, 最後 index (空白: 沒有), 本地已移除的舊檔
+type publisher func(dir string, files []string, index string, removed []string) error
+
+// 只有明確指定 hook / s3 / sftp 才上傳, 都沒有時回傳 nil
+func newPublishers(s *config.Settings) ([]publisher, error) {
+	var pubs []publisher
+	if s.Hook != "" {
+		retry := 3
+		if s.HookRetry != nil {
+			retry = *s.HookRetry
+		}
+		hook, err := webhook.New(&webhook.Options{
+			URL: s.Hook,
+			Secret: s.HookSecret,
+			CAFile: s.HookCA,
+			Retries: retry,
+			Timeout: 60 * time.Second,
+			Dial: dialer(s),
+		})
+		if err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, hook.PostDir)
+	}
+	if s.S3 != "" {
+		retry := 3
+		if s.S3Retry != nil {
+			retry = *s.S3Retry
+		}
+		bucket, err := s3.New(&s3.Options{
+			URL: s.S3,
+			Region: s.S3Region,
+			AccessKey: s.S3AccessKey,
+			SecretKey: s.S3SecretKey,
+			CAFile: s.S3CA,
+			CacheControl: s.S3CacheControl,
+			Gzip: s.S3Gzip != nil && *s.S3Gzip,
+			Retries: retry,
+			Timeout: 60 * time.Second,
+			Dial: dialer(s),
+		})
+		if err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, bucket.PutDir)
+	}
+	if s.SFTP != "" {
+		target, err := sftp.New(&sftp.Options{
+			URL: s.SFTP,
+			KeyFile: s.SFTPKey,
+			KnownHosts: s.SFTPKnownHosts,
+			Stale: dataset.IsFrameFile,
+			Dial: dialer(s),
+		})
+		if err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, target.PutDir)
+	}
+	return pubs, nil
+}
+
+// 有 index.json 時最後上傳, 並刪除已移除的舊檔; 各目標互不影響, 回傳全部的錯誤
+// 全部成功才刪除本地的舊檔, 失敗時下次執行再通知刪除
+// 上傳完才記錄這次執行成功或失敗 (oac_runs_total)
+func post(ds dataset.Dataset, pubs []publisher, out *dataset.Output, files []string) error {
+	index := ""
+	for _, fn := range files {
+		if fn == dataset.IndexName {
+			index = fn
+		}
+	}
+	var errs []error
+	for _, pub := range pubs {
+		if err := pub(out.Dir, files, index, out.Removed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	err := errors.Join(errs...)
+	if err == nil {
+		dataset.RemoveStale(out)
+	}
+	dataset.RecordRun(ds, err)
+	return err
+}
+
+// 需要授權碼的資料集
+func checkToken(ds dataset.Dataset, opt *dataset.FetchOptions) error {
+	if opt.Token == "" && opt.URL == "" && strings.Contains(ds.SourceURL(), "%v") {
+		return errors.New("dataset " + ds.ID() + " needs a token (-auth, OAC_TOKEN, OAC_TOKEN_FILE or config)")
+	}
+	return nil
+}
+
+// ==== fetch ====
+
+var cmdFetch = &command{
+	Name: "fetch",
+	Args: "DATASET",
+	Short: "download the latest data and convert it",
+}
+
+var fetchSave *string
+
+func init() {
+	cmdFetch.Flags = func(fs *flag.FlagSet) {
+		addNetFlags(fs)
+		addOutFlags(fs)
+		fetchSave = fs.String("save", "", "also keep the downloaded raw file")
+		addCommonFlags(fs)
+	}
+	cmdFetch.Run = runFetch
+}
+
+func runFetch(fs *flag.FlagSet, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	ds, err := dataset.Get(args[0])
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	s, err := settingsFor(fs, cfg, ds.ID())
+	if err != nil {
+		return err
+	}
+	opt := fetchOptions(s)
+	if err := checkToken(ds, opt); err != nil {
+		return err
+	}
+	out, err := output(s)
+	if err != nil {
+		return err
+	}
+	pubs, err := newPublishers(s)
+	if err != nil {
+		return err
+	}
+
+	defer writeTextfile(fs, cfg)
+	out = dataset.WithRun(ds, out)
+	raw, err := dataset.Fetch(ds, opt, out)
+	if err != nil {
+		return err
+	}
+	if *fetchSave != "" {
+		err = ioutil.WriteFile(*fetchSave, raw, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	files, err := dataset.Convert(ds, raw, out)
+	if err != nil {
+		return err
+	}
+	return post(ds, pubs, out, files)
+}
+
+// ==== convert ====
+
+var cmdConvert = &command{
+	Name: "convert",
+	Args: "DATASET FILE",
+	Short: "convert a local raw file (XML, ZIP...)",
+}
+
+func init() {
+	cmdConvert.Flags = func(fs *flag.FlagSet) {
+		addProxyFlag(fs)
+		addOutFlags(fs)
+		addCommonFlags(fs)
+	}
+	cmdConvert.Run = runConvert
+}
+
+func runConvert(fs *flag.FlagSet, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	ds, err := dataset.Get(args[0])
+	if err != nil {
+		return err
+	}
+	raw, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	s, err := settingsFor(fs, cfg, ds.ID())
+	if err != nil {
+		return err
+	}
+	out, err := output(s)
+	if err != nil {
+		return err
+	}
+	pubs, err := newPublishers(s)
+	if err != nil {
+		return err
+	}
+
+	defer writeTextfile(fs, cfg)
+	out = dataset.WithRun(ds, out)
+	out.Manifest.Source.File = args[1]
+	files, err := dataset.Convert(ds, raw, out)
+	if err != nil {
+		return err
+	}
+	return post(ds, pubs, out, files)
+}
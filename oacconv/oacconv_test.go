@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib/config"
+	"github.com/OAC-TW/oac-opendata-converters/lib/dataset"
+	"github.com/OAC-TW/oac-opendata-converters/lib/metrics"
+)
+
+// 上傳失敗也要算在 oac_runs_total{result="error"}, 成功才更新時間
+func TestPostRecordsRun(t *testing.T) {
+	ds, err := dataset.Get("M-B0071-000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &dataset.Output{Dir: t.TempDir()}
+	var called []string
+	ok := func(dir string, files []string, index string, removed []string) error {
+		called = append(called, "ok")
+		return nil
+	}
+	fail := func(dir string, files []string, index string, removed []string) error {
+		called = append(called, "fail")
+		return errors.New("upload failed")
+	}
+
+	if err := post(ds, []publisher{fail, ok}, out, []string{"a.json"}); err == nil {
+		t.Fatal("no error from failed publisher")
+	}
+	if len(called) != 2 {
+		t.Errorf("publishers called %v, want both", called)
+	}
+	text := metricsText(t)
+	if !strings.Contains(text, `oac_runs_total{dataset="M-B0071-000",result="error"} 1`) {
+		t.Errorf("upload failure not counted:\n%v", text)
+	}
+	if strings.Contains(text, `oac_last_success_timestamp_seconds{dataset="M-B0071-000"}`) {
+		t.Errorf("success time set on failure:\n%v", text)
+	}
+
+	if err := post(ds, []publisher{ok}, out, []string{"a.json"}); err != nil {
+		t.Fatal(err)
+	}
+	text = metricsText(t)
+	if !strings.Contains(text, `oac_runs_total{dataset="M-B0071-000",result="ok"} 1`) ||
+		!strings.Contains(text, `oac_last_success_timestamp_seconds{dataset="M-B0071-000"}`) {
+		t.Errorf("success not recorded:\n%v", text)
+	}
+}
+
+// 本地舊檔等全部上傳成功才刪除, 失敗時下次執行仍會通知刪除
+func TestPostRemovesStale(t *testing.T) {
+	ds, err := dataset.Get("F-A0020-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "23123118.003.grid.json")
+	if err := os.WriteFile(stale, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := &dataset.Output{Dir: dir, Removed: []string{"23123118.003.grid.json"}}
+	var notified [][]string
+	ok := func(dir string, files []string, index string, removed []string) error {
+		notified = append(notified, removed)
+		return nil
+	}
+	fail := func(dir string, files []string, index string, removed []string) error {
+		return errors.New("upload failed")
+	}
+
+	if err := post(ds, []publisher{ok, fail}, out, []string{dataset.IndexName}); err == nil {
+		t.Fatal("no error from failed publisher")
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("stale file removed after failed upload: %v", err)
+	}
+	if err := post(ds, []publisher{ok}, out, []string{dataset.IndexName}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale file kept after upload: %v", err)
+	}
+	if len(notified) != 2 || len(notified[1]) != 1 {
+		t.Errorf("delete lists %v", notified)
+	}
+
+	// 沒有上傳目標時直接刪除
+	os.WriteFile(stale, []byte("old"), 0644)
+	if err := post(ds, nil, out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale file kept without publishers: %v", err)
+	}
+}
+
+func metricsText(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := metrics.Default.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// 參數 > 環境變數 > 設定檔 (資料集區段 > 全域) > 預設值; 有指定的參數即使是 0 或空白也覆蓋
+func TestSettingsPrecedence(t *testing.T) {
+	five, one := 5, 1
+	file := &config.Config{
+		Settings: config.Settings{Proxy: "socks5://file.lan", Timeout: 20, Retry: &five, Dir: "file", TokenFile: "missing-token.txt"},
+		Datasets: map[string]*config.Settings{
+			"F-A0020-001": {Timeout: 30, Retry: &one},
+		},
+	}
+	proxyEnv := map[string]string{"OAC_PROXY": "http://env.lan"}
+	cases := []struct {
+		name string
+		cfg *config.Config
+		id string
+		env map[string]string
+		args []string
+		check func(s *config.Settings) bool
+	}{
+		{"default", &config.Config{}, "", nil, nil, func(s *config.Settings) bool {
+			return s.Timeout == 10 && *s.Retry == 3 && s.Proxy == "" && s.Dir == "." && s.MaxTime == 600
+		}},
+		{"file", file, "", nil, []string{"-auth", "CWA-x"}, func(s *config.Settings) bool {
+			return s.Proxy == "socks5://file.lan" && s.Timeout == 20 && *s.Retry == 5 && s.Dir == "file" && s.MaxTime == 600
+		}},
+		{"dataset section", file, "F-A0020-001", nil, []string{"-auth", "CWA-x"}, func(s *config.Settings) bool {
+			return s.Timeout == 30 && *s.Retry == 1 && s.Proxy == "socks5://file.lan"
+		}},
+		{"env over file", file, "", proxyEnv, []string{"-auth", "CWA-x"}, func(s *config.Settings) bool {
+			return s.Proxy == "http://env.lan"
+		}},
+		{"flag over env", file, "", proxyEnv, []string{"-auth", "CWA-x", "-x", "http://flag.lan"}, func(s *config.Settings) bool {
+			return s.Proxy == "http://flag.lan"
+		}},
+		{"empty flag over env", file, "", proxyEnv, []string{"-auth", "CWA-x", "-x", ""}, func(s *config.Settings) bool {
+			return s.Proxy == ""
+		}},
+		{"zero timeout over file", file, "F-A0020-001", nil, []string{"-auth", "CWA-x", "-timeout", "0"}, func(s *config.Settings) bool {
+			return s.Timeout == 0
+		}},
+		{"zero retry over file", file, "F-A0020-001", nil, []string{"-auth", "CWA-x", "-retry", "0"}, func(s *config.Settings) bool {
+			return *s.Retry == 0
+		}},
+		{"false and empty over file", file, "", nil, []string{"-auth", "CWA-x", "-nc=false", "-dir", ""}, func(s *config.Settings) bool {
+			return s.NetCDF != nil && !*s.NetCDF && s.Dir == ""
+		}},
+		// 授權碼檔不存在, 沒有被參數覆蓋時會讀檔失敗
+		{"token flag over token file", file, "", map[string]string{"OAC_TOKEN_FILE": "missing-env-token.txt"}, []string{"-auth", "CWA-flag"}, func(s *config.Settings) bool {
+			return s.Token == "CWA-flag" && s.TokenFile == ""
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, k := range []string{"OAC_TOKEN", "OAC_TOKEN_FILE", "OAC_PROXY", "OAC_HOOK", "OAC_HOOK_FILE", "OAC_S3", "OAC_SFTP"} {
+				t.Setenv(k, tc.env[k])
+			}
+			fs := newFlagSet(cmdFetch)
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			s, err := settingsFor(fs, tc.cfg, tc.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.check(s) {
+				t.Errorf("settings %+v", s)
+			}
+		})
+	}
+}
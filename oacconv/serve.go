@@ -0,0 +1,143 @@
+package main
+
+/*
+* serve: 常駐, 定時下載/轉換, 並以HTTP提供輸出資料夾
+* 多個資料集時各自輸出到 dir/DATASET/ (設定檔內資料集有指定 dir 時用該設定)
+* 沒有指定資料集時用設定檔內列出的資料集
+*/
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+	"github.com/OAC-TW/oac-opendata-converters/lib/dataset"
+	"github.com/OAC-TW/oac-opendata-converters/lib/metrics"
+)
+
+var cmdServe = &command{
+	Name: "serve",
+	Args: "[DATASET...]",
+	Short: "fetch and convert periodically, serve the output dir and /metrics over HTTP",
+}
+
+func init() {
+	cmdServe.Flags = func(fs *flag.FlagSet) {
+		addNetFlags(fs)
+		addOutFlags(fs)
+		fs.String("l", "", "HTTP listen addr for output dir (:8080), empty: no HTTP")
+		fs.Duration("interval", time.Hour, "fetch interval")
+		addCommonFlags(fs)
+	}
+	cmdServe.Run = runServe
+}
+
+type serveJob struct {
+	ds dataset.Dataset
+	opt *dataset.FetchOptions
+	out *dataset.Output
+	pubs []publisher
+}
+
+func runServe(fs *flag.FlagSet, args []string) error {
+	cfg, err := loadConfig(fs)
+	if err != nil {
+		return err
+	}
+	ids := args
+	if len(ids) == 0 {
+		for id := range cfg.Datasets {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+	if len(ids) == 0 {
+		return errUsage
+	}
+
+	// 參數 > 設定檔
+	listen := cfg.Listen
+	interval := time.Hour
+	if cfg.Interval != "" {
+		interval, err = time.ParseDuration(cfg.Interval)
+		if err != nil {
+			return err
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "l":
+			listen = f.Value.String()
+		case "interval":
+			interval = f.Value.(flag.Getter).Get().(time.Duration)
+		}
+	})
+
+	base, err := settingsFor(fs, cfg, "")
+	if err != nil {
+		return err
+	}
+
+	jobs := make([]*serveJob, 0, len(ids))
+	for _, id := range ids {
+		ds, err := dataset.Get(id)
+		if err != nil {
+			return err
+		}
+		s, err := settingsFor(fs, cfg, id)
+		if err != nil {
+			return err
+		}
+		own, ok := cfg.Datasets[id]
+		if len(ids) > 1 && !(ok && own.Dir != "") {
+			s.Dir = filepath.Join(s.Dir, id)
+		}
+		opt := fetchOptions(s)
+		if err := checkToken(ds, opt); err != nil {
+			return err
+		}
+		out, err := output(s)
+		if err != nil {
+			return err
+		}
+		pubs, err := newPublishers(s)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, &serveJob{ds, opt, out, pubs})
+	}
+
+	if listen != "" {
+		if err := os.MkdirAll(base.Dir, 0755); err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/", http.FileServer(http.Dir(base.Dir)))
+		mux.Handle("/metrics", metrics.Handler())
+		go func() {
+			lib.Logger.Info("listen", "addr", listen, "dir", base.Dir)
+			err := http.ListenAndServe(listen, mux)
+			lib.Logger.Error("http server stopped", "err", err)
+		}()
+	}
+
+	for {
+		for _, job := range jobs {
+			out := dataset.WithRun(job.ds, job.out)
+			files, err := dataset.Run(job.ds, job.opt, out)
+			if err != nil {
+				lib.Logger.Error("run failed", "dataset", job.ds.ID(), "err", err)
+				continue
+			}
+			if err := post(job.ds, job.pubs, out, files); err != nil {
+				lib.Logger.Error("post failed", "dataset", job.ds.ID(), "err", err)
+			}
+		}
+		writeTextfile(fs, cfg)
+		time.Sleep(interval)
+	}
+}
@@ -3,84 +3,183 @@ package main
 /*
 * 中央氣象局open data
 * 海流模式-海流數值模式預報資料-第000小時
-* https://opendata.cwb.gov.tw/dataset/climate/M-B0071-000
+* https://opendata.cwa.gov.tw/dataset/climate/M-B0071-000
+* 舊網址 opendata.cwb.gov.tw 也可用 (-u)
 * 將2D經緯度資料轉為1D-array
 * 經緯度 7, 119 >> 7, 126; 7.1, 119 >> 7.1, 126; .... ; 36, 126
 */
 
 import (
+	"errors"
 	"flag"
 	"log"
 	"time"
-	"fmt"
 
 	"io"
 	"os"
+	"sort"
 	"strings"
-	"strconv"
-	"errors"
 
 	"encoding/json"
-	"encoding/xml"
-	"math"
+	"net/http"
 
 	"bytes"
-	"crypto/tls"
-	"mime/multipart"
-	"net"
-	"net/http"
 	"io/ioutil"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+	"github.com/OAC-TW/oac-opendata-converters/lib/config"
+	"github.com/OAC-TW/oac-opendata-converters/lib/contour"
+	"github.com/OAC-TW/oac-opendata-converters/lib/cwbxml"
+	"github.com/OAC-TW/oac-opendata-converters/lib/dataset"
+	"github.com/OAC-TW/oac-opendata-converters/lib/metrics"
+	"github.com/OAC-TW/oac-opendata-converters/lib/netcdf"
+	"github.com/OAC-TW/oac-opendata-converters/lib/validate"
+	"github.com/OAC-TW/oac-opendata-converters/lib/webhook"
 )
 
 var (
-	inFile = flag.String("i", "M-B0071-000.xml", "input XML file")
+	inFile = flag.String("i", "M-B0071-000.xml", "input XML file (with -local)")
+	local = flag.Bool("local", false, "convert -i instead of downloading")
 	outFile = flag.String("o", "M-B0071-000.grid.json", "output file")
 
-	proxyAddr = flag.String("x", "", "socks5 proxy addr (127.0.0.1:5005)")
-	connTimeout = flag.Int("timeout", 10, "connect timeout in Seconds")
+	proxyAddr = flag.String("x", "", "proxy URL socks5://[user:pass@]host:port, http://... or https://... (host:port: socks5), also used for web hook, default env HTTPS_PROXY/HTTP_PROXY")
+	connTimeout = flag.Int("timeout", 10, "connect timeout in Seconds (TCP and proxy handshake)")
+	tlsTimeout = flag.Int("tls-timeout", 10, "TLS handshake timeout in Seconds")
+	maxTime = flag.Int("max-time", 600, "total download deadline in Seconds, including retries")
+	retry = flag.Int("retry", 3, "download retries on network error, truncated data or 5xx (resume with Range when supported)")
+	maxSize = flag.Int("max-size", 512, "max download size in MB, 0: no limit")
 
-	token = flag.String("auth", "CWB-XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX", "token") // 氣象局open data的API授權碼
-	url = flag.String("u", "https://opendata.cwb.gov.tw/fileapi/v1/opendataapi/M-B0071-000?Authorization=%v&downloadType=WEB&format=XML", "url")
+	token = flag.String("auth", "", "token, default env OAC_TOKEN or OAC_TOKEN_FILE") // 氣象署open data的API授權碼
+	url = flag.String("u", "https://opendata.cwa.gov.tw/fileapi/v1/opendataapi/M-B0071-000?Authorization=%v&downloadType=WEB&format=XML", "url, %v: token (without %v: send token in Authorization header)")
 	UA = flag.String("ua", "OAC bot", "User-Agent")
 
-	verbosity = flag.Int("v", 3, "verbosity for app")
+	verbosity = flag.Int("v", 3, "verbosity for app (1 error, 2 warn, 3 info, 4 debug)")
+	logFormat = flag.String("log", "text", "log format, text or json")
+	textfile = flag.String("textfile", "", "write Prometheus metrics to this file (node_exporter textfile collector, *.prom)")
+
+	hookUrl = flag.String("hook", "", "web hook URL, default env OAC_HOOK or OAC_HOOK_FILE")
+	hookCA = flag.String("hook-ca", "", "CA certificate (PEM) for web hook TLS, default system CAs")
+	hookRetry = flag.Int("hook-retry", 3, "web hook retries on network error or 5xx")
+	hookSecret string // HMAC-SHA256 簽章, env OAC_HOOK_SECRET or OAC_HOOK_SECRET_FILE
 
-	hookUrl = flag.String("hook", "http://127.0.0.1:8080/api/push/89HuRzqCRlRGIrhSifYN", "web hook URL")
+	ncOut = flag.Bool("nc", false, "also output NetCDF-3 (.nc)")
+	contourSpec = flag.String("contour", "", "contour levels, name:level,...;name:level,... (海表溫度:20,22,24,26,28,30)")
+	mappingFile = flag.String("mapping", "", "XML element mapping JSON (default builtin M-B0071-000)")
+
+	// 等值線輸出檔名用
+	varTag = map[string]string{
+		"X": "u",
+		"Y": "v",
+		"海表溫度": "sst",
+		"海高": "ssh",
+		"海表鹽度": "sss",
+	}
+	contourLevels map[string][]float64
+	mapping *cwbxml.Mapping
+
+	// metrics 的 dataset label, 與 oacconv 相同
+	ds = &dataset.MB0071{}
 )
 
 func main() {
 	flag.Parse()
+	if err := lib.SetupLog(*verbosity, *logFormat); err != nil {
+		log.Fatalln(err)
+	}
+	lib.UA = *UA
 
-	if *token == "" {
-		transFile(*inFile, *outFile)
+	// 成功或失敗都寫 -textfile; 下載失敗已由 RecordFetch 記錄
+	ok, fetchFailed := false, false
+	defer func() {
+		if !fetchFailed {
+			var err error
+			if !ok {
+				err = errors.New("run failed")
+			}
+			dataset.RecordRun(ds, err)
+		}
+		writeTextfile()
+	}()
+
+	levels, err := contour.ParseSpec(*contourSpec)
+	if err != nil {
+		Vln(2, "[contour]spec err", err)
 		return
 	}
+	for k, _ := range levels {
+		if _, ok := varTag[k]; !ok {
+			Vln(2, "[contour]unknown variable", k)
+			return
+		}
+	}
+	contourLevels = levels
 
-	aurl := fmt.Sprintf(*url, *token)
-	dialFunc := func(network, address string) (net.Conn, error) {
-		return net.DialTimeout("tcp", address, time.Duration(*connTimeout) * time.Second)
+	if *mappingFile != "" {
+		mapping, err = cwbxml.LoadMapping(*mappingFile)
+	} else {
+		mapping, err = cwbxml.Builtin("M-B0071-000")
 	}
-	if *proxyAddr != "" {
-		dialFunc = func(network, address string) (net.Conn, error) {
-			if network != "tcp" {
-				return nil, errors.New("only support tcp")
-			}
-			return makeConnection(address, *proxyAddr, time.Duration(*connTimeout) * time.Second)
-		}
+	if err != nil {
+		Vln(2, "[mapping]err", err)
+		return
 	}
 
-	fd, err := getUrlFd(aurl, dialFunc)
+	// 機密不寫死在程式內
+	if *token == "" {
+		*token, err = config.EnvSecret("OAC_TOKEN")
+	}
+	if err == nil && *hookUrl == "" {
+		*hookUrl, err = config.EnvSecret("OAC_HOOK")
+	}
+	if err == nil {
+		hookSecret, err = config.EnvSecret("OAC_HOOK_SECRET")
+	}
 	if err != nil {
-		Vln(2, "[get]err", aurl, err)
+		Vln(2, "[config]err", err)
 		return
 	}
-	defer fd.Close()
+	lib.AddSecret(*token)
+	lib.AddSecretURL(*hookUrl)
+	lib.AddSecret(hookSecret)
+
+	// -local: 由現有檔案轉換, 與下載相同會送 -hook
+	var in io.Reader
+	if *local {
+		fd, err := os.Open(*inFile)
+		if err != nil {
+			Vln(2, "[open]err", *inFile, err)
+			return
+		}
+		defer fd.Close()
+		in = fd
+	} else {
+		if *token == "" {
+			Vln(2, "[config]err", "no token, set -auth or OAC_TOKEN (use -local to convert -i)")
+			return
+		}
+		aurl, header := lib.AuthRequest(*url, *token)
+		Vln(3, "[get]start download...", aurl)
+
+		// 與 oceanwave-proc 相同: 中斷時重試/續傳, 連線/TLS/整次下載分開計時
+		start := time.Now()
+		data, err := lib.Download(aurl, downloadOptions(header))
+		dataset.RecordFetch(ds, len(data), time.Since(start), err)
+		if err != nil {
+			fetchFailed = true
+			Vln(2, "[get]err", aurl, err)
+			return
+		}
+		Vln(3, "[get]download end", len(data))
+		in = bytes.NewReader(data)
+	}
 
-	grid, err := parseXML(fd)
+	start := time.Now()
+	grid, err := parseXML(in)
 	if err != nil {
 		Vln(2, "[parse]err", err)
 		return
 	}
+	dataset.RecordGrid(ds, grid, time.Since(start))
 	Vln(3, "[grid]", grid.Nx, grid.Ny)
 
 	var buf bytes.Buffer
@@ -91,10 +190,39 @@ func main() {
 	}
 	Vln(3, "[json]ok")
 
+	contours := makeContours(grid, *outFile)
+	if *ncOut {
+		fn, data, err := makeNetCDF(grid, *outFile)
+		if err != nil {
+			Vln(2, "[nc]err", err)
+		} else {
+			contours[fn] = data
+		}
+	}
+
 	if *hookUrl != "" {
-		postUrl(*hookUrl, *outFile, &buf)
+		hook, err := newHook()
+		if err != nil {
+			Vln(2, "[post]err", err)
+			return
+		}
+		_, err = hook.PostFile(*outFile, &buf)
+		if err != nil {
+			Vln(2, "[post]err", *outFile, err)
+			return
+		}
 		Vln(3, "[post]", *hookUrl)
+		for fn, data := range contours {
+			_, err = hook.PostFile(fn, bytes.NewReader(data))
+			if err != nil {
+				Vln(2, "[post]err", fn, err)
+				return
+			}
+			Vln(3, "[post]", *hookUrl, fn)
+		}
 	} else {
+		writeContours(contours)
+
 		of, err := os.OpenFile(*outFile, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
 		if err != nil {
 			Vln(2, "[open]err", *outFile, err)
@@ -108,434 +236,121 @@ func main() {
 			return
 		}
 	}
+	ok = true
 }
 
-func transFile(inFp string, outFp string) {
-	fd, err := os.OpenFile(inFp, os.O_CREATE|os.O_RDONLY, 0400)
-	if err != nil {
-		Vln(2, "[open]err", *inFile, err)
-		return
-	}
-	defer fd.Close()
-
-	grid, err := parseXML(fd)
-	if err != nil {
-		Vln(2, "[parse]err", err)
+func writeTextfile() {
+	if *textfile == "" {
 		return
 	}
-	Vln(3, "[grid]", grid.Nx, grid.Ny)
-
-	of, err := os.OpenFile(outFp, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
-		Vln(2, "[open]err", *outFile, err)
-		return
-	}
-	defer of.Close()
-
-	enc := json.NewEncoder(of)
-	err = enc.Encode(grid)
-	if err != nil {
-		Vln(2, "[json]err", err)
-	}
-}
-
-func getUrl(url string, dialFunc func(network, addr string) (net.Conn, error)) ([]byte, error) {
-	resBody, err := getUrlFd(url, dialFunc)
-	if err != nil {
-		return nil, err
-	}
-	defer resBody.Close()
-
-	data, err := ioutil.ReadAll(resBody)
-	if err != nil {
-		return nil, err
-	}
-	return data, nil
-}
-
-func getUrlFd(url string, dialFunc func(network, addr string) (net.Conn, error)) (io.ReadCloser, error) {
-	var netTransport = &http.Transport{
-		Dial: dialFunc,
-		TLSHandshakeTimeout: time.Duration(*connTimeout) * time.Second,
-	}
-
-	var netClient = &http.Client{
-		Timeout: time.Second * 180,
-		Transport: netTransport,
+	if err := metrics.WriteTextfile(*textfile); err != nil {
+		Vln(2, "[metrics]write err", *textfile, err)
 	}
-
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Set("Connection", "close")
-	req.Header.Set("User-Agent", *UA)
-	req.Close = true
-	res, err := netClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	return res.Body, nil
 }
 
-func postUrl(url string, fileName string, data io.Reader) ([]byte, error) {
-	var netTransport = &http.Transport{
-		Dial: (&net.Dialer{
-			Timeout: 5 * time.Second,
-		}).Dial,
-		TLSHandshakeTimeout: 5 * time.Second,
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-
-	var netClient = &http.Client{
-		Timeout: time.Second * 60,
-		Transport: netTransport,
-	}
-
-	var b bytes.Buffer
-	w := multipart.NewWriter(&b)
-	fw, err := w.CreateFormFile("file", fileName)
+// 單一時間的NetCDF: M-B0071-000.nc
+func makeNetCDF(grid *lib.VectorGrid, outFp string) (string, []byte, error) {
+	t, err := grid.ParseTime()
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
-	_, err = io.Copy(fw, data)
+	nc, err := netcdf.FromGrids([]*lib.VectorGrid{grid}, []time.Time{t})
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
-	// Don't forget to close the multipart writer.
-	// If you don't close it, your request will be missing the terminating boundary.
-	w.Close()
-
-	req, err := http.NewRequest("POST", url, nil)
-	req.Header.Set("Connection", "close")
-	req.Header.Set("User-Agent", *UA)
-	req.Header.Set("Content-Type", w.FormDataContentType())
-	req.Close = true
-	//req.Body = ioutil.NopCloser(data)
-	req.Body = ioutil.NopCloser(&b)
-	res, err := netClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-
-	ret, err := ioutil.ReadAll(res.Body)
+	var buf bytes.Buffer
+	err = nc.Write(&buf)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
-	return ret, nil
-}
-
-
-type VectorGrid struct {
-	// 原點 經度, 緯度
-	Lo1 float32 `json:"lo1"`
-	La1 float32 `json:"la1"`
-
-	// 終點 經度, 緯度
-	Lo2 float32 `json:"lo2"`
-	La2 float32 `json:"la2"`
-
-	Nx int `json:"nx"` // 經度格數
-	Ny int `json:"ny"` // 緯度格數
-
-	Time string `json:"time"` // just copy now
-	Desc string `json:"Description"`  // just copy
-
-	DataRange map[string][]jsonFloat `json:"drange"`
-
-	Data map[string][]jsonFloat `json:"d"`
+	fn := strings.TrimSuffix(strings.TrimSuffix(outFp, ".grid.json"), ".json") + ".nc"
+	Vln(3, "[nc]", fn, buf.Len())
+	return fn, buf.Bytes(), nil
 }
 
-type jsonFloat float32
-func (value jsonFloat) MarshalJSON() ([]byte, error) {
-	if math.IsNaN(float64(value)) {
-		return []byte("\"\""), nil
+// 每個變數一個GeoJSON: M-B0071-000.sst.geojson
+func makeContours(grid *lib.VectorGrid, outFp string) map[string][]byte {
+	out := make(map[string][]byte, len(contourLevels) + 1)
+	if len(contourLevels) == 0 {
+		return out
 	}
-	return []byte(fmt.Sprintf("%v", value)), nil
-}
-
-func NewVectorGrid() *VectorGrid {
-	vg := &VectorGrid{}
-	vg.Data = make(map[string][]jsonFloat, 2)
-	vg.DataRange = make(map[string][]jsonFloat, 2)
-	return vg
-}
-
-func parseXML(r io.Reader) (*VectorGrid, error) {
-	grid := NewVectorGrid()
-
-	ps := &procState{}
-	xs := NewXMLState()
-	decoder := xml.NewDecoder(r)
-	for {
-		token, err := decoder.Token()
+	base := strings.TrimSuffix(outFp, ".grid.json")
+	for k, levels := range contourLevels {
+		fc, err := contour.Build(grid, k, levels)
 		if err != nil {
-			if err == io.EOF {
-				return grid, nil
-			}
-			return grid, err
+			Vln(2, "[contour]err", k, err)
+			continue
 		}
-
-		switch t := token.(type) {
-		case xml.StartElement:
-			stelm := xml.StartElement(t)
-			//Vln(5, "start: ", stelm.Name.Local)
-			xs.StartTag(stelm)
-
-		case xml.EndElement:
-			endelm := xml.EndElement(t)
-			//Vln(5, "end: ", endelm.Name.Local)
-			xs.EndTag(endelm)
-
-		case xml.CharData:
-			data := xml.CharData(t)
-			ps.FillTag(xs, data, grid)
-
-			//str := string(data)
-			//Vln(5, "[val]", xs.GetPath(), str)
+		buf, err := json.Marshal(fc)
+		if err != nil {
+			Vln(2, "[contour]json err", k, err)
+			continue
 		}
+		fn := base + "." + varTag[k] + ".geojson"
+		Vln(3, "[contour]", fn, len(fc.Features))
+		out[fn] = buf
 	}
-
-	return grid, nil
-}
-
-type procState struct {
-	st int
-	valName string
-
-	lat float32
-	lon float32
-	lat0 float32
-	lon0 float32
-	lat1 float32
-	lon1 float32
+	return out
 }
-func (ps *procState) FillTag(xs *XmlState, data []byte, grid *VectorGrid) {
-	switch ps.st {
-	case 0:
-		path := xs.GetPath()
-		switch path {
-		case "cwbopendata/dataset/datasetInfo/datasetDescription":
-			str := string(data)
-			Vln(3, "[desc]", path, str)
-			grid.Desc = str
-		case "cwbopendata/dataset/datasetInfo/parameterSet/parameter/parameterName":
-			Vln(3, "[n?]", path, string(data))
-		case "cwbopendata/dataset/datasetInfo/parameterSet/parameter/parameterValue":
-			str := string(data)
-			Vln(3, "[ny]", path, str)
-			if v, err := strconv.ParseUint(str, 10, 32); err == nil {
-				grid.Ny = int(v)
-			}
-		case "cwbopendata/dataset/time/datetime":
-			str := string(data)
-			Vln(3, "[time]", path, str)
-			grid.Time = str
-		case "cwbopendata/dataset/location":
-			ps.st = 1 // start parse grids
-			ps.lat0 = 9999
-			ps.lon0 = 9999
-			ps.lat1 = -9999
-			ps.lon1 = -9999
-		}
-	case 1:
-		tag := xs.LastPath()
-		switch tag {
-		case "lat": // 緯度
-			if v, err := strconv.ParseFloat(string(data), 32); err == nil {
-				ps.lat = float32(v)
-				if ps.lat < ps.lat0 {
-					ps.lat0 = ps.lat
-				}
-				if ps.lat > ps.lat1 {
-					ps.lat1 = ps.lat
-				}
-			}
-		case "lon": // 經度
-			if v, err := strconv.ParseFloat(string(data), 32); err == nil {
-				ps.lon = float32(v)
-				if ps.lon < ps.lon0 {
-					ps.lon0 = ps.lon
-				}
-				if ps.lon > ps.lon1 {
-					ps.lon1 = ps.lon
-				}
-			}
-		case "elementName":
-			str := string(data)
-			switch str {
-			case "橫向流速":
-				ps.valName = "X"
-				//Vln(7, "[pos]", ps.lat, ps.lon)
-			case "直向流速":
-				ps.valName = "Y"
-			case "海表溫度", "海高", "海表鹽度":
-				ps.valName = str
-			default:
-				ps.valName = ""
-			}
-		case "value":
-			if ps.valName == "" {
-				break
-			}
-			arr, ok := grid.Data[ps.valName]
-			if !ok {
-				arr = make([]jsonFloat, 0, grid.Ny)
-			}
-			if v, err := strconv.ParseFloat(string(data), 32); err == nil {
-				arr = append(arr, jsonFloat(v))
-				grid.Data[ps.valName] = arr
-
-				if !math.IsNaN(v) {
-					// minimum and maximum
-					minMax, ok := grid.DataRange[ps.valName]
-					if !ok {
-						minMax = []jsonFloat{jsonFloat(v), jsonFloat(v)}
-						grid.DataRange[ps.valName] = minMax
-					}
-					if v < float64(minMax[0]) {
-						minMax[0] = jsonFloat(v)
-					}
-					if v > float64(minMax[1]) {
-						minMax[1] = jsonFloat(v)
-					}
-				}
-			}
-		case "cwbopendata": // end dataset
-			ps.st = 2
-			grid.Nx = len(grid.Data["X"]) / grid.Ny
 
-			// min >> max
-			grid.Lo1 = ps.lon0
-			grid.Lo2 = ps.lon1
-
-			// max >> min
-			grid.La1 = ps.lat1
-			grid.La2 = ps.lat0
-
-			for k, arr := range grid.Data {
-				grid.Data[k] = transT(arr, grid.Ny)
-			}
-
-			Vln(3, "[grid]", ps.lat, ps.lon, len(grid.Data["X"]), len(grid.Data["Y"]), len(grid.Data["海表溫度"]), len(grid.Data["海高"]), len(grid.Data["海表鹽度"]))
+func writeContours(list map[string][]byte) {
+	for fn, buf := range list {
+		err := ioutil.WriteFile(fn, buf, 0644)
+		if err != nil {
+			Vln(2, "[contour]write err", fn, err)
 		}
 	}
 }
 
-func transT(in []jsonFloat, stride int) []jsonFloat {
-	sz := len(in)
-	stride2 := sz / stride
-	out := make([]jsonFloat, sz, sz)
-	for i, v := range in {
-		a := i / stride
-		b := i % stride
-		idx := a + b * stride2
-		out[idx] = v
-	}
-	return out
-}
-
-type XmlState struct {
-	Path []string
-}
-
-func NewXMLState() *XmlState {
-	xs := &XmlState{}
-	xs.Path = make([]string, 0, 32)
-	return xs
-}
-
-func (xs *XmlState) StartTag(t xml.StartElement) {
-	xs.Path = append(xs.Path, string(t.Name.Local))
-}
-
-func (xs *XmlState) EndTag(t xml.EndElement) {
-	sz := len(xs.Path)
-	xs.Path = xs.Path[:sz-1]
-}
-
-func (xs *XmlState) GetPath() string {
-	return strings.Join(xs.Path, "/")
-}
-
-func (xs *XmlState) LastPath() string {
-	sz := len(xs.Path) - 1
-	if sz < 0 {
-		return ""
+// 與下載相同, 有 -x (或 HTTP(S)_PROXY) 時經由 proxy
+// 下載參數: 連線/TLS/整次下載分開計時
+func downloadOptions(header http.Header) *lib.DownloadOptions {
+	return &lib.DownloadOptions{
+		Header: header,
+		Dial: lib.NewDialFunc(*proxyAddr, time.Duration(*connTimeout) * time.Second),
+		TLSTimeout: time.Duration(*tlsTimeout) * time.Second,
+		Total: time.Duration(*maxTime) * time.Second,
+		Retries: *retry,
+		MaxSize: int64(*maxSize) * 1024 * 1024,
 	}
-	return xs.Path[sz]
 }
 
-func (xs *XmlState) PathLevel() int {
-	return len(xs.Path)
+func newHook() (*webhook.Client, error) {
+	return webhook.New(&webhook.Options{
+		URL: *hookUrl,
+		Secret: hookSecret,
+		CAFile: *hookCA,
+		Retries: *hookRetry,
+		Timeout: 60 * time.Second,
+		Dial: lib.NewDialFunc(*proxyAddr, time.Duration(*connTimeout) * time.Second),
+	})
 }
 
 
-// ==== proxy ====
-func makeConnection(targetAddr string, socksAddr string, timeout time.Duration) (net.Conn, error) {
-
-	host, portStr, err := net.SplitHostPort(targetAddr)
-	if err != nil {
-		Vln(2, "SplitHostPort err:", targetAddr, err)
-		return nil, err
-	}
-	port, err := strconv.Atoi(portStr)
+// 檢查不通過時不輸出 (mapping 內的變數都必須有)
+func parseXML(r io.Reader) (*lib.VectorGrid, error) {
+	grid, err := cwbxml.Parse(r, mapping)
 	if err != nil {
-		Vln(2, "failed to parse port number:", portStr, err)
 		return nil, err
 	}
-	if port < 1 || port > 0xffff {
-		Vln(2, "port number out of range:", portStr, err)
-		return nil, err
+	rules := &validate.Rules{}
+	for _, el := range mapping.Elements {
+		rules.Required = append(rules.Required, el.Key)
 	}
-
-	socksReq := []byte{0x05, 0x01, 0x00, 0x03}
-	socksReq = append(socksReq, byte(len(host)))
-	socksReq = append(socksReq, host...)
-	socksReq = append(socksReq, byte(port>>8), byte(port))
-
-
-	conn, err := net.DialTimeout("tcp", socksAddr, timeout)
-	if err != nil {
-		Vln(2, "connect to ", socksAddr, err)
-		return nil, err
-	}
-
-	var b [10]byte
-
-	// send request
-	conn.Write([]byte{0x05, 0x01, 0x00})
-
-	// read reply
-	_, err = conn.Read(b[:2])
+	sort.Strings(rules.Required)
+	err = validate.Check(grid, rules)
 	if err != nil {
 		return nil, err
 	}
-
-	// send server addr
-	conn.Write(socksReq)
-
-	// read reply
-	n, err := conn.Read(b[:10])
-	if n < 10 {
-		Vln(2, "Dial err replay:", targetAddr, "via", socksAddr, n)
-		return nil, err
-	}
-	if err != nil || b[1] != 0x00 {
-		Vln(2, "Dial err:", targetAddr, "via", socksAddr, n, b[1], err)
-		return nil, err
-	}
-
-	return conn, nil
+	return grid, nil
 }
 
+
 // ==== log ====
 func Vf(level int, format string, v ...interface{}) {
-	if level <= *verbosity {
-		log.Printf(format, v...)
-	}
+	lib.Vf(level, format, v...)
 }
 func Vln(level int, v ...interface{}) {
-	if level <= *verbosity {
-		log.Println(v...)
-	}
+	lib.Vln(level, v...)
 }
 
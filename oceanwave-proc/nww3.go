@@ -0,0 +1,72 @@
+package main
+
+/*
+* NWW3 (WAVEWATCH III) 波浪模式 GRIB2
+* 浪高(HTSGW), 尖峰週期(PWPER, 沒有時用主波平均週期PERPW), 主波向(DIRPW) 轉為與 F-A0020-001 相同的輸出 (lib/dataset NWW3)
+* 檔名: 模式起始時間(UTC) yyMMddHH + 預報小時數, 例: 20081200.003.grid.json
+*/
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+	"github.com/OAC-TW/oac-opendata-converters/lib/dataset"
+	"github.com/OAC-TW/oac-opendata-converters/lib/grib2"
+)
+
+func readGribAndExtract(srcList string, dirOut string) error {
+	var bbox grib2.BBox
+	if *gribBBox != "" {
+		for _, str := range strings.Split(*gribBBox, ",") {
+			x, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+			if err != nil {
+				return fmt.Errorf("bad bbox %q: %v", *gribBBox, err)
+			}
+			bbox = append(bbox, x)
+		}
+	}
+
+	ds := &dataset.NWW3{BBox: bbox}
+
+	// 各檔案接在一起解碼, 與 F-A0020-001 相同檢查、依 -partial 處理缺少參數的時間、寫 manifest.json
+	raw := make([]byte, 0, 1 << 20)
+	for _, src := range strings.Split(srcList, ",") {
+		if src = strings.TrimSpace(src); src == "" {
+			continue
+		}
+		buf, err := readSource(ds, src)
+		if err != nil {
+			Vln(2, "[grib2]read err", src, err)
+			return err
+		}
+		Vln(3, "[grib2]", src, len(buf))
+		raw = append(raw, buf...)
+	}
+	if len(raw) == 0 {
+		return fmt.Errorf("no GRIB2 file")
+	}
+
+	out := dataset.WithRun(ds, newOutput(dirOut))
+	out.Manifest.Source.File = lib.Redact(srcList)
+	files, err := dataset.Convert(ds, raw, out)
+	if err != nil {
+		return err
+	}
+	return publishAndClean(ds, out, files)
+}
+
+// 本地檔案或 http(s) URL
+func readSource(ds dataset.Dataset, src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(src)
+	}
+	Vln(3, "[get]start download...", src)
+	start := time.Now()
+	buf, err := lib.Download(src, downloadOptions(nil))
+	dataset.RecordFetch(ds, len(buf), time.Since(start), err)
+	return buf, err
+}
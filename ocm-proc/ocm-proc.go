@@ -0,0 +1,292 @@
+package main
+
+/*
+* 中央氣象局 OCM 海流模式資料 (NetCDF)
+* http://med.cwb.gov.tw/opendap/OCM/contents.html
+* 讀取 UCURR/VCURR/SST/SALT/WL 等 nc 檔, 依時間合併後轉為與 oceanwave-proc 相同格式的輸出
+* 沒有指定輸入檔時, 透過 OPeNDAP (DAP2) 只抓需要的時間/範圍
+* 不需要 netcdf.dll / libnetcdf
+*/
+
+import (
+	"flag"
+	"log"
+	"time"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+
+	"encoding/json"
+
+	"github.com/OAC-TW/oac-opendata-converters/lib"
+	"github.com/OAC-TW/oac-opendata-converters/lib/dap"
+	"github.com/OAC-TW/oac-opendata-converters/lib/netcdf"
+)
+
+var (
+	inFiles = flag.String("i", "", "input NetCDF files, separated by ',' (also accept file list in args)")
+	outDir = flag.String("dir", "json/", "path to save output file")
+
+	dapUrl = flag.String("u", "http://med.cwb.gov.tw/opendap/hyrax/OCM/%[1]v/00/9999/%[2]v.%[1]v00.nc.nc", "OPeNDAP dataset url, %[1]v: date, %[2]v: variable")
+	runDate = flag.String("date", "", "model run date yyyyMMdd (default today in UTC)")
+	dapVars = flag.String("vars", "UCURR,VCURR,SST,SALT,WL", "variables to fetch by OPeNDAP")
+	timeRange = flag.String("time", "0:-1", "time index range, start:end (-1 for last)")
+	bbox = flag.String("bbox", "", "minLon,minLat,maxLon,maxLat (default all)")
+
+	proxyAddr = flag.String("x", "", "proxy URL socks5://[user:pass@]host:port, http://... or https://... (host:port: socks5), default env HTTPS_PROXY/HTTP_PROXY")
+	connTimeout = flag.Int("timeout", 10, "connect timeout in Seconds")
+	UA = flag.String("ua", "OAC bot", "User-Agent")
+
+	verbosity = flag.Int("v", 3, "verbosity for app (1 error, 2 warn, 3 info, 4 debug)")
+	logFormat = flag.String("log", "text", "log format, text or json")
+
+	jsonRx = regexp.MustCompile(`([0-9]{8,8})\.([0-9]{3,3})\.grid\.json`) // name for old output
+)
+
+func main() {
+	flag.Parse()
+	if err := lib.SetupLog(*verbosity, *logFormat); err != nil {
+		log.Fatalln(err)
+	}
+	lib.UA = *UA
+
+	files := make([]string, 0, 8)
+	for _, fp := range strings.Split(*inFiles, ",") {
+		if fp = strings.TrimSpace(fp); fp != "" {
+			files = append(files, fp)
+		}
+	}
+	files = append(files, flag.Args()...)
+
+	var list []*IndexFile
+	var err error
+	if len(files) == 0 {
+		list, err = fetchDAP()
+	} else {
+		list, err = loadFiles(files)
+	}
+	if err != nil {
+		Vln(2, "[ocm]err", err)
+		return
+	}
+
+	err = writeOutput(list, *outDir)
+	if err != nil {
+		Vln(2, "[json]err", err)
+		return
+	}
+	Vln(3, "[json]ok", len(list))
+}
+
+type IndexFile struct {
+	TimeUTC time.Time `json:"timeUTC"`
+	Time08  time.Time `json:"time08"`
+	Name string `json:"name"`
+
+	DataRange map[string][]lib.JsonFloat `json:"drange"`
+
+	grid *lib.VectorGrid
+}
+
+type sortByTime []*IndexFile
+func (s sortByTime) Len() int      { return len(s) }
+func (s sortByTime) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sortByTime) Less(i, j int) bool { return s[i].TimeUTC.Before(s[j].TimeUTC) }
+
+// 各檔案依時間合併, UCURR 跟 VCURR 通常分開放
+func loadFiles(files []string) ([]*IndexFile, error) {
+	byTime := make(map[int64]*IndexFile)
+	for _, fp := range files {
+		nc, err := netcdf.ReadFile(fp)
+		if err != nil {
+			Vln(2, "[nc]read err", fp, err)
+			return nil, err
+		}
+		err = mergeFile(byTime, nc, fp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sortAndName(byTime), nil
+}
+
+// 透過 OPeNDAP 抓各變數, 每個變數一個 dataset
+func fetchDAP() ([]*IndexFile, error) {
+	date := *runDate
+	if date == "" {
+		date = time.Now().UTC().Format("20060102")
+	}
+
+	sel := dap.Selection{}
+	_, err := fmt.Sscanf(*timeRange, "%d:%d", &sel.Time[0], &sel.Time[1])
+	if err != nil {
+		return nil, fmt.Errorf("bad time range %q: %v", *timeRange, err)
+	}
+	if *bbox != "" {
+		for _, s := range strings.Split(*bbox, ",") {
+			var x float64
+			if _, err := fmt.Sscan(strings.TrimSpace(s), &x); err != nil {
+				return nil, fmt.Errorf("bad bbox %q: %v", *bbox, err)
+			}
+			sel.BBox = append(sel.BBox, x)
+		}
+	}
+
+	timeout := time.Duration(*connTimeout) * time.Second
+	dialFunc := lib.NewDialFunc(*proxyAddr, timeout)
+	client := &dap.Client{
+		Get: func(aurl string) (io.ReadCloser, error) {
+			return lib.GetUrlFd(aurl, dialFunc, timeout)
+		},
+	}
+
+	byTime := make(map[int64]*IndexFile)
+	for _, code := range strings.Split(*dapVars, ",") {
+		if code = strings.TrimSpace(code); code == "" {
+			continue
+		}
+		aurl := fmt.Sprintf(*dapUrl, date, code)
+		Vln(3, "[dap]fetch", aurl)
+		nc, err := client.Subset(aurl, []string{code}, sel)
+		if err != nil {
+			Vln(2, "[dap]fetch err", code, err)
+			return nil, err
+		}
+		err = mergeFile(byTime, nc, aurl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sortAndName(byTime), nil
+}
+
+func mergeFile(byTime map[int64]*IndexFile, nc *netcdf.File, src string) error {
+	loc := time.FixedZone("UTC+8", +8*60*60)
+	grids, times, err := netcdf.ToGrids(nc)
+	if err != nil {
+		Vln(2, "[nc]convert err", src, err)
+		return err
+	}
+	Vln(3, "[nc]", src, len(grids))
+
+	for i, grid := range grids {
+		t := times[i].UTC()
+		item, ok := byTime[t.Unix()]
+		if !ok {
+			item = &IndexFile{
+				TimeUTC: t,
+				Time08: t.In(loc),
+				grid: grid,
+			}
+			byTime[t.Unix()] = item
+			continue
+		}
+		if item.grid.Nx != grid.Nx || item.grid.Ny != grid.Ny {
+			return fmt.Errorf("grid size mismatch at %v: %vx%v, %vx%v", t, item.grid.Nx, item.grid.Ny, grid.Nx, grid.Ny)
+		}
+		for k, arr := range grid.Data {
+			item.grid.Data[k] = arr
+			item.grid.DataRange[k] = grid.DataRange[k]
+			item.grid.Units[k] = grid.Units[k]
+		}
+	}
+	return nil
+}
+
+func sortAndName(byTime map[int64]*IndexFile) []*IndexFile {
+	list := make([]*IndexFile, 0, len(byTime))
+	for _, item := range byTime {
+		list = append(list, item)
+	}
+	sort.Sort(sortByTime(list))
+
+	// 檔名: 第一個時間(UTC) yyMMddHH + 距第一個時間的小時數, 與 oceanwave-proc 相同
+	if len(list) > 0 {
+		t0 := list[0].TimeUTC
+		base := t0.Format("06010215")
+		for _, item := range list {
+			offset := int(item.TimeUTC.Sub(t0) / time.Hour)
+			item.Name = fmt.Sprintf("%v.%03d.grid.json", base, offset)
+			item.DataRange = item.grid.DataRange
+		}
+	}
+	return list
+}
+
+func writeOutput(list []*IndexFile, dirOut string) error {
+	// list old file for clean up
+	oldFiles, err := readDir(dirOut)
+	if err != nil {
+		Vln(2, "[proc]list old data", err)
+		return err
+	}
+
+	for _, item := range list {
+		buf, err := json.Marshal(item.grid)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(dirOut, item.Name), buf, 0644)
+		if err != nil {
+			Vln(2, "[write]err", item.Name, err)
+			return err
+		}
+		Vln(4, "[write]", item.Name, item.grid.Nx, item.grid.Ny)
+		delete(oldFiles, item.Name)
+	}
+
+	// update index.json
+	buf, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(dirOut, "index.json"), buf, 0644)
+	if err != nil {
+		Vln(2, "[proc]update index", err)
+		return err
+	}
+
+	// remove old file for clean up
+	for name := range oldFiles {
+		fp := filepath.Join(dirOut, name)
+		err := os.Remove(fp)
+		if err != nil {
+			Vln(2, "[clean]remove file fail", fp, err)
+		}
+	}
+	return nil
+}
+
+func readDir(dirname string) (map[string]bool, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	list, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]bool, len(list))
+	for _, name := range list {
+		if jsonRx.MatchString(name) {
+			out[name] = true
+		}
+	}
+	Vln(4, "[cache]old data", dirname, len(out))
+	return out, nil
+}
+
+// ==== log ====
+func Vf(level int, format string, v ...interface{}) {
+	lib.Vf(level, format, v...)
+}
+func Vln(level int, v ...interface{}) {
+	lib.Vln(level, v...)
+}